@@ -0,0 +1,71 @@
+package main
+
+import "log"
+
+// requested language meaning "detect the script, and use languages suited to it"
+const autoLanguage = "auto"
+
+// languages to ocr each script detected by tesseract's osd with; scripts used by many
+// languages map to tesseract's script models (e.g. script/Latin.traineddata), which
+// recognize all of them. operators may override these with AUTO_SCRIPT_LANGUAGES.
+var defaultScriptLanguages = map[string]string{
+	"Arabic":     "Arabic",
+	"Armenian":   "hye",
+	"Bengali":    "ben",
+	"Cyrillic":   "Cyrillic",
+	"Devanagari": "Devanagari",
+	"Ethiopic":   "amh",
+	"Fraktur":    "Fraktur",
+	"Georgian":   "kat",
+	"Greek":      "ell",
+	"Gujarati":   "guj",
+	"Gurmukhi":   "pan",
+	"Han":        "chi_sim+chi_tra",
+	"Hangul":     "kor",
+	"Hebrew":     "heb",
+	"Japanese":   "jpn",
+	"Kannada":    "kan",
+	"Khmer":      "khm",
+	"Korean":     "kor",
+	"Lao":        "lao",
+	"Latin":      "Latin",
+	"Malayalam":  "mal",
+	"Myanmar":    "mya",
+	"Sinhala":    "sin",
+	"Tamil":      "tam",
+	"Telugu":     "tel",
+	"Thai":       "tha",
+	"Tibetan":    "bod",
+}
+
+// scriptLanguages returns the languages to use for a detected script, or the
+// default language if the script is not one we know
+func scriptLanguages(script string) string {
+	if langs, ok := config.scriptLanguages[script]; ok {
+		return langs
+	}
+
+	if langs, ok := defaultScriptLanguages[script]; ok {
+		return langs
+	}
+
+	log.Printf("no languages known for detected script [%s]; using default language [%s]", script, defaultLanguage)
+
+	return defaultLanguage
+}
+
+// detectLanguages chooses languages for an image from the script tesseract's osd
+// detected in it, falling back to the default language if detection failed (e.g.
+// because the image has too little text)
+func detectLanguages(osd *osdResult) string {
+	if osd == nil {
+		log.Printf("WARNING: language detection failed; using default language [%s]", defaultLanguage)
+		return defaultLanguage
+	}
+
+	langs := scriptLanguages(osd.script)
+
+	log.Printf("using languages [%s] for detected script [%s]", langs, osd.script)
+
+	return langs
+}
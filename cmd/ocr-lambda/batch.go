@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+)
+
+// results of one key of a batched workflow request; keys that failed (or were not
+// attempted) have an error instead of the usual workflow response fields
+type workflowBatchEntryType struct {
+	Key          string `json:"key"`
+	ResultPrefix string `json:"resultprefix,omitempty"` // s3 prefix (in bucket) this key's results were uploaded under
+	Error        string `json:"error,omitempty"`
+	*workflowResponseType
+}
+
+type workflowBatchResponseType struct {
+	Keys   []workflowBatchEntryType `json:"keys"`   // every key of the request, in order
+	Failed int                      `json:"failed"` // number of keys with an error
+}
+
+// batchKeyName returns the subprefix a batched key's results are uploaded under:
+// its file name, without extension
+func batchKeyName(key string) string {
+	name := path.Base(key)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// validateBatchKeys ensures each key of a batch refers to an image, and that
+// no two keys' results would be uploaded under the same subprefix
+func validateBatchKeys(keys []string) error {
+	names := make(map[string]string)
+
+	for _, key := range keys {
+		if err := validateSourceKey(key); err != nil {
+			return err
+		}
+
+		name := batchKeyName(key)
+		if name == "" || name == "." || name == ".." {
+			return fmt.Errorf("cannot derive a results subprefix from batch key: [%s]", key)
+		}
+
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("batch keys have the same file name: [%s] and [%s]", other, key)
+		}
+
+		names[name] = key
+	}
+
+	return nil
+}
+
+// handleWorkflowBatchRequest ocrs each key of a workflow request in turn, sharing the
+// warm lambda and any downloaded language files; each key's results are uploaded
+// under its own subprefix of the request's results prefix. some keys failing does not fail
+// the request: the response has an entry for every key, with an error for those that failed.
+// the request fails only if every key did, so that sqs and step functions see the failure.
+func handleWorkflowBatchRequest(ctx context.Context, req lambdaRequestType) (string, error) {
+	log.Printf("handling batched workflow ocr request (%d keys)", len(req.Keys))
+
+	res := workflowBatchResponseType{}
+
+	for i, key := range req.Keys {
+		entry, err := processBatchKey(ctx, req, i, key)
+		if err != nil {
+			entry.Error = err.Error()
+			entry.workflowResponseType = nil
+		}
+
+		res.Keys = append(res.Keys, entry)
+
+		if err == nil {
+			continue
+		}
+
+		res.Failed++
+
+		log.Printf("WARNING: batch key %d of %d (%s) failed: %s", i+1, len(req.Keys), key, err.Error())
+
+		// there is no time left for the rest of the batch either
+		if _, ok := err.(*workDeadlineError); ok {
+			// no key was processed, so the batch as a whole timed out
+			if i == 0 {
+				return "", err
+			}
+
+			for _, remaining := range req.Keys[i+1:] {
+				res.Keys = append(res.Keys, workflowBatchEntryType{Key: remaining, Error: "not attempted: the lambda ran out of time"})
+				res.Failed++
+			}
+
+			break
+		}
+	}
+
+	if res.Failed == len(res.Keys) {
+		return "", batchFailedError(res)
+	}
+
+	output, err := json.Marshal(res)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize output: [%s]", err.Error())
+	}
+
+	return string(output), nil
+}
+
+// batchFailedError describes a batch in which every key failed
+func batchFailedError(res workflowBatchResponseType) error {
+	var failures []string
+
+	for _, entry := range res.Keys {
+		failures = append(failures, fmt.Sprintf("%s: %s", entry.Key, entry.Error))
+	}
+
+	return fmt.Errorf("failed to process all %d batch keys: %s", len(res.Keys), strings.Join(failures, "; "))
+}
+
+// processBatchKey ocrs one key of a batched workflow request, returning its entry in the response
+func processBatchKey(ctx context.Context, req lambdaRequestType, i int, key string) (workflowBatchEntryType, error) {
+	entry := workflowBatchEntryType{Key: key}
+
+	keyReq := req.workflowRequestType
+	keyReq.Key = key
+	keyReq.Keys = nil
+
+	ocr, err := buildWorkflowOcrConfig(keyReq)
+	if err != nil {
+		return entry, err
+	}
+
+	ocr.batchName = batchKeyName(key)
+	ocr.remoteResultsPrefix = path.Join(ocr.remoteResultsPrefix, ocr.batchName)
+
+	entry.ResultPrefix = ocr.remoteResultsPrefix
+
+	log.Printf("batch key %d of %d: %s => %s", i+1, len(req.Keys), key, ocr.remoteResultsPrefix)
+
+	output, err := handleGenericOcrRequest(ctx, *ocr)
+	if err != nil {
+		return entry, err
+	}
+
+	entry.workflowResponseType = &workflowResponseType{}
+
+	if err = json.Unmarshal([]byte(output), entry.workflowResponseType); err != nil {
+		return entry, fmt.Errorf("failed to parse output for batch key: [%s]", err.Error())
+	}
+
+	return entry, nil
+}
+
+func validateWorkflowBatchRequest(req lambdaRequestType) error {
+	if req.Bucket == "" || req.Key != "" || req.ImageURL != "" {
+		return errors.New("workflow request with keys must have a bucket, and no key or imageurl")
+	}
+
+	return validateBatchKeys(req.Keys)
+}
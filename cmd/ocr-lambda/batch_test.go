@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBatchKeyName(t *testing.T) {
+	tests := map[string]string{
+		"images/page-0001.tif": "page-0001",
+		"page.tar.gz":          "page.tar",
+		"dir/noext":            "noext",
+	}
+
+	for key, want := range tests {
+		if got := batchKeyName(key); got != want {
+			t.Errorf("%s: got [%s], want [%s]", key, got, want)
+		}
+	}
+}
+
+func TestValidateBatchKeys(t *testing.T) {
+	if err := validateBatchKeys([]string{"a/page-1.tif", "a/page-2.tif"}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	if err := validateBatchKeys([]string{"a/page-1.tif", "b/page-1.jpg"}); err == nil {
+		t.Error("expected an error for colliding file names")
+	}
+}
+
+func TestWorkflowBatchPartialFailure(t *testing.T) {
+	env := useIntegrationEnv(t)
+
+	env.s3.put("bucket", "images/page-1.png", []byte(testPng))
+
+	req := lambdaRequestType{}
+	req.Pid = "uva-lib:1"
+	req.Bucket = "bucket"
+	req.Keys = []string{"images/page-1.png", "images/page-2.png"}
+
+	// only the first source image exists
+	out, err := handleWorkflowBatchRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("the request failed rather than its key: %s", err.Error())
+	}
+
+	// an embedded pointer to an unexported type cannot be decoded into
+	var res struct {
+		Keys []struct {
+			Key          string `json:"key"`
+			ResultPrefix string `json:"resultprefix"`
+			Error        string `json:"error"`
+			Text         string `json:"text"`
+		} `json:"keys"`
+		Failed int `json:"failed"`
+	}
+
+	if err = json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("failed to parse response: %s", err.Error())
+	}
+
+	if len(res.Keys) != 2 || res.Failed != 1 {
+		t.Fatalf("got %d entries, %d failed: %s", len(res.Keys), res.Failed, out)
+	}
+
+	for i, entry := range res.Keys {
+		if entry.Key != req.Keys[i] {
+			t.Errorf("entry %d is for [%s]", i, entry.Key)
+		}
+
+		if !strings.HasSuffix(entry.ResultPrefix, batchKeyName(entry.Key)) {
+			t.Errorf("entry %d has results prefix [%s]", i, entry.ResultPrefix)
+		}
+	}
+
+	if entry := res.Keys[0]; entry.Error != "" || entry.Text == "" {
+		t.Errorf("entry 0 has an error, or no response: %+v", entry)
+	}
+
+	if entry := res.Keys[1]; entry.Error == "" || entry.Text != "" {
+		t.Errorf("entry 1 has no error, or a response: %+v", entry)
+	}
+}
+
+func TestWorkflowBatchAllKeysFailed(t *testing.T) {
+	useFakeS3(t)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	req := lambdaRequestType{}
+	req.Pid = "uva-lib:1"
+	req.Bucket = "bucket"
+	req.Keys = []string{"images/page-1.tif", "images/page-2.tif"}
+
+	// neither source image exists
+	_, err := handleWorkflowBatchRequest(context.Background(), req)
+	if err == nil {
+		t.Fatal("expected the request to fail when every key did")
+	}
+
+	for _, key := range req.Keys {
+		if !strings.Contains(err.Error(), key+": ") {
+			t.Errorf("got error %q, want one with the failure of %s", err.Error(), key)
+		}
+	}
+}
+
+func TestSqsWorkflowBatchAllKeysFailed(t *testing.T) {
+	useFakeS3(t)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	body := `{"pid":"uva-lib:1","bucket":"bucket","keys":["images/page-1.tif","images/page-2.tif"]}`
+
+	req := lambdaRequestType{}
+	req.Records = []s3RecordType{{EventSource: sqsEventSource, MessageID: "message-1", Body: body}}
+
+	res, err := handleSqsOcrRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if len(res.BatchItemFailures) != 1 || res.BatchItemFailures[0].ItemIdentifier != "message-1" {
+		t.Errorf("got batch item failures %+v, want the message reported as failed", res.BatchItemFailures)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// checksum algorithms s3 can verify on upload, and how to compute each
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"CRC32":  func() hash.Hash { return crc32.NewIEEE() },
+	"CRC32C": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+}
+
+func checksumAlgorithmNames() []string {
+	return []string{"", "CRC32", "CRC32C", "SHA1", "SHA256"}
+}
+
+// fileChecksum computes the base64-encoded checksum of a file, as expected by s3
+func fileChecksum(filename, algorithm string) (string, error) {
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unsupported checksum algorithm: [%s]", algorithm)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := newHash()
+
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumRequestOption returns a request option adding the configured checksum of
+// a file to its upload, so s3 rejects the upload if the contents are corrupted.
+// files sent with multipartUpload are not covered: the sdk in use cannot include
+// part checksums when completing a multipart upload.
+func checksumRequestOption(filename string) (request.Option, error) {
+	if config.checksumAlgorithm == "" {
+		return nil, nil
+	}
+
+	checksum, err := fileChecksum(filename, config.checksumAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute checksum: [%s]", err.Error())
+	}
+
+	return request.WithSetRequestHeaders(map[string]string{
+		"x-amz-sdk-checksum-algorithm": config.checksumAlgorithm,
+		fmt.Sprintf("x-amz-checksum-%s", strings.ToLower(config.checksumAlgorithm)): checksum,
+	}), nil
+}
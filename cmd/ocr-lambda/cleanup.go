@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// text cleanup steps, applied in this order
+const (
+	cleanupNfc         = "nfc"
+	cleanupControls    = "controls"
+	cleanupDehyphenate = "dehyphenate"
+	cleanupWhitespace  = "whitespace"
+)
+
+var cleanupStepNames = []string{cleanupNfc, cleanupControls, cleanupDehyphenate, cleanupWhitespace}
+
+// a word broken across lines by a hyphen (including the soft hyphen, and the negation
+// sign fraktur models often produce for one), continuing in lower case; capitalized
+// continuations are usually hyphenated names, so are left alone
+var hyphenatedLineBreakRegex = regexp.MustCompile(`(\pL)[-\x{00AD}\x{00AC}\x{2010}]\n(\p{Ll}+)[ \t]*`)
+
+var spaceRunRegex = regexp.MustCompile(`[ \t]+`)
+var lineEdgeSpaceRegex = regexp.MustCompile(`(?m)^[ \t]+|[ \t]+$`)
+var blankLinesRegex = regexp.MustCompile(`\n{3,}`)
+
+func validateCleanupSteps(steps []string) error {
+	for _, step := range steps {
+		if !hasFormat(cleanupStepNames, step) {
+			return fmt.Errorf("unsupported text cleanup step: [%s] (must be one of: %s)", step, strings.Join(cleanupStepNames, ", "))
+		}
+	}
+
+	return nil
+}
+
+// removeControlCharacters removes control and invisible formatting characters, keeping
+// line breaks and tabs (page breaks become line breaks), and the joiners some scripts need
+func removeControlCharacters(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case r == '\f' || r == '\v':
+			return '\n'
+		case r == '\u200c' || r == '\u200d':
+			return r
+		case unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+			return -1
+		}
+
+		return r
+	}, text)
+}
+
+// dehyphenate rejoins words broken across lines, moving the rest of the word up to the first line
+func dehyphenate(text string) string {
+	return hyphenatedLineBreakRegex.ReplaceAllString(text, "$1$2\n")
+}
+
+// collapseWhitespace reduces runs of spaces to one, removes spaces around lines, and allows
+// at most one blank line between paragraphs
+func collapseWhitespace(text string) string {
+	text = spaceRunRegex.ReplaceAllString(text, " ")
+	text = lineEdgeSpaceRegex.ReplaceAllString(text, "")
+	text = blankLinesRegex.ReplaceAllString(text, "\n\n")
+
+	return strings.Trim(text, "\n") + "\n"
+}
+
+// cleanupText applies the given cleanup steps, in their fixed order
+func cleanupText(text string, steps []string) string {
+	if hasFormat(steps, cleanupNfc) {
+		text = norm.NFC.String(text)
+	}
+
+	if hasFormat(steps, cleanupControls) {
+		text = removeControlCharacters(text)
+	}
+
+	if hasFormat(steps, cleanupDehyphenate) {
+		text = dehyphenate(text)
+	}
+
+	if hasFormat(steps, cleanupWhitespace) {
+		text = collapseWhitespace(text)
+	}
+
+	return text
+}
+
+// cleanupTextFile applies the configured cleanup steps to a (utf-8) results text file
+func cleanupTextFile(textFile string) error {
+	text, err := ioutil.ReadFile(textFile)
+	if err != nil {
+		return fmt.Errorf("failed to read ocr results file: [%s]", err.Error())
+	}
+
+	if err = ioutil.WriteFile(textFile, []byte(cleanupText(string(text), config.cleanupSteps)), 0644); err != nil {
+		return fmt.Errorf("failed to save cleaned up text: [%s]", err.Error())
+	}
+
+	return nil
+}
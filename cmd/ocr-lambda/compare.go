@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// line-level comparison of ocr text with that of a previous run
+type diffStatsType struct {
+	Added         int     `json:"added"`
+	Removed       int     `json:"removed"`
+	Unchanged     int     `json:"unchanged"`
+	SimilarityPct float64 `json:"similaritypct"` // -1 if there was no previous text to compare with
+}
+
+// fetchPreviousText returns the text of an existing results file as utf-8,
+// or false if there is none
+func fetchPreviousText(bucket, key string) (string, bool, error) {
+	obj, err := newS3Client(config.resultsS3).GetObject(&s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: requestPayer(),
+	})
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to read previous results: [%s]", err.Error())
+	}
+	defer obj.Body.Close()
+
+	text, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read previous results: [%s]", err.Error())
+	}
+
+	return decodeText(text, contentTypeEncoding(aws.StringValue(obj.ContentType))), true, nil
+}
+
+// splitLines splits text into lines, ignoring a trailing newline
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(text, "\n")
+}
+
+// longestCommonSubsequence returns the number of lines common to both, in order
+func longestCommonSubsequence(a, b []string) int {
+	// only the previous row of the table is needed for the length
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i-1] == b[j-1]:
+				cur[j] = prev[j-1] + 1
+			case prev[j] >= cur[j-1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j-1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+// compareText summarizes the line changes from previous to current text
+func compareText(previous, current string) diffStatsType {
+	a := splitLines(previous)
+	b := splitLines(current)
+
+	common := longestCommonSubsequence(a, b)
+
+	stats := diffStatsType{
+		Added:         len(b) - common,
+		Removed:       len(a) - common,
+		Unchanged:     common,
+		SimilarityPct: 100,
+	}
+
+	if total := len(a) + len(b); total > 0 {
+		stats.SimilarityPct = math.Round(float64(2*common)/float64(total)*10000) / 100
+	}
+
+	return stats
+}
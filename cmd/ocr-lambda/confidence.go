@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tsv columns we need; the first line of the file is a header
+const (
+	tsvLevelColumn = 0
+	tsvConfColumn  = 10
+	tsvTextColumn  = 11
+)
+
+// tsv level of rows describing individual words
+const tsvWordLevel = "5"
+
+// word confidences of a page, as reported in tesseract's tsv output
+type pageConfidence struct {
+	words int
+	total float64
+}
+
+// tsvResultsFiles returns the tsv results of each page that was ocr'd, in page order
+func tsvResultsFiles(resultsBase string) []string {
+	candidates := []string{fmt.Sprintf("%s.tsv", resultsBase)}
+
+	for _, base := range spreadResultsBases(resultsBase) {
+		candidates = append(candidates, fmt.Sprintf("%s.tsv", base))
+	}
+
+	for _, textFile := range pageTextFiles(resultsBase) {
+		candidates = append(candidates, strings.TrimSuffix(textFile, ".txt")+".tsv")
+	}
+
+	var files []string
+
+	for _, f := range candidates {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+
+	return files
+}
+
+func parseTsvConfidence(tsvFile string) (pageConfidence, error) {
+	var page pageConfidence
+
+	f, err := os.Open(tsvFile)
+	if err != nil {
+		return page, fmt.Errorf("failed to open tsv results file: [%s]", err.Error())
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 0; scanner.Scan(); line++ {
+		if line == 0 {
+			continue
+		}
+
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) <= tsvTextColumn || cols[tsvLevelColumn] != tsvWordLevel {
+			continue
+		}
+
+		// non-word and empty entries are reported with a confidence of -1
+		conf, err := strconv.ParseFloat(cols[tsvConfColumn], 64)
+		if err != nil || conf < 0 || strings.TrimSpace(cols[tsvTextColumn]) == "" {
+			continue
+		}
+
+		page.words++
+		page.total += conf
+	}
+
+	if err = scanner.Err(); err != nil {
+		return page, fmt.Errorf("failed to read tsv results file: [%s]", err.Error())
+	}
+
+	return page, nil
+}
+
+// ocrConfidence returns the mean word confidence (0-100) over all pages, or nil if no
+// words were recognized, along with the number of words on each page
+func ocrConfidence(tsvFiles []string) (*float64, []int, error) {
+	var words int
+	var total float64
+	var pageWords []int
+
+	for _, tsvFile := range tsvFiles {
+		page, err := parseTsvConfidence(tsvFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		words += page.words
+		total += page.total
+		pageWords = append(pageWords, page.words)
+	}
+
+	if words == 0 {
+		return nil, pageWords, nil
+	}
+
+	mean := math.Round(total/float64(words)*100) / 100
+
+	return &mean, pageWords, nil
+}
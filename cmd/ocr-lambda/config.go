@@ -0,0 +1,495 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// lambda configuration, populated from environment variables at init time
+type configData struct {
+	uploadMaxRetries     int
+	uploadRetryDelayMs   int
+	downloadMaxRetries   int
+	downloadRetryDelayMs int
+	retryJitterPct       int
+	storageClass         string
+	standaloneFormats    []string
+	workflowFormats      []string
+	allowedFormats       []string
+	toolchainManifest    string
+	sourceS3             s3EndpointConfig
+	resultsS3            s3EndpointConfig
+	convertedPrefix      string
+
+	multipartThresholdMB     int
+	multipartPartSizeMB      int
+	multipartConcurrency     int
+	multipartAbandonHours    int
+	multipartStatePrefix     string
+	uploadDeadlineMarginSecs int
+	workDeadlineMarginSecs   int
+	convertTimeoutSecs       int
+	ocrTimeoutSecs           int
+	dedupIndexPrefix         string
+	tessdataMaxRetries       int
+	tessdataRetryDelayMs     int
+	tessdataTimeoutSecs      int
+	tessdataConcurrency      int
+	tessdataCacheBucket      string
+	tessdataType             string
+	tessdataVersion          string
+	tessdataURL              string
+	scriptLanguages          map[string]string
+	autoRotate               bool
+	metricsNamespace         string
+	notifyTopicArn           string
+	jobStatusTable           string
+	jobStatusTTLDays         int
+	originalImagePdf         bool
+	originalPdfQuality       int
+	cleanupSteps             []string
+	lowConfidenceThreshold   int
+	lowConfidenceRetryScale  int
+	krakenModel              string
+	visionSecret             string
+	visionEndpoint           string
+	visionTimeoutSecs        int
+	textractDailyLimit       int
+	textractUsageTable       string
+	textractMinWords         int
+	dictionaryBucket         string
+	dictionaryPrefix         string
+	tessdataCachePrefix      string
+	intermediateCompression  string
+	maxPages                 int
+	streamSourceImage        bool
+	diskSpaceMarginMB        int
+	diskSpaceReduceScale     bool
+	maxOutputMegapixels      int
+	imageURLPrefixes         []string
+	imageURLTimeoutSecs      int
+	settingsSource           string
+	settingsTTLSecs          int
+	minImageWidth            int
+	minImageHeight           int
+	resultGrantRead          string
+	checksumAlgorithm        string
+	maxCommandHistory        int
+	maxCommandOutputBytes    int
+	truncationMarker         string
+	requestPayer             string
+	allowedCommands          []string
+	archiveSourcePrefix      string
+	precacheLanguages        []string
+	precacheTimeoutSecs      int
+	cleanupIntermediates     bool
+}
+
+var config configData
+
+func envString(name, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+func envInt(name string, defaultValue int) int {
+	value := envString(name, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid integer value for %s: [%s]", name, value)
+	}
+
+	return i
+}
+
+func envNonNegativeInt(name string, defaultValue int) int {
+	i := envInt(name, defaultValue)
+	if i < 0 {
+		log.Fatalf("value for %s must not be negative: [%d]", name, i)
+	}
+
+	return i
+}
+
+func envBool(name string, defaultValue bool) bool {
+	value := envString(name, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("invalid boolean value for %s: [%s]", name, value)
+	}
+
+	return b
+}
+
+// envS3Endpoint reads s3 client settings for a specific purpose (e.g. S3_SOURCE_ENDPOINT),
+// falling back to the general settings (e.g. S3_ENDPOINT) for anything not overridden
+func envS3Endpoint(purpose string) s3EndpointConfig {
+	base := s3EndpointConfig{
+		endpoint:       envString("S3_ENDPOINT", ""),
+		forcePathStyle: envBool("S3_FORCE_PATH_STYLE", false),
+		useAccelerate:  envBool("S3_USE_ACCELERATE", false),
+	}
+
+	e := s3EndpointConfig{
+		endpoint:       envString(fmt.Sprintf("S3_%s_ENDPOINT", purpose), base.endpoint),
+		forcePathStyle: envBool(fmt.Sprintf("S3_%s_FORCE_PATH_STYLE", purpose), base.forcePathStyle),
+		useAccelerate:  envBool(fmt.Sprintf("S3_%s_USE_ACCELERATE", purpose), base.useAccelerate),
+	}
+
+	if err := e.validate(); err != nil {
+		log.Fatalf("invalid %s s3 configuration: %s", strings.ToLower(purpose), err.Error())
+	}
+
+	return e
+}
+
+func envChoice(name, defaultValue string, choices []string) string {
+	value := envString(name, defaultValue)
+
+	for _, choice := range choices {
+		if value == choice {
+			return value
+		}
+	}
+
+	log.Fatalf("invalid value for %s: [%s] (must be one of: %s)", name, value, strings.Join(choices, ", "))
+
+	return ""
+}
+
+// envList reads a comma-separated list, ignoring empty entries
+func envList(name, defaultValue string) []string {
+	var list []string
+
+	for _, item := range strings.Split(envString(name, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func envFormats(name, defaultValue string) []string {
+	formats, err := normalizeFormats(strings.Split(envString(name, defaultValue), ","))
+	if err != nil {
+		log.Fatalf("invalid value for %s: %s", name, err.Error())
+	}
+
+	return formats
+}
+
+// envGrantee converts an s3 canonical user id or email address into an s3 grant header value
+func envGrantee(name string) string {
+	value := envString(name, "")
+
+	switch {
+	case value == "":
+		return ""
+
+	case strings.ContainsAny(value, "\"= "):
+		log.Fatalf("invalid value for %s: [%s] (must be a canonical user id or email address)", name, value)
+
+	case strings.Contains(value, "@"):
+		return fmt.Sprintf("emailAddress=\"%s\"", value)
+	}
+
+	return fmt.Sprintf("id=\"%s\"", value)
+}
+
+// envScriptLanguages reads a comma-separated list of script=languages pairs,
+// e.g. "Latin=eng+fra,Cyrillic=rus+ukr"
+func envScriptLanguages(name string) map[string]string {
+	langs := make(map[string]string)
+
+	for _, item := range envList(name, "") {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" || strings.TrimSpace(pair[1]) == "" {
+			log.Fatalf("invalid value for %s: [%s] (must be script=languages)", name, item)
+		}
+
+		langs[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+
+	return langs
+}
+
+func loadConfig() {
+	config.uploadMaxRetries = envNonNegativeInt("S3_UPLOAD_MAX_RETRIES", 3)
+	config.uploadRetryDelayMs = envNonNegativeInt("S3_UPLOAD_RETRY_DELAY_MS", 500)
+	config.downloadMaxRetries = envNonNegativeInt("S3_DOWNLOAD_MAX_RETRIES", 3)
+	config.downloadRetryDelayMs = envNonNegativeInt("S3_DOWNLOAD_RETRY_DELAY_MS", 500)
+	config.retryJitterPct = envNonNegativeInt("S3_RETRY_JITTER_PCT", 20)
+
+	if config.retryJitterPct > 100 {
+		log.Fatalf("value for S3_RETRY_JITTER_PCT must be at most 100: [%d]", config.retryJitterPct)
+	}
+
+	config.storageClass = envChoice("S3_RESULT_STORAGE_CLASS", s3.StorageClassStandard, []string{
+		s3.StorageClassStandard,
+		s3.StorageClassStandardIa,
+		s3.StorageClassOnezoneIa,
+		s3.StorageClassIntelligentTiering,
+		storageClassGlacierIr,
+	})
+
+	config.allowedFormats = envFormats("OCR_ALLOWED_FORMATS", strings.Join(supportedFormats, ","))
+	config.standaloneFormats = envFormats("OCR_STANDALONE_FORMATS", "hocr,pdf")
+	config.workflowFormats = envFormats("OCR_WORKFLOW_FORMATS", "hocr")
+
+	config.toolchainManifest = envString("OCR_TOOLCHAIN_MANIFEST", "")
+	config.convertedPrefix = envString("OCR_CONVERTED_PREFIX", "internal/converted")
+	config.multipartThresholdMB = envNonNegativeInt("S3_MULTIPART_THRESHOLD_MB", 64)
+	config.multipartPartSizeMB = envNonNegativeInt("S3_MULTIPART_PART_SIZE_MB", 16)
+	config.multipartConcurrency = envNonNegativeInt("S3_MULTIPART_CONCURRENCY", 4)
+	config.multipartAbandonHours = envNonNegativeInt("S3_MULTIPART_ABANDON_HOURS", 24)
+	config.multipartStatePrefix = envString("OCR_UPLOAD_STATE_PREFIX", "internal/uploads")
+	config.uploadDeadlineMarginSecs = envNonNegativeInt("S3_UPLOAD_DEADLINE_MARGIN_SECS", 30)
+	config.workDeadlineMarginSecs = envNonNegativeInt("WORK_DEADLINE_MARGIN_SECS", 60)
+	config.convertTimeoutSecs = envNonNegativeInt("CONVERT_TIMEOUT_SECS", 0)
+	config.ocrTimeoutSecs = envNonNegativeInt("OCR_TIMEOUT_SECS", 0)
+
+	// s3 requires parts of at least 5 MB (other than the last)
+	if config.multipartPartSizeMB < 5 {
+		log.Fatalf("value for S3_MULTIPART_PART_SIZE_MB must be at least 5: [%d]", config.multipartPartSizeMB)
+	}
+
+	if config.multipartConcurrency < 1 {
+		log.Fatalf("value for S3_MULTIPART_CONCURRENCY must be at least 1: [%d]", config.multipartConcurrency)
+	}
+
+	config.dedupIndexPrefix = envString("OCR_DEDUP_INDEX_PREFIX", "")
+	config.tessdataMaxRetries = envNonNegativeInt("TESSDATA_MAX_RETRIES", 3)
+	config.tessdataRetryDelayMs = envNonNegativeInt("TESSDATA_RETRY_DELAY_MS", 1000)
+	config.tessdataTimeoutSecs = envNonNegativeInt("TESSDATA_DOWNLOAD_TIMEOUT_SECS", 60)
+	config.tessdataConcurrency = envNonNegativeInt("TESSDATA_DOWNLOAD_CONCURRENCY", 4)
+
+	if config.tessdataConcurrency < 1 {
+		log.Fatalf("value for TESSDATA_DOWNLOAD_CONCURRENCY must be at least 1: [%d]", config.tessdataConcurrency)
+	}
+
+	config.tessdataType = envChoice("TESSDATA_TYPE", "fast", tessdataTypes)
+	config.tessdataVersion = envString("TESSDATA_VERSION", "4.0.0")
+	config.tessdataURL = strings.TrimSuffix(envString("TESSDATA_URL", "https://github.com/tesseract-ocr"), "/")
+	config.scriptLanguages = envScriptLanguages("AUTO_SCRIPT_LANGUAGES")
+	config.autoRotate = envBool("AUTO_ROTATE", false)
+	config.metricsNamespace = envString("METRICS_NAMESPACE", "")
+	config.notifyTopicArn = envString("NOTIFY_TOPIC_ARN", "")
+	config.jobStatusTable = envString("JOB_STATUS_TABLE", "")
+	config.jobStatusTTLDays = envInt("JOB_STATUS_TTL_DAYS", 30)
+
+	if config.jobStatusTTLDays < 1 {
+		log.Fatalf("value for JOB_STATUS_TTL_DAYS must be at least 1: [%d]", config.jobStatusTTLDays)
+	}
+
+	config.originalImagePdf = envBool("OCR_STANDALONE_ORIGINAL_PDF", false)
+	config.originalPdfQuality = envInt("ORIGINAL_PDF_JPEG_QUALITY", 90)
+
+	if config.originalPdfQuality < 1 || config.originalPdfQuality > 100 {
+		log.Fatalf("value for ORIGINAL_PDF_JPEG_QUALITY must be between 1 and 100: [%d]", config.originalPdfQuality)
+	}
+
+	config.cleanupSteps = envList("TEXT_CLEANUP_STEPS", strings.Join(cleanupStepNames, ","))
+
+	if err := validateCleanupSteps(config.cleanupSteps); err != nil {
+		log.Fatalf("invalid value for TEXT_CLEANUP_STEPS: %s", err.Error())
+	}
+
+	// images whose mean word confidence is below the threshold (0-100; 0 disables retries)
+	// are converted again at this percentage of their first scale, and ocr'd again
+	config.lowConfidenceThreshold = envNonNegativeInt("LOW_CONFIDENCE_THRESHOLD", 0)
+	config.lowConfidenceRetryScale = envNonNegativeInt("LOW_CONFIDENCE_RETRY_SCALE_PCT", 150)
+
+	if config.lowConfidenceThreshold > 100 {
+		log.Fatalf("value for LOW_CONFIDENCE_THRESHOLD must be at most 100: [%d]", config.lowConfidenceThreshold)
+	}
+
+	if config.lowConfidenceRetryScale <= 100 {
+		log.Fatalf("value for LOW_CONFIDENCE_RETRY_SCALE_PCT must be greater than 100: [%d]", config.lowConfidenceRetryScale)
+	}
+
+	// requests may use kraken instead of tesseract if a model (e.g. in a layer) is configured
+	config.krakenModel = envString("KRAKEN_MODEL", "")
+
+	// requests may use google cloud vision if a service account key is kept in secrets manager
+	config.visionSecret = envString("VISION_CREDENTIALS_SECRET", "")
+	config.visionEndpoint = envString("VISION_ENDPOINT", "https://vision.googleapis.com/v1/images:annotate")
+	config.visionTimeoutSecs = envNonNegativeInt("VISION_TIMEOUT_SECS", 120)
+
+	// requests may fall back to textract for images tesseract finds (almost) no text in, up to
+	// a number of calls a day (0 disables it), counted in a dynamodb table keyed by "day"
+	config.textractDailyLimit = envNonNegativeInt("TEXTRACT_DAILY_LIMIT", 0)
+	config.textractUsageTable = envString("TEXTRACT_USAGE_TABLE", "")
+	config.textractMinWords = envNonNegativeInt("TEXTRACT_MIN_WORDS", 3)
+
+	if config.textractDailyLimit > 0 && config.textractUsageTable == "" {
+		log.Fatalf("TEXTRACT_USAGE_TABLE must be set when TEXTRACT_DAILY_LIMIT is")
+	}
+
+	// word lists for quality scoring, as <lang>.txt files, if configured
+	if dicts := envString("QUALITY_DICTIONARIES", ""); dicts != "" {
+		bucket, prefix, err := parseTessdataLocation(dicts, "")
+		if err != nil || bucket == "" {
+			log.Fatalf("invalid value for QUALITY_DICTIONARIES (expected s3://bucket/prefix): [%s]", dicts)
+		}
+
+		config.dictionaryBucket, config.dictionaryPrefix = bucket, prefix
+	}
+
+	// language files downloaded from github are cached in s3, if configured
+	if cache := envString("TESSDATA_CACHE", ""); cache != "" {
+		bucket, prefix, err := parseTessdataLocation(cache, "")
+		if err != nil || bucket == "" {
+			log.Fatalf("invalid value for TESSDATA_CACHE (expected s3://bucket/prefix): [%s]", cache)
+		}
+
+		config.tessdataCacheBucket, config.tessdataCachePrefix = bucket, prefix
+	}
+
+	config.intermediateCompression = envChoice("INTERMEDIATE_TIFF_COMPRESSION", "none", tiffCompressionNames)
+	config.maxPages = envNonNegativeInt("MAX_PAGES", 100)
+	config.streamSourceImage = envBool("STREAM_SOURCE_IMAGE", false)
+	config.diskSpaceMarginMB = envNonNegativeInt("DISK_SPACE_MARGIN_MB", 16)
+	config.diskSpaceReduceScale = envBool("DISK_SPACE_REDUCE_SCALE", false)
+	config.maxOutputMegapixels = envNonNegativeInt("MAX_OUTPUT_MEGAPIXELS", 0)
+	config.imageURLPrefixes = envList("IMAGE_URL_PREFIXES", "")
+	config.imageURLTimeoutSecs = envNonNegativeInt("IMAGE_URL_TIMEOUT_SECS", 300)
+	config.settingsSource = envString("OCR_SETTINGS", "")
+	config.settingsTTLSecs = envNonNegativeInt("OCR_SETTINGS_TTL_SECS", 300)
+	config.minImageWidth = envNonNegativeInt("MIN_IMAGE_WIDTH", 100)
+	config.minImageHeight = envNonNegativeInt("MIN_IMAGE_HEIGHT", 100)
+
+	// object-level grants are only honored if the results bucket's object
+	// ownership setting allows acls (i.e. is not "bucket owner enforced")
+	config.resultGrantRead = envGrantee("S3_RESULT_GRANT_READ")
+
+	config.maxCommandHistory = envNonNegativeInt("MAX_COMMAND_HISTORY", 500)
+
+	// the first and last commands are always kept
+	if config.maxCommandHistory < 2*keptCommandHistory {
+		log.Fatalf("value for MAX_COMMAND_HISTORY must be at least %d: [%d]", 2*keptCommandHistory, config.maxCommandHistory)
+	}
+
+	config.maxCommandOutputBytes = envNonNegativeInt("MAX_COMMAND_OUTPUT_BYTES", 64*1024)
+	config.truncationMarker = envString("TRUNCATION_MARKER", "…")
+
+	// the tiff tools are needed for conversion fallbacks, gs for pdf/a output, and kraken
+	// for requests that choose it
+	config.allowedCommands = envList("ALLOWED_COMMANDS", "magick,tesseract,ldd,find,ls,cp,tiffcp,tiff2rgba,gs,kraken")
+
+	config.precacheLanguages = envList("PRECACHE_LANGUAGES", "")
+	config.precacheTimeoutSecs = envNonNegativeInt("PRECACHE_TIMEOUT_SECS", 60)
+	config.cleanupIntermediates = envBool("CLEANUP_INTERMEDIATES", true)
+	config.archiveSourcePrefix = envString("ARCHIVE_SOURCE_PREFIX", "")
+	config.requestPayer = envChoice("S3_REQUEST_PAYER", "", []string{"", s3.RequestPayerRequester})
+	config.checksumAlgorithm = envChoice("S3_CHECKSUM_ALGORITHM", "", checksumAlgorithmNames())
+
+	config.sourceS3 = envS3Endpoint("SOURCE")
+	config.resultsS3 = envS3Endpoint("RESULTS")
+
+	if err := validateFormatConfig(config.standaloneFormats, config.allowedFormats); err != nil {
+		log.Fatalf("invalid value for OCR_STANDALONE_FORMATS: %s", err.Error())
+	}
+
+	if err := validateFormatConfig(config.workflowFormats, config.allowedFormats); err != nil {
+		log.Fatalf("invalid value for OCR_WORKFLOW_FORMATS: %s", err.Error())
+	}
+
+	payer := config.requestPayer
+	if payer == "" {
+		payer = "bucket owner"
+	}
+
+	log.Printf("[CONFIG] uploadMaxRetries         = [%d]", config.uploadMaxRetries)
+	log.Printf("[CONFIG] uploadRetryDelayMs       = [%d]", config.uploadRetryDelayMs)
+	log.Printf("[CONFIG] downloadMaxRetries       = [%d]", config.downloadMaxRetries)
+	log.Printf("[CONFIG] downloadRetryDelayMs     = [%d]", config.downloadRetryDelayMs)
+	log.Printf("[CONFIG] retryJitterPct           = [%d]", config.retryJitterPct)
+	log.Printf("[CONFIG] storageClass             = [%s]", config.storageClass)
+	log.Printf("[CONFIG] allowedFormats           = [%s]", strings.Join(config.allowedFormats, ","))
+	log.Printf("[CONFIG] standaloneFormats        = [%s]", strings.Join(config.standaloneFormats, ","))
+	log.Printf("[CONFIG] workflowFormats          = [%s]", strings.Join(config.workflowFormats, ","))
+	log.Printf("[CONFIG] toolchainManifest        = [%s]", config.toolchainManifest)
+	log.Printf("[CONFIG] convertedPrefix          = [%s]", config.convertedPrefix)
+	log.Printf("[CONFIG] multipartThresholdMB     = [%d]", config.multipartThresholdMB)
+	log.Printf("[CONFIG] multipartPartSizeMB      = [%d]", config.multipartPartSizeMB)
+	log.Printf("[CONFIG] multipartConcurrency     = [%d]", config.multipartConcurrency)
+	log.Printf("[CONFIG] multipartAbandonHours    = [%d]", config.multipartAbandonHours)
+	log.Printf("[CONFIG] multipartStatePrefix     = [%s]", config.multipartStatePrefix)
+	log.Printf("[CONFIG] uploadDeadlineMarginSecs = [%d]", config.uploadDeadlineMarginSecs)
+	log.Printf("[CONFIG] workDeadlineMarginSecs   = [%d]", config.workDeadlineMarginSecs)
+	log.Printf("[CONFIG] convertTimeoutSecs       = [%d]", config.convertTimeoutSecs)
+	log.Printf("[CONFIG] ocrTimeoutSecs           = [%d]", config.ocrTimeoutSecs)
+	log.Printf("[CONFIG] dedupIndexPrefix         = [%s]", config.dedupIndexPrefix)
+	log.Printf("[CONFIG] tessdataMaxRetries       = [%d]", config.tessdataMaxRetries)
+	log.Printf("[CONFIG] tessdataTimeoutSecs      = [%d]", config.tessdataTimeoutSecs)
+	log.Printf("[CONFIG] tessdataConcurrency      = [%d]", config.tessdataConcurrency)
+	log.Printf("[CONFIG] tessdataType             = [%s]", config.tessdataType)
+	log.Printf("[CONFIG] tessdataVersion          = [%s]", config.tessdataVersion)
+	log.Printf("[CONFIG] tessdataURL              = [%s]", config.tessdataURL)
+	log.Printf("[CONFIG] scriptLanguages          = [%v]", config.scriptLanguages)
+	log.Printf("[CONFIG] autoRotate               = [%t]", config.autoRotate)
+	log.Printf("[CONFIG] metricsNamespace         = [%s]", config.metricsNamespace)
+	log.Printf("[CONFIG] notifyTopicArn           = [%s]", config.notifyTopicArn)
+	log.Printf("[CONFIG] jobStatusTable           = [%s]", config.jobStatusTable)
+	log.Printf("[CONFIG] jobStatusTTLDays         = [%d]", config.jobStatusTTLDays)
+	log.Printf("[CONFIG] originalImagePdf         = [%t]", config.originalImagePdf)
+	log.Printf("[CONFIG] originalPdfQuality       = [%d]", config.originalPdfQuality)
+	log.Printf("[CONFIG] cleanupSteps             = [%s]", strings.Join(config.cleanupSteps, ","))
+	log.Printf("[CONFIG] lowConfidenceThreshold   = [%d]", config.lowConfidenceThreshold)
+	log.Printf("[CONFIG] lowConfidenceRetryScale  = [%d]", config.lowConfidenceRetryScale)
+	log.Printf("[CONFIG] krakenModel              = [%s]", config.krakenModel)
+	log.Printf("[CONFIG] visionSecret             = [%s]", config.visionSecret)
+	log.Printf("[CONFIG] visionEndpoint           = [%s]", config.visionEndpoint)
+	log.Printf("[CONFIG] visionTimeoutSecs        = [%d]", config.visionTimeoutSecs)
+	log.Printf("[CONFIG] textractDailyLimit       = [%d]", config.textractDailyLimit)
+	log.Printf("[CONFIG] textractUsageTable       = [%s]", config.textractUsageTable)
+	log.Printf("[CONFIG] textractMinWords         = [%d]", config.textractMinWords)
+	log.Printf("[CONFIG] dictionaryBucket         = [%s]", config.dictionaryBucket)
+	log.Printf("[CONFIG] dictionaryPrefix         = [%s]", config.dictionaryPrefix)
+	log.Printf("[CONFIG] tessdataCacheBucket      = [%s]", config.tessdataCacheBucket)
+	log.Printf("[CONFIG] tessdataCachePrefix      = [%s]", config.tessdataCachePrefix)
+	log.Printf("[CONFIG] tessdataRetryDelayMs     = [%d]", config.tessdataRetryDelayMs)
+	log.Printf("[CONFIG] intermediateCompression  = [%s]", config.intermediateCompression)
+	log.Printf("[CONFIG] maxPages                 = [%d]", config.maxPages)
+	log.Printf("[CONFIG] streamSourceImage        = [%t]", config.streamSourceImage)
+	log.Printf("[CONFIG] diskSpaceMarginMB        = [%d]", config.diskSpaceMarginMB)
+	log.Printf("[CONFIG] diskSpaceReduceScale     = [%t]", config.diskSpaceReduceScale)
+	log.Printf("[CONFIG] maxOutputMegapixels      = [%d]", config.maxOutputMegapixels)
+	log.Printf("[CONFIG] imageURLPrefixes         = [%s]", strings.Join(config.imageURLPrefixes, ","))
+	log.Printf("[CONFIG] imageURLTimeoutSecs      = [%d]", config.imageURLTimeoutSecs)
+	log.Printf("[CONFIG] settingsSource           = [%s]", config.settingsSource)
+	log.Printf("[CONFIG] settingsTTLSecs          = [%d]", config.settingsTTLSecs)
+	log.Printf("[CONFIG] minImageWidth            = [%d]", config.minImageWidth)
+	log.Printf("[CONFIG] minImageHeight           = [%d]", config.minImageHeight)
+	log.Printf("[CONFIG] resultGrantRead          = [%s]", config.resultGrantRead)
+	log.Printf("[CONFIG] maxCommandHistory        = [%d]", config.maxCommandHistory)
+	log.Printf("[CONFIG] maxCommandOutputBytes    = [%d]", config.maxCommandOutputBytes)
+	log.Printf("[CONFIG] truncationMarker         = [%s]", config.truncationMarker)
+
+	log.Printf("[CONFIG] allowedCommands          = [%s]", strings.Join(config.allowedCommands, ","))
+	log.Printf("[CONFIG] precacheLanguages        = [%s]", strings.Join(config.precacheLanguages, ","))
+	log.Printf("[CONFIG] precacheTimeoutSecs      = [%d]", config.precacheTimeoutSecs)
+	log.Printf("[CONFIG] cleanupIntermediates     = [%t]", config.cleanupIntermediates)
+	log.Printf("[CONFIG] archiveSourcePrefix      = [%s]", config.archiveSourcePrefix)
+	log.Printf("[CONFIG] requestPayer             = [%s]", payer)
+	log.Printf("[CONFIG] checksumAlgorithm        = [%s]", config.checksumAlgorithm)
+	log.Printf("[CONFIG] sourceS3                 = %s", config.sourceS3)
+	log.Printf("[CONFIG] resultsS3                = %s", config.resultsS3)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// known magick failure classes, matched case-insensitively against the command
+// output, and the fallback strategies worth trying for each (in order)
+type convertFailureClass struct {
+	name       string
+	patterns   []string
+	strategies []string
+}
+
+var convertFailureClasses = []convertFailureClass{
+	{
+		name:       "tiff-tags",
+		patterns:   []string{"unknown field with tag", "incorrect count for field", "invalid tiff directory"},
+		strategies: []string{"ignore-tags", "tiffcp", "tiff2rgba"},
+	},
+	{
+		name:       "tiff-compression",
+		patterns:   []string{"compression algorithm does not support", "compression scheme", "is not configured", "ojpeg", "old-style jpeg", "fax3", "fax4"},
+		strategies: []string{"tiffcp", "tiff2rgba"},
+	},
+	{
+		name:       "unrecognized-format",
+		patterns:   []string{"no decode delegate", "improper image header", "not a tiff", "negative or zero image size"},
+		strategies: []string{"jp2"},
+	},
+}
+
+// magick settings for each supported compression of the converted (intermediate) tiff
+var tiffCompressions = map[string][]string{
+	"none":    {"+compress"},
+	"lzw":     {"-compress", "LZW"},
+	"deflate": {"-compress", "Zip"},
+	"zip":     {"-compress", "Zip"},
+}
+
+var tiffCompressionNames = []string{"none", "lzw", "deflate", "zip"}
+
+// how the converted image should be produced, irrespective of how the source is decoded
+type convertParams struct {
+	scale      string   // resize percentage
+	operations []string // additional image operators applied after resizing
+}
+
+// a fallback strategy attempts the conversion in an alternate way, returning why it failed, if it did
+type convertStrategy func(localSourceImage, localConvertedImage string, params convertParams, failure string) error
+
+var convertStrategies = map[string]convertStrategy{
+	"ignore-tags": convertIgnoringTags,
+	"tiffcp":      convertViaRewrite("tiffcp", "-c", "none"),
+	"tiff2rgba":   convertViaRewrite("tiff2rgba", "-c", "none"),
+	"jp2":         convertAsJp2,
+}
+
+var unknownTagRegex = regexp.MustCompile(`(?i)tag (\d+)`)
+
+// classifyConvertFailure returns the failure class matching the magick output, if any
+func classifyConvertFailure(output string) *convertFailureClass {
+	lower := strings.ToLower(output)
+
+	for i := range convertFailureClasses {
+		for _, p := range convertFailureClasses[i].patterns {
+			if strings.Contains(lower, p) {
+				return &convertFailureClasses[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// convertArgs builds the magick arguments; settings apply to reading the input
+func convertArgs(input, output string, params convertParams, settings ...string) []string {
+	args := []string{"convert", "-units", "PixelsPerInch", "-type", "Grayscale"}
+	args = append(args, tiffCompressions[config.intermediateCompression]...)
+	args = append(args, "+repage")
+	args = append(args, settings...)
+	args = append(args, input, "-filter", "Lanczos", "-resize", fmt.Sprintf("%s%%", params.scale))
+	args = append(args, params.operations...)
+	args = append(args, output)
+
+	return args
+}
+
+func runConvert(input, output string, params convertParams, settings ...string) error {
+	if out, err := runCommand("magick", convertArgs(input, output, params, settings...)...); err != nil {
+		return fmt.Errorf("%s (%s)", err.Error(), strings.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// logConvertedSize reports the size of the converted image relative to its source,
+// to help assess the intermediate compression tradeoff
+func logConvertedSize(localSourceImage, localConvertedImage string) {
+	source, err := os.Stat(localSourceImage)
+	if err != nil {
+		return
+	}
+
+	converted, err := os.Stat(localConvertedImage)
+	if err != nil {
+		return
+	}
+
+	log.Printf("converted image size: %d bytes (%s compression); source image size: %d bytes", converted.Size(), config.intermediateCompression, source.Size())
+}
+
+func convertIgnoringTags(localSourceImage, localConvertedImage string, params convertParams, failure string) error {
+	var tags []string
+
+	for _, m := range unknownTagRegex.FindAllStringSubmatch(failure, -1) {
+		if !hasFormat(tags, m[1]) {
+			tags = append(tags, m[1])
+		}
+	}
+
+	if len(tags) == 0 {
+		return errors.New("no offending tags reported")
+	}
+
+	define := fmt.Sprintf("tiff:ignore-tags=%s", strings.Join(tags, ","))
+
+	return runConvert(fmt.Sprintf("%s[0]", localSourceImage), localConvertedImage, params, "-define", define)
+}
+
+// convertViaRewrite rewrites the tiff with a libtiff tool (if present in the layer),
+// then converts the rewritten file
+func convertViaRewrite(tool string, toolArgs ...string) convertStrategy {
+	return func(localSourceImage, localConvertedImage string, params convertParams, failure string) error {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("%s is not available", tool)
+		}
+
+		rewritten := fmt.Sprintf("source-%s.tif", tool)
+
+		args := append(append([]string{}, toolArgs...), localSourceImage, rewritten)
+		if out, err := runCommand(tool, args...); err != nil {
+			return fmt.Errorf("%s (%s)", err.Error(), strings.TrimSpace(out))
+		}
+
+		return runConvert(fmt.Sprintf("%s[0]", rewritten), localConvertedImage, params)
+	}
+}
+
+// convertAsJp2 forces the jpeg 2000 decoder, for files whose extension does not match their contents
+func convertAsJp2(localSourceImage, localConvertedImage string, params convertParams, failure string) error {
+	return runConvert(fmt.Sprintf("jp2:%s[0]", localSourceImage), localConvertedImage, params)
+}
+
+// convertWithFallbacks runs the fallback strategies appropriate to a failed conversion,
+// returning nil on the first success, or an error listing every attempt
+func convertWithFallbacks(localSourceImage, localConvertedImage string, params convertParams, convertErr error) error {
+	attempts := []string{fmt.Sprintf("default: %s", convertErr.Error())}
+
+	class := classifyConvertFailure(convertErr.Error())
+	if class == nil {
+		return fmt.Errorf("failed to convert source image: [%s]", convertErr.Error())
+	}
+
+	log.Printf("conversion failure classified as [%s]; trying fallbacks: [%s]", class.name, strings.Join(class.strategies, ", "))
+
+	for _, name := range class.strategies {
+		err := convertStrategies[name](localSourceImage, localConvertedImage, params, convertErr.Error())
+		if err == nil {
+			log.Printf("conversion fallback [%s] succeeded", name)
+			return nil
+		}
+
+		log.Printf("conversion fallback [%s] failed: %s", name, err.Error())
+		attempts = append(attempts, fmt.Sprintf("%s: %s", name, err.Error()))
+	}
+
+	return fmt.Errorf("failed to convert source image; attempted strategies: [%s]", strings.Join(attempts, "; "))
+}
+
+// binarization methods for faint or low-contrast scans
+const (
+	binarizeThreshold = "threshold" // global threshold at a fixed intensity
+	binarizeAdaptive  = "adaptive"  // local adaptive threshold over a sliding window
+)
+
+// binarizeOperations returns the magick operators for the requested binarization,
+// using defaults for any parameters left unset (zero)
+func binarizeOperations(method string, threshold, window, offset int) ([]string, error) {
+	switch method {
+	case "":
+		return nil, nil
+
+	case binarizeThreshold:
+		if threshold == 0 {
+			threshold = 50
+		}
+
+		if threshold < 1 || threshold > 99 {
+			return nil, fmt.Errorf("binarize threshold must be between 1 and 99 percent: [%d]", threshold)
+		}
+
+		return []string{"-threshold", fmt.Sprintf("%d%%", threshold)}, nil
+
+	case binarizeAdaptive:
+		if window == 0 {
+			window = 25
+		}
+
+		if window < 3 || window > 500 {
+			return nil, fmt.Errorf("binarize window must be between 3 and 500 pixels: [%d]", window)
+		}
+
+		if offset < -100 || offset > 100 {
+			return nil, fmt.Errorf("binarize offset must be between -100 and 100 percent: [%d]", offset)
+		}
+
+		return []string{"-lat", fmt.Sprintf("%dx%d%+d%%", window, window, offset)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported binarize method: [%s] (must be one of: %s, %s)", method, binarizeThreshold, binarizeAdaptive)
+}
+
+// preprocessing steps that clean up poor scans before ocr, applied in this order
+const (
+	preprocessDeskew    = "deskew"    // straighten skewed text lines
+	preprocessDespeckle = "despeckle" // remove scanning noise
+	preprocessBinarize  = "binarize"  // binarize, adaptively unless another method is requested
+)
+
+var preprocessSteps = []string{preprocessDeskew, preprocessDespeckle, preprocessBinarize}
+
+// preprocessOperations returns the magick operators for the requested preprocessing steps,
+// followed by those for the requested binarization (if any)
+func preprocessOperations(steps []string, method string, threshold, window, offset int) ([]string, error) {
+	for _, step := range steps {
+		if !hasFormat(preprocessSteps, step) {
+			return nil, fmt.Errorf("unsupported preprocess step: [%s] (must be one of: %s)", step, strings.Join(preprocessSteps, ", "))
+		}
+	}
+
+	var ops []string
+
+	if hasFormat(steps, preprocessDeskew) {
+		ops = append(ops, "-deskew", "40%", "+repage")
+	}
+
+	if hasFormat(steps, preprocessDespeckle) {
+		ops = append(ops, "-despeckle")
+	}
+
+	if hasFormat(steps, preprocessBinarize) && method == "" {
+		method = binarizeAdaptive
+	}
+
+	binarize, err := binarizeOperations(method, threshold, window, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ops, binarize...), nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClassifyConvertFailure(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"TIFFReadDirectory: Unknown field with tag 33723 (0x837b) encountered", "tiff-tags"},
+		{"TIFFFetchNormalTag: Incorrect count for field \"DateTime\"", "tiff-tags"},
+		{"Compression algorithm does not support random access", "tiff-compression"},
+		{"OJPEG compression support is not configured", "tiff-compression"},
+		{"magick: no decode delegate for this image format `' @ error/constitute.c/ReadImage/746", "unrecognized-format"},
+		{"magick: improper image header `source.tif'", "unrecognized-format"},
+		{"magick: unable to open image: no such file", ""},
+		{"", ""},
+	}
+
+	for _, tc := range tests {
+		got := ""
+		if class := classifyConvertFailure(tc.output); class != nil {
+			got = class.name
+		}
+
+		if got != tc.want {
+			t.Errorf("%q: got class %q, want %q", tc.output, got, tc.want)
+		}
+	}
+}
+
+func TestConvertArgs(t *testing.T) {
+	useConfig(t)
+
+	config.intermediateCompression = "lzw"
+
+	params := convertParams{scale: "50", operations: []string{"-despeckle"}}
+
+	got := convertArgs("tiff:source.tif[0]", "converted.tif", params, "-define", "tiff:ignore-tags=33723")
+
+	want := []string{"convert", "-units", "PixelsPerInch", "-type", "Grayscale", "-compress", "LZW", "+repage",
+		"-define", "tiff:ignore-tags=33723", "tiff:source.tif[0]", "-filter", "Lanczos", "-resize", "50%", "-despeckle", "converted.tif"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// magick stub that converts (writing its arguments to the output) only when its arguments
+// contain the given text, and otherwise fails with the given output
+func failingMagick(succeedWith, failure string) string {
+	return `
+case "$*" in
+*` + succeedWith + `*) for arg; do output=$arg; done; echo "$*" > "$output" ;;
+*) echo "` + failure + `" >&2; exit 1 ;;
+esac`
+}
+
+// rewriting tool stub that writes its output (the last argument)
+const rewritingTool = `
+for arg; do output=$arg; done
+echo rewritten > "$output"`
+
+func TestConvertWithFallbacks(t *testing.T) {
+	tests := []struct {
+		name    string
+		scripts map[string]string
+		wantIn  string   // text the successful conversion's arguments contain
+		errMsgs []string // or the parts of the error expected
+	}{
+		{
+			name:    "ignore tags",
+			scripts: map[string]string{"magick": failingMagick("ignore-tags=33723,34000", "Unknown field with tag 33723 (0x837b); Unknown field with tag 34000")},
+			wantIn:  "-define tiff:ignore-tags=33723,34000 source.tif[0]",
+		},
+		{
+			name:    "tiffcp",
+			scripts: map[string]string{"magick": failingMagick("source-tiffcp.tif", "OJPEG compression support is not configured"), "tiffcp": rewritingTool},
+			wantIn:  "source-tiffcp.tif[0]",
+		},
+		{
+			name:    "tiff2rgba after tiffcp fails",
+			scripts: map[string]string{"magick": failingMagick("source-tiff2rgba.tif", "Fax4 compression scheme"), "tiffcp": "exit 1", "tiff2rgba": rewritingTool},
+			wantIn:  "source-tiff2rgba.tif[0]",
+		},
+		{
+			name:    "jp2 decoder",
+			scripts: map[string]string{"magick": failingMagick("jp2:source.tif", "no decode delegate for this image format")},
+			wantIn:  "jp2:source.tif[0]",
+		},
+		{
+			name:    "unclassified",
+			scripts: map[string]string{"magick": failingMagick("never", "unable to open image")},
+			errMsgs: []string{"failed to convert source image: [", "unable to open image"},
+		},
+		{
+			name:    "tools unavailable",
+			scripts: map[string]string{"magick": failingMagick("never", "Fax3 compression scheme")},
+			errMsgs: []string{"default: ", "tiffcp: tiffcp is not available", "tiff2rgba: tiff2rgba is not available"},
+		},
+		{
+			name:    "every strategy fails",
+			scripts: map[string]string{"magick": failingMagick("never", "Unknown field with tag 700"), "tiffcp": "exit 1", "tiff2rgba": "exit 1"},
+			errMsgs: []string{"attempted strategies: [default: ", "; ignore-tags: ", "; tiffcp: exit status 1", "; tiff2rgba: exit status 1"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t)
+			useWorkDir(t)
+
+			dir := useStubCommands(t, tc.scripts)
+
+			// only the stubs are available
+			os.Setenv("PATH", dir)
+
+			if err := ioutil.WriteFile("source.tif", []byte("source image"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := convertImage("source.tif", "tiff:source.tif[0]", "converted.tif", convertParams{scale: "100"})
+
+			if tc.errMsgs != nil {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				for _, msg := range tc.errMsgs {
+					if !strings.Contains(err.Error(), msg) {
+						t.Errorf("got error %q, want one containing %q", err.Error(), msg)
+					}
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			converted, _ := ioutil.ReadFile("converted.tif")
+
+			if !strings.Contains(string(converted), tc.wantIn) {
+				t.Errorf("got conversion %q, want one with %q", converted, tc.wantIn)
+			}
+		})
+	}
+}
+
+func TestPreprocessOperations(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []string
+		method  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "all steps", steps: []string{"binarize", "despeckle", "deskew"}, want: []string{"-deskew", "40%", "+repage", "-despeckle", "-lat", "25x25+0%"}},
+		{name: "binarize with method", steps: []string{"binarize"}, method: binarizeThreshold, want: []string{"-threshold", "50%"}},
+		{name: "method alone", method: binarizeAdaptive, want: []string{"-lat", "25x25+0%"}},
+		{name: "unknown step", steps: []string{"sharpen"}, wantErr: true},
+		{name: "unknown method", method: "otsu", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := preprocessOperations(tc.steps, tc.method, 0, 0, 0)
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tc.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
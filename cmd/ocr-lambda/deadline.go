@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// bounds the work (downloads, commands) of the request being handled: it expires
+// once the lambda's remaining time drops below the work deadline margin, leaving
+// time to save and upload whatever results and reports there are
+var workCtx = context.Background()
+
+// bounds the commands of the current stage: it expires with the work context, or
+// once the stage's own time limit (if it has one) is exceeded
+var stageCtx = context.Background()
+var endStageCtx context.CancelFunc = func() {}
+
+// startWorkDeadline derives the work context for a request from the lambda context,
+// returning a function that ends it once the request is done
+func startWorkDeadline(ctx context.Context) func() {
+	cancel := context.CancelFunc(func() {})
+
+	if deadline, ok := ctx.Deadline(); ok {
+		workCtx, cancel = context.WithDeadline(context.Background(), deadline.Add(-time.Duration(config.workDeadlineMarginSecs)*time.Second))
+	}
+
+	stageCtx = workCtx
+
+	return func() {
+		endStageCtx()
+		cancel()
+		workCtx, stageCtx, endStageCtx = context.Background(), context.Background(), func() {}
+	}
+}
+
+// timed out requests fail with this error, so the caller can tell them from other failures
+type workDeadlineError struct {
+	stage string
+}
+
+func (e *workDeadlineError) Error() string {
+	return fmt.Sprintf("timed_out: lambda deadline approaching during %s stage (margin %d seconds)", e.stage, config.workDeadlineMarginSecs)
+}
+
+// requests with a stage that exceeded its own time limit fail with this error
+type stageTimeoutError struct {
+	stage string
+	limit time.Duration
+}
+
+func (e *stageTimeoutError) Error() string {
+	return fmt.Sprintf("stage_timed_out: %s stage exceeded its time limit of %v", e.stage, e.limit)
+}
+
+// workTimedOut reports whether the work deadline has passed
+func workTimedOut() bool {
+	return workCtx.Err() == context.DeadlineExceeded
+}
+
+// stageTimedOut reports whether the current stage exceeded its own time limit
+func stageTimedOut() bool {
+	return stageCtx.Err() == context.DeadlineExceeded && !workTimedOut()
+}
+
+// stageTimeout returns the time limit of a stage's commands, or zero if it has none
+func stageTimeout(stage string) time.Duration {
+	switch stage {
+	case stageConvert:
+		return time.Duration(config.convertTimeoutSecs) * time.Second
+	case stageOcr:
+		return time.Duration(config.ocrTimeoutSecs) * time.Second
+	}
+
+	return 0
+}
+
+// beginStage starts the time limit of a stage, failing if there is no time left to start it
+func beginStage(stage string) error {
+	if workTimedOut() {
+		return &workDeadlineError{stage: stage}
+	}
+
+	endStageCtx()
+
+	stageCtx, endStageCtx = workCtx, func() {}
+
+	if limit := stageTimeout(stage); limit > 0 {
+		stageCtx, endStageCtx = context.WithTimeout(workCtx, limit)
+	}
+
+	return nil
+}
+
+// timeoutError returns the error describing why a request ran out of time in a
+// stage, or nil if it did not
+func timeoutError(stage string) error {
+	switch {
+	case workTimedOut():
+		return &workDeadlineError{stage: stage}
+	case stageTimedOut():
+		return &stageTimeoutError{stage: stage, limit: stageTimeout(stage)}
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// index entry recording where results for a given source image and set of parameters live
+type dedupIndexEntry struct {
+	Bucket      string   `json:"bucket"`
+	Prefix      string   `json:"prefix"`
+	ResultsBase string   `json:"resultsbase"`
+	Files       []string `json:"files"`
+
+	// the response the results were returned with; the text and word coordinates are read
+	// back from the results files instead, and fields describing the request that was made
+	// (rather than its results) are left out
+	Response *workflowResponseType `json:"response,omitempty"`
+
+	// response fields recorded by entries made before the whole response was
+	UnmappedChars int           `json:"unmappedchars,omitempty"`
+	Confidence    *float64      `json:"confidence,omitempty"`
+	PageWords     []int         `json:"pagewords,omitempty"`
+	Quality       *qualityScore `json:"quality,omitempty"`
+
+	Provenance *provenanceType `json:"provenance,omitempty"` // origin of the source image that produced these results
+}
+
+func (e *dedupIndexEntry) location() string {
+	return fmt.Sprintf("s3://%s/%s", e.Bucket, e.Prefix)
+}
+
+// response returns the response recorded for the indexed results
+func (e *dedupIndexEntry) response() workflowResponseType {
+	if e.Response != nil {
+		return *e.Response
+	}
+
+	return workflowResponseType{
+		UnmappedChars: e.UnmappedChars,
+		Confidence:    e.Confidence,
+		PageWords:     e.PageWords,
+		Quality:       e.Quality,
+	}
+}
+
+// the parameters that affect ocr output; results are only reused when these match
+// exactly. fields are hashed in this order, and those omitted when unset or default
+// keep index entries made before they were added matching.
+type dedupParams struct {
+	Lang       string   `json:"lang"`
+	Scale      string   `json:"scale"`
+	Formats    []string `json:"formats"`
+	Operations []string `json:"operations"`
+	PdfDpi     int      `json:"pdfdpi"`
+	Split      bool     `json:"splitspread"`
+	Encoding   string   `json:"textencoding"`
+	Page       int      `json:"pagenumber,omitempty"`
+	MultiPage  bool     `json:"multipage,omitempty"`
+	Psm        int      `json:"psm,omitempty"` // tesseract modes are omitted when both are defaults
+	Oem        int      `json:"oem,omitempty"`
+	Tessdata   string   `json:"tessdata,omitempty"` // omitted for the configured tier
+	AutoRotate bool     `json:"autorotate,omitempty"`
+	Words      bool     `json:"words,omitempty"`
+	Original   bool     `json:"originalpdf,omitempty"`
+	Layout     bool     `json:"layout,omitempty"`
+	Cleanup    []string `json:"cleanup,omitempty"` // steps applied, as these are configurable
+	Engine     string   `json:"engine,omitempty"`  // omitted for tesseract
+	Model      string   `json:"model,omitempty"`
+	Banner     string   `json:"banner,omitempty"` // template of the delivery copy's banner, if one is produced
+}
+
+// newDedupParams derives the parameters identifying a request's results from its ocr config
+func newDedupParams(ocr ocrConfig) dedupParams {
+	langStr := ocr.languages
+	if langStr == "" {
+		langStr = defaultLanguage
+	}
+
+	params := dedupParams{
+		Lang:       langStr,
+		Scale:      ocr.scale,
+		Formats:    append([]string{"txt"}, ocr.additionalFormats...),
+		Operations: ocr.convertOperations,
+		PdfDpi:     ocr.pdfDpi,
+		Split:      ocr.splitSpread,
+		Encoding:   ocr.textEncoding,
+		Page:       ocr.pageNumber,
+		MultiPage:  ocr.multiPage,
+		AutoRotate: ocr.autoRotate,
+		Words:      ocr.words,
+		Original:   ocr.originalPdf,
+		Layout:     ocr.layout,
+	}
+
+	if ocr.cleanup {
+		params.Cleanup = config.cleanupSteps
+	}
+
+	if ocr.engineName != engineTesseract {
+		params.Engine = ocr.engineName
+	}
+
+	if ocr.engineName == engineKraken {
+		params.Model = config.krakenModel
+	}
+
+	if ocr.engine != defaultTesseractParams {
+		params.Psm, params.Oem = ocr.engine.psm, ocr.engine.oem
+	}
+
+	if ocr.tessdataType != config.tessdataType {
+		params.Tessdata = ocr.tessdataType
+	}
+
+	if ocr.settings != nil && ocr.settings.Banner {
+		params.Banner = ocr.settings.BannerTemplate
+	}
+
+	return params
+}
+
+func (p dedupParams) hash() string {
+	paramsText, _ := json.Marshal(p)
+	sum := sha256.Sum256(paramsText)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func dedupIndexKey(sourceHash, paramsHash string) string {
+	return path.Join(config.dedupIndexPrefix, sourceHash, fmt.Sprintf("%s.json", paramsHash))
+}
+
+// lookupDuplicate returns the index entry for the source/params, or nil if there is none
+func lookupDuplicate(svc *s3.S3, bucket, indexKey string) (*dedupIndexEntry, error) {
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(indexKey),
+		RequestPayer: requestPayer(),
+	})
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read duplicate index: [%s]", err.Error())
+	}
+	defer obj.Body.Close()
+
+	entryText, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read duplicate index: [%s]", err.Error())
+	}
+
+	var entry dedupIndexEntry
+
+	if err = json.Unmarshal(entryText, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse duplicate index: [%s]", err.Error())
+	}
+
+	return &entry, nil
+}
+
+// copyDuplicateResults copies the indexed results to the new prefix/base name, returning the
+// ocr text (as stored, in the requested text encoding) and the copied files
+func copyDuplicateResults(svc *s3.S3, entry *dedupIndexEntry, bucket, remoteResultsPrefix, resultsBase string) ([]byte, []manifestFile, error) {
+	// make sure every referenced result is still present before copying anything
+
+	sizes := make(map[string]int64)
+
+	for _, f := range entry.Files {
+		head, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket:       aws.String(entry.Bucket),
+			Key:          aws.String(path.Join(entry.Prefix, f)),
+			RequestPayer: requestPayer(),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("indexed result is missing: [%s] (%s)", f, err.Error())
+		}
+
+		sizes[f] = aws.Int64Value(head.ContentLength)
+	}
+
+	var copied []manifestFile
+
+	for _, f := range entry.Files {
+		src := path.Join(entry.Bucket, entry.Prefix, f)
+		dst := path.Join(remoteResultsPrefix, resultsBase+strings.TrimPrefix(f, entry.ResultsBase))
+
+		log.Printf("copying duplicate result: s3://%s => s3://%s/%s", src, bucket, dst)
+
+		input := &s3.CopyObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(dst),
+			CopySource:   aws.String((&url.URL{Path: src}).EscapedPath()),
+			StorageClass: aws.String(storageClassFor(dst)),
+			RequestPayer: requestPayer(),
+		}
+
+		// copies get their own acl rather than the original's, so grant read as for uploads
+		if config.resultGrantRead != "" {
+			input.GrantRead = aws.String(config.resultGrantRead)
+		}
+
+		if _, err := svc.CopyObject(input); err != nil {
+			return nil, nil, fmt.Errorf("failed to copy indexed result: [%s] (%s)", f, err.Error())
+		}
+
+		copied = append(copied, manifestFile{Key: dst, Bytes: sizes[f]})
+	}
+
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket:       aws.String(entry.Bucket),
+		Key:          aws.String(path.Join(entry.Prefix, fmt.Sprintf("%s.txt", entry.ResultsBase))),
+		RequestPayer: requestPayer(),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read indexed text: [%s]", err.Error())
+	}
+	defer obj.Body.Close()
+
+	textBytes, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read indexed text: [%s]", err.Error())
+	}
+
+	return textBytes, copied, nil
+}
+
+// readDuplicateWords returns the word coordinates saved with the indexed results, if any
+func readDuplicateWords(svc *s3.S3, entry *dedupIndexEntry) ([]wordsPageType, error) {
+	wordsFile := fmt.Sprintf("%s.words.json", entry.ResultsBase)
+
+	if !hasFormat(entry.Files, wordsFile) {
+		return nil, nil
+	}
+
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket:       aws.String(entry.Bucket),
+		Key:          aws.String(path.Join(entry.Prefix, wordsFile)),
+		RequestPayer: requestPayer(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexed word coordinates: [%s]", err.Error())
+	}
+	defer obj.Body.Close()
+
+	var pages []wordsPageType
+
+	if err = json.NewDecoder(obj.Body).Decode(&pages); err != nil {
+		return nil, fmt.Errorf("failed to parse indexed word coordinates: [%s]", err.Error())
+	}
+
+	return pages, nil
+}
+
+// newDuplicateIndexEntry describes uploaded results, and the response they were returned
+// with, for the duplicate index; the command log is not reused
+func newDuplicateIndexEntry(ocr ocrConfig, resultsBase, result string, uploaded []string) *dedupIndexEntry {
+	entry := &dedupIndexEntry{Bucket: ocr.bucket, Prefix: ocr.remoteResultsPrefix, ResultsBase: resultsBase, Provenance: ocr.provenance}
+
+	for _, f := range uploaded {
+		if f != fmt.Sprintf("%s.log", resultsBase) {
+			entry.Files = append(entry.Files, f)
+		}
+	}
+
+	var res workflowResponseType
+
+	if err := json.Unmarshal([]byte(result), &res); err == nil {
+		res.Text, res.Words = "", nil
+		res.DuplicateOf, res.Provenance, res.Settings, res.DeliveryFile, res.PageNumber, res.DiffStats = "", nil, nil, "", 0, nil
+
+		entry.Response = &res
+	}
+
+	return entry
+}
+
+func saveDuplicateIndexEntry(svc *s3.S3, bucket, indexKey string, entry *dedupIndexEntry) {
+	entryText, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("failed to serialize duplicate index entry: [%s]", err.Error())
+		return
+	}
+
+	// the entry is written to the results bucket, so it is paid for and retried like the results
+	err = withRetries(cmds, "duplicate index upload", uploadRetries(), func() error {
+		_, putErr := svc.PutObject(&s3.PutObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(indexKey),
+			Body:         bytes.NewReader(entryText),
+			ContentType:  aws.String("application/json"),
+			RequestPayer: requestPayer(),
+		})
+
+		return putErr
+	})
+
+	if err != nil {
+		log.Printf("failed to save duplicate index entry: [%s]", err.Error())
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCopyDuplicateResultsGrantsAndPayer(t *testing.T) {
+	fake := useFakeS3(t)
+
+	savedGrant, savedPayer := config.resultGrantRead, config.requestPayer
+	defer func() { config.resultGrantRead, config.requestPayer = savedGrant, savedPayer }()
+
+	config.resultGrantRead = "id=0123456789abcdef"
+	config.requestPayer = "requester"
+
+	fake.put("bucket", "results/old/results.txt", []byte("some text\n"))
+	fake.put("bucket", "results/old/results.hocr", []byte("<html/>"))
+
+	entry := &dedupIndexEntry{Bucket: "bucket", Prefix: "results/old", ResultsBase: "results", Files: []string{"results.txt", "results.hocr"}}
+
+	text, copied, err := copyDuplicateResults(newS3Client(config.resultsS3), entry, "bucket", "results/new", "ocr")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if string(text) != "some text\n" {
+		t.Errorf("got text [%s]", text)
+	}
+
+	if len(copied) != 2 || copied[0].Key != "results/new/ocr.txt" || copied[1].Key != "results/new/ocr.hocr" || copied[0].Bytes != 10 {
+		t.Errorf("unexpected copies: %+v", copied)
+	}
+
+	for _, f := range []string{"ocr.txt", "ocr.hocr"} {
+		obj := fake.object("bucket", "results/new/"+f)
+		if obj == nil {
+			t.Fatalf("%s was not copied", f)
+		}
+
+		if got := obj.header.Get("X-Amz-Grant-Read"); got != config.resultGrantRead {
+			t.Errorf("%s: got grant read [%s]", f, got)
+		}
+
+		if got := obj.header.Get("X-Amz-Request-Payer"); got != "requester" {
+			t.Errorf("%s: got copy request payer [%s]", f, got)
+		}
+	}
+
+	for _, method := range []string{"HEAD", "GET"} {
+		requests := fake.requestsFor(method, "")
+		if len(requests) == 0 {
+			t.Errorf("no %s requests were made", method)
+		}
+
+		for _, r := range requests {
+			if got := r.header.Get("X-Amz-Request-Payer"); got != "requester" {
+				t.Errorf("%s %s: got request payer [%s]", method, r.path, got)
+			}
+		}
+	}
+}
+
+func TestCopyDuplicateResultsMissing(t *testing.T) {
+	fake := useFakeS3(t)
+
+	fake.put("bucket", "results/old/results.txt", []byte("some text\n"))
+
+	entry := &dedupIndexEntry{Bucket: "bucket", Prefix: "results/old", ResultsBase: "results", Files: []string{"results.txt", "results.pdf"}}
+
+	if _, _, err := copyDuplicateResults(newS3Client(config.resultsS3), entry, "bucket", "results/new", "results"); err == nil {
+		t.Fatal("expected an error for a missing indexed result")
+	}
+
+	if keys := fake.keys("bucket"); len(keys) != 1 {
+		t.Errorf("results were copied despite a missing one: %v", keys)
+	}
+}
+
+func TestSaveDuplicateIndexEntryPayerAndRetries(t *testing.T) {
+	fake := useFakeS3(t)
+
+	savedPayer, savedRetries, savedDelay := config.requestPayer, config.uploadMaxRetries, config.uploadRetryDelayMs
+	defer func() {
+		config.requestPayer, config.uploadMaxRetries, config.uploadRetryDelayMs = savedPayer, savedRetries, savedDelay
+	}()
+
+	config.requestPayer = "requester"
+	config.uploadMaxRetries = 1
+	config.uploadRetryDelayMs = 1
+
+	// the first put is throttled
+	puts := 0
+	fake.fail = func(r *http.Request) int {
+		if r.Method != http.MethodPut {
+			return 0
+		}
+		if puts++; puts == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+
+	entry := &dedupIndexEntry{Bucket: "bucket", Prefix: "results/old", ResultsBase: "results", Files: []string{"results.txt"}}
+
+	saveDuplicateIndexEntry(newS3Client(config.resultsS3), "bucket", "internal/dedup/abc.json", entry)
+
+	obj := fake.object("bucket", "internal/dedup/abc.json")
+	if obj == nil {
+		t.Fatal("index entry was not saved after a retryable failure")
+	}
+
+	if puts != 2 {
+		t.Errorf("got %d puts, want 2", puts)
+	}
+
+	if got := obj.header.Get("X-Amz-Request-Payer"); got != "requester" {
+		t.Errorf("got request payer [%s]", got)
+	}
+
+	var saved dedupIndexEntry
+
+	if err := json.Unmarshal(obj.data, &saved); err != nil || saved.Prefix != entry.Prefix {
+		t.Errorf("unexpected saved entry: %s", obj.data)
+	}
+}
+
+func TestHandleDuplicateResponseFields(t *testing.T) {
+	fake := useFakeS3(t)
+
+	confidence := 87.5
+	dictionaryWords := 92.0
+
+	original := workflowResponseType{
+		Formats:       []string{"txt", "hocr"},
+		Blocks:        []blockLanguageType{{ID: "block_1_1", Lang: "fra", Words: 40}},
+		UnmappedChars: 3,
+		PyramidLevel:  &pyramidLevelType{Level: 1, Width: 600, Height: 800, Scale: "100.0000", Factor: 2},
+		Pages:         2,
+		Confidence:    &confidence,
+		PageWords:     []int{42},
+		Scale:         "50",
+		Lang:          "fra",
+		Rotated:       90,
+		Cleanup:       []string{"hyphenation"},
+		Quality:       &qualityScore{Score: 90, DictionaryWords: &dictionaryWords, Words: 42, Dictionaries: []string{"eng"}},
+	}
+
+	words := []wordsPageType{{Page: 1, Width: 100, Height: 200, Lines: []wordsLineType{{BBox: [4]int{1, 2, 3, 4}}}}}
+
+	wordsText, _ := json.Marshal(words)
+	fake.put("bucket", "results/old/results.txt", []byte("some text\n"))
+	fake.put("bucket", "results/old/results.words.json", wordsText)
+
+	result, _ := json.Marshal(original)
+	old := ocrConfig{bucket: "bucket", remoteResultsPrefix: "results/old"}
+	entry := newDuplicateIndexEntry(old, "results", string(result), []string{"results.txt", "results.words.json"})
+
+	entryText, _ := json.Marshal(entry)
+	fake.put("bucket", "index/entry.json", entryText)
+
+	manifest := &resultsManifest{}
+	ocr := ocrConfig{bucket: "bucket", remoteResultsPrefix: "results/new", words: true}
+
+	out, err := handleDuplicate(ocr, "index/entry.json", "results", manifest)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var res workflowResponseType
+	if err = json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("failed to parse response: %s", err.Error())
+	}
+
+	if res.Confidence == nil || *res.Confidence != confidence {
+		t.Errorf("got confidence %v", res.Confidence)
+	}
+
+	if !reflect.DeepEqual(res.PageWords, original.PageWords) {
+		t.Errorf("got page words %v", res.PageWords)
+	}
+
+	if !reflect.DeepEqual(res.Quality, original.Quality) || !reflect.DeepEqual(manifest.Quality, original.Quality) {
+		t.Errorf("got quality %+v (manifest %+v)", res.Quality, manifest.Quality)
+	}
+
+	if !reflect.DeepEqual(res.Words, words) {
+		t.Errorf("got words %+v", res.Words)
+	}
+
+	if res.DuplicateOf != "s3://bucket/results/old" {
+		t.Errorf("got duplicate of [%s]", res.DuplicateOf)
+	}
+
+	// everything else is returned as it was originally
+	res.Text, res.Words, res.DuplicateOf = "", nil, ""
+
+	if !reflect.DeepEqual(res, original) {
+		t.Errorf("got response %+v, want %+v", res, original)
+	}
+
+	if manifest.Languages != "fra" || manifest.Scale != "50" {
+		t.Errorf("got manifest languages %q and scale %q", manifest.Languages, manifest.Scale)
+	}
+}
+
+func TestHandleDuplicateLegacyEntry(t *testing.T) {
+	fake := useFakeS3(t)
+
+	confidence := 87.5
+
+	// entries made before the whole response was recorded
+	fake.put("bucket", "results/old/results.txt", []byte("some text\n"))
+	fake.put("bucket", "index/entry.json", []byte(`{"bucket":"bucket","prefix":"results/old","resultsbase":"results","files":["results.txt"],"unmappedchars":2,"confidence":87.5,"pagewords":[3]}`))
+
+	ocr := ocrConfig{bucket: "bucket", remoteResultsPrefix: "results/new", additionalFormats: []string{"hocr"}}
+
+	out, err := handleDuplicate(ocr, "index/entry.json", "results", &resultsManifest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var res workflowResponseType
+	if err = json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("failed to parse response: %s", err.Error())
+	}
+
+	want := workflowResponseType{
+		Text:          "some text\n",
+		Formats:       []string{"txt", "hocr"},
+		DuplicateOf:   "s3://bucket/results/old",
+		UnmappedChars: 2,
+		Confidence:    &confidence,
+		PageWords:     []int{3},
+	}
+
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("got response %+v, want %+v", res, want)
+	}
+}
+
+// the ocr config of a request with every setting left at its default
+func defaultDedupConfig() ocrConfig {
+	return ocrConfig{scale: "100", textEncoding: textEncodingUtf8, engineName: engineTesseract, engine: defaultTesseractParams, tessdataType: config.tessdataType}
+}
+
+func TestDedupParamsHashStable(t *testing.T) {
+	params := newDedupParams(defaultDedupConfig())
+
+	text, _ := json.Marshal(params)
+
+	// existing index entries are only found while this serialization is unchanged
+	want := `{"lang":"eng","scale":"100","formats":["txt"],"operations":null,"pdfdpi":0,"splitspread":false,"textencoding":"utf-8"}`
+
+	if string(text) != want {
+		t.Errorf("got %s, want %s", text, want)
+	}
+}
+
+func TestDedupParamsHashDistinguishes(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*ocrConfig)
+	}{
+		{"lang", func(o *ocrConfig) { o.languages = "fra" }},
+		{"scale", func(o *ocrConfig) { o.scale = "50" }},
+		{"formats", func(o *ocrConfig) { o.additionalFormats = []string{"hocr"} }},
+		{"operations", func(o *ocrConfig) { o.convertOperations = []string{"deskew"} }},
+		{"pdf dpi", func(o *ocrConfig) { o.pdfDpi = 300 }},
+		{"split spread", func(o *ocrConfig) { o.splitSpread = true }},
+		{"encoding", func(o *ocrConfig) { o.textEncoding = textEncodingLatin1 }},
+		{"page number", func(o *ocrConfig) { o.pageNumber = 2 }},
+		{"multi-page", func(o *ocrConfig) { o.multiPage = true }},
+		{"psm", func(o *ocrConfig) { o.engine.psm = 6 }},
+		{"tessdata type", func(o *ocrConfig) { o.tessdataType = "other" }},
+		{"auto rotate", func(o *ocrConfig) { o.autoRotate = true }},
+		{"words", func(o *ocrConfig) { o.words = true }},
+		{"original pdf", func(o *ocrConfig) { o.originalPdf = true }},
+		{"layout", func(o *ocrConfig) { o.layout = true }},
+		{"engine", func(o *ocrConfig) { o.engineName = engineVision }},
+	}
+
+	base := newDedupParams(defaultDedupConfig()).hash()
+	seen := map[string]string{base: "defaults"}
+
+	for _, tc := range tests {
+		ocr := defaultDedupConfig()
+		tc.modify(&ocr)
+
+		h := newDedupParams(ocr).hash()
+
+		if other, ok := seen[h]; ok {
+			t.Errorf("%s: hash equals that of %s", tc.name, other)
+		}
+
+		seen[h] = tc.name
+	}
+
+	// the default language is hashed as such
+	explicit := defaultDedupConfig()
+	explicit.languages = defaultLanguage
+
+	if newDedupParams(explicit).hash() != base {
+		t.Error("an explicit default language changed the hash")
+	}
+}
+
+func TestDedupParamsHashBanner(t *testing.T) {
+	withBanner := func(banner bool, template string) string {
+		ocr := defaultDedupConfig()
+		ocr.settings = &resolvedSettings{Banner: banner, BannerTemplate: template}
+		return newDedupParams(ocr).hash()
+	}
+
+	base := newDedupParams(defaultDedupConfig()).hash()
+
+	if withBanner(false, "[OCR {date}]") != base {
+		t.Error("the banner template changed the hash of results without a banner")
+	}
+
+	if withBanner(true, "[OCR {date}]") == base {
+		t.Error("results with a banner hash as those without")
+	}
+
+	if withBanner(true, "[OCR {date}]") == withBanner(true, "[Machine OCR {date}]") {
+		t.Error("results with different banners hash the same")
+	}
+}
+
+func TestHandleDuplicateBanner(t *testing.T) {
+	fake := useFakeS3(t)
+
+	fake.put("bucket", "results/old/results.txt", []byte("the text\n"))
+	fake.put("bucket", "results/old/results.delivery.txt", []byte("[OCR 2021-03-04]\nthe text\n"))
+
+	entry := &dedupIndexEntry{Bucket: "bucket", Prefix: "results/old", ResultsBase: "results", Files: []string{"results.txt", "results.delivery.txt"}}
+
+	entryText, _ := json.Marshal(entry)
+	fake.put("bucket", "index/entry.json", entryText)
+
+	ocr := ocrConfig{bucket: "bucket", remoteResultsPrefix: "results/new", settings: &resolvedSettings{Banner: true, BannerTemplate: "[OCR {date}]"}}
+
+	out, err := handleDuplicate(ocr, "index/entry.json", "ocr", &resultsManifest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var res workflowResponseType
+	if err = json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("failed to parse response: %s", err.Error())
+	}
+
+	if res.DeliveryFile != "ocr.delivery.txt" {
+		t.Errorf("got delivery file [%s]", res.DeliveryFile)
+	}
+
+	if res.Text != "the text\n" {
+		t.Errorf("response text has changed: %q", res.Text)
+	}
+
+	if got := string(fake.object("bucket", "results/new/ocr.txt").data); got != "the text\n" {
+		t.Errorf("canonical text has changed: %q", got)
+	}
+
+	if got := string(fake.object("bucket", "results/new/ocr.delivery.txt").data); strings.Count(got, "[OCR ") != 1 {
+		t.Errorf("delivery text does not have the banner exactly once: %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"syscall"
+)
+
+// smallest scale (percent) we will reduce to in order to fit the converted image on disk
+const minReducedScale = 10
+
+// availableSpace returns the bytes available to us on the file system containing dir
+func availableSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, fmt.Errorf("failed to determine available disk space: [%s]", err.Error())
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+func diskSpaceMargin() int64 {
+	return int64(config.diskSpaceMarginMB) * 1024 * 1024
+}
+
+// checkDiskSpace fails if a file of the given size (plus a safety margin) will not fit in dir
+func checkDiskSpace(dir string, size int64, what string) error {
+	avail, err := availableSpace(dir)
+	if err != nil {
+		return err
+	}
+
+	if size+diskSpaceMargin() > avail {
+		return fmt.Errorf("insufficient disk space for %s: need %d bytes (plus %d MB margin), %d available", what, size, config.diskSpaceMarginMB, avail)
+	}
+
+	return nil
+}
+
+// estimateConvertedSize returns the uncompressed size of the 8-bit grayscale image
+// produced by scaling an image of the given dimensions
+func estimateConvertedSize(width, height int, pct float64) int64 {
+	factor := pct / 100
+
+	return int64(math.Ceil(float64(width)*factor) * math.Ceil(float64(height)*factor))
+}
+
+// fitConvertedImage checks that the image converted from a source of the given dimensions
+// at the given scale will fit on disk, returning the scale to use: either the one given or,
+// if reduce is set, the largest (whole percentage) scale that fits
+func fitConvertedImage(dir string, width, height int, scale string, reduce bool) (string, error) {
+	pct, err := strconv.ParseFloat(scale, 64)
+	if err != nil || pct <= 0 {
+		return scale, nil
+	}
+
+	avail, err := availableSpace(dir)
+	if err != nil {
+		return "", err
+	}
+
+	budget := avail - diskSpaceMargin()
+	needed := estimateConvertedSize(width, height, pct)
+
+	if needed <= budget {
+		return scale, nil
+	}
+
+	what := fmt.Sprintf("converted %dx%d image at %s%%: need about %d bytes (plus %d MB margin), %d available", width, height, scale, needed, config.diskSpaceMarginMB, avail)
+
+	if !reduce {
+		return "", fmt.Errorf("insufficient disk space for %s", what)
+	}
+
+	reduced := 0
+	if budget > 0 {
+		reduced = scaleWithinPixels(width, height, budget)
+	}
+
+	if reduced < minReducedScale {
+		return "", fmt.Errorf("insufficient disk space for %s; would need to reduce scale below %d%%", what, minReducedScale)
+	}
+
+	newScale := strconv.Itoa(reduced)
+
+	log.Printf("insufficient disk space for %s; reducing scale to %s%%", what, newScale)
+
+	return newScale, nil
+}
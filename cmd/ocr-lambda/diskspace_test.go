@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFitConvertedImage(t *testing.T) {
+	useConfig(t)
+
+	config.diskSpaceMarginMB = 0
+
+	dir := t.TempDir()
+
+	avail, err := availableSpace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an image that fits at 100%, and one that only fits at about half the size
+	small := int(math.Sqrt(float64(avail) / 4))
+	large := int(math.Sqrt(float64(avail) * 4))
+
+	if got, err := fitConvertedImage(dir, small, small, "100", false); err != nil || got != "100" {
+		t.Errorf("got scale %q (error %v) for an image that fits, want 100", got, err)
+	}
+
+	if _, err = fitConvertedImage(dir, large, large, "100", false); err == nil || !strings.Contains(err.Error(), "insufficient disk space") {
+		t.Errorf("got error %v without reducing, want insufficient disk space", err)
+	}
+
+	got, err := fitConvertedImage(dir, large, large, "100", true)
+	if err != nil {
+		t.Fatalf("unexpected error reducing: %s", err.Error())
+	}
+
+	if pct, _ := strconv.Atoi(got); pct < 40 || pct > 50 {
+		t.Errorf("got reduced scale %q, want about 50", got)
+	}
+}
+
+func TestReproducibleRequestDoesNotReduceScale(t *testing.T) {
+	env := useIntegrationEnv(t)
+
+	config.diskSpaceReduceScale = true
+	config.maxOutputMegapixels = 0
+
+	osdHash, _ := hashFile(filepath.Join(env.tessdataDir, "osd.traineddata"))
+	engHash, _ := hashFile(filepath.Join(env.tessdataDir, "eng.traineddata"))
+
+	config.toolchainManifest = fmt.Sprintf(`{"tesseract":"4.1.1","magick":"7.0.11-2","traineddata":{"osd":"%s","eng":"%s"}}`, osdHash, engHash)
+
+	// the source identifies as an image that needs twice the space available at 100%, so that
+	// it only fits at about 70%, however the space used by anything else changes meanwhile
+	avail, err := availableSpace("/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	side := int(math.Sqrt(float64(avail) * 2))
+
+	useStubCommands(t, map[string]string{
+		"magick": strings.Replace(stubMagick, `echo "1200 1600"`, fmt.Sprintf(`echo "%d %d"`, side, side), 1),
+	})
+
+	config.diskSpaceMarginMB = 0
+
+	env.s3.put("bucket", "image.png", []byte(testPng))
+
+	for _, reproducible := range []bool{false, true} {
+		req := workflowRequestType{Bucket: "bucket", Key: "image.png", Pid: "uva-lib:1", Lang: "eng", Reproducible: reproducible}
+
+		ocr, err := buildWorkflowOcrConfig(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := handleGenericOcrRequest(context.Background(), *ocr)
+
+		if reproducible {
+			if err == nil || !strings.Contains(err.Error(), "insufficient disk space") {
+				t.Errorf("got error %v, want insufficient disk space rather than a reduced scale", err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+
+		var res workflowResponseType
+		if err = json.Unmarshal([]byte(output), &res); err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Scale == "" || res.Scale == "100" {
+			t.Errorf("got scale %q, want a reduced scale for a request that is not reproducible", res.Scale)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// encodings available for results text files; the text in the response is always utf-8
+const textEncodingUtf8 = "utf-8"
+const textEncodingUtf8Bom = "utf-8-bom"
+const textEncodingLatin1 = "latin-1"
+
+var textEncodings = []string{textEncodingUtf8, textEncodingUtf8Bom, textEncodingLatin1}
+
+// charset reported in the content type of text results, per encoding
+var textEncodingCharsets = map[string]string{
+	textEncodingUtf8:    "utf-8",
+	textEncodingUtf8Bom: "utf-8",
+	textEncodingLatin1:  "iso-8859-1",
+}
+
+var utf8Bom = []byte{0xef, 0xbb, 0xbf}
+
+// approximations for characters outside latin-1 that tesseract commonly produces
+var latin1Transliterations = map[rune]string{
+	'‘': "'",
+	'’': "'",
+	'‚': ",",
+	'“': "\"",
+	'”': "\"",
+	'„': "\"",
+	'–': "-",
+	'—': "-",
+	'…': "...",
+	'ﬀ': "ff",
+	'ﬁ': "fi",
+	'ﬂ': "fl",
+	'ﬃ': "ffi",
+	'ﬄ': "ffl",
+}
+
+func validateTextEncoding(encoding string) error {
+	for _, e := range textEncodings {
+		if encoding == e {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported text encoding: [%s] (must be one of: %s)", encoding, strings.Join(textEncodings, ", "))
+}
+
+// latin1Replacement returns what a character latin-1 cannot represent is written as:
+// an approximation if it has one, otherwise "?"
+func latin1Replacement(r rune) string {
+	if t, ok := latin1Transliterations[r]; ok {
+		return t
+	}
+
+	return "?"
+}
+
+// encodeLatin1 converts utf-8 text to latin-1, transliterating or replacing characters
+// that latin-1 cannot represent (and invalid utf-8), and returns the number of such characters
+func encodeLatin1(text []byte) ([]byte, int) {
+	out := make([]byte, 0, len(text))
+	unmapped := 0
+
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		text = text[size:]
+
+		if r == utf8.RuneError && size <= 1 {
+			out = append(out, '?')
+			unmapped++
+			continue
+		}
+
+		if b, ok := charmap.ISO8859_1.EncodeRune(r); ok {
+			out = append(out, b)
+			continue
+		}
+
+		out = append(out, latin1Replacement(r)...)
+		unmapped++
+	}
+
+	return out, unmapped
+}
+
+// encodeText converts utf-8 text to the given encoding, returning the
+// number of characters that could not be represented exactly
+func encodeText(text []byte, encoding string) ([]byte, int) {
+	switch encoding {
+	case textEncodingUtf8Bom:
+		return append(append([]byte{}, utf8Bom...), text...), 0
+
+	case textEncodingLatin1:
+		return encodeLatin1(text)
+
+	default:
+		return text, 0
+	}
+}
+
+// decodeText converts results text saved in the given encoding back to utf-8, without
+// any byte order mark; characters that were replaced when it was encoded stay replaced
+func decodeText(text []byte, encoding string) string {
+	if encoding == textEncodingLatin1 {
+		decoded, _ := charmap.ISO8859_1.NewDecoder().Bytes(text)
+		return string(decoded)
+	}
+
+	return string(bytes.TrimPrefix(text, utf8Bom))
+}
+
+// encodeTextFile rewrites a utf-8 results text file in the given encoding
+func encodeTextFile(filename, encoding string) (int, error) {
+	if encoding == "" || encoding == textEncodingUtf8 {
+		return 0, nil
+	}
+
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read ocr results file: [%s]", err.Error())
+	}
+
+	encoded, unmapped := encodeText(text, encoding)
+
+	if err = ioutil.WriteFile(filename, encoded, 0644); err != nil {
+		return 0, fmt.Errorf("failed to save encoded ocr results: [%s]", err.Error())
+	}
+
+	if unmapped > 0 {
+		log.Printf("WARNING: %d character(s) in %s could not be represented in %s", unmapped, filename, encoding)
+	}
+
+	return unmapped, nil
+}
+
+// contentTypeEncoding returns the encoding of a results text file from its content type
+func contentTypeEncoding(contentType string) string {
+	if strings.Contains(contentType, "charset="+textEncodingCharsets[textEncodingLatin1]) {
+		return textEncodingLatin1
+	}
+
+	return textEncodingUtf8
+}
+
+// textContentType returns the content type for a results file, or "" to let s3 decide
+func textContentType(resultFile, encoding string) string {
+	if !strings.HasSuffix(resultFile, ".txt") {
+		return ""
+	}
+
+	charset := textEncodingCharsets[encoding]
+	if charset == "" {
+		charset = textEncodingCharsets[textEncodingUtf8]
+	}
+
+	return fmt.Sprintf("text/plain; charset=%s", charset)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEncodeLatin1(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		want     []byte
+		unmapped int
+	}{
+		{name: "empty", text: "", want: []byte{}},
+		{name: "ascii", text: "plain text\n", want: []byte("plain text\n")},
+		{name: "latin-1", text: "café ñ ß ÿ", want: []byte{'c', 'a', 'f', 0xe9, ' ', 0xf1, ' ', 0xdf, ' ', 0xff}},
+		{name: "transliterated quotes", text: "“quoted” ‘text’", want: []byte(`"quoted" 'text'`), unmapped: 4},
+		{name: "transliterated ligatures", text: "ﬁne ﬄy", want: []byte("fine ffly"), unmapped: 2},
+		{name: "ellipsis and dashes", text: "a…b–c—d", want: []byte("a...b-c-d"), unmapped: 3},
+		{name: "unmappable", text: "漢字 → x", want: []byte("?? ? x"), unmapped: 3},
+		{name: "euro sign", text: "5€", want: []byte("5?"), unmapped: 1},
+		{name: "invalid utf-8", text: "a\xffb\xc3", want: []byte("a?b?"), unmapped: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, unmapped := encodeLatin1([]byte(tc.text))
+
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+
+			if unmapped != tc.unmapped {
+				t.Errorf("got %d unmapped, want %d", unmapped, tc.unmapped)
+			}
+		})
+	}
+}
+
+func TestEncodeText(t *testing.T) {
+	text := []byte("café\n")
+
+	if got, n := encodeText(text, textEncodingUtf8); !bytes.Equal(got, text) || n != 0 {
+		t.Errorf("utf-8: got %q (%d)", got, n)
+	}
+
+	if got, n := encodeText(text, textEncodingUtf8Bom); !bytes.Equal(got, append(append([]byte{}, utf8Bom...), text...)) || n != 0 {
+		t.Errorf("utf-8-bom: got %q (%d)", got, n)
+	}
+
+	if got, n := encodeText(text, textEncodingLatin1); !bytes.Equal(got, []byte("caf\xe9\n")) || n != 0 {
+		t.Errorf("latin-1: got %q (%d)", got, n)
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		encoding string
+	}{
+		{name: "utf-8", text: "café “x” 漢", encoding: textEncodingUtf8},
+		{name: "utf-8-bom", text: "café “x” 漢", encoding: textEncodingUtf8Bom},
+		{name: "latin-1", text: "café ñ ß ÿ", encoding: textEncodingLatin1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, _ := encodeText([]byte(tc.text), tc.encoding)
+
+			got := decodeText(encoded, tc.encoding)
+
+			if got != tc.text {
+				t.Errorf("got %q, want %q", got, tc.text)
+			}
+		})
+	}
+}
+
+func TestContentTypeEncoding(t *testing.T) {
+	for encoding := range textEncodingCharsets {
+		got := contentTypeEncoding(textContentType("results.txt", encoding))
+
+		// a bom is only recognizable from the text itself
+		want := encoding
+		if encoding == textEncodingUtf8Bom {
+			want = textEncodingUtf8
+		}
+
+		if got != want {
+			t.Errorf("%s: got %s", encoding, got)
+		}
+	}
+}
+
+func TestHandleDuplicateText(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		text     string // as originally recognized
+		want     string // in the response; latin-1 replacements cannot be undone
+		unmapped int
+	}{
+		{name: "utf-8", encoding: textEncodingUtf8, text: "café “x” 漢\n", want: "café “x” 漢\n"},
+		{name: "utf-8-bom", encoding: textEncodingUtf8Bom, text: "café “x” 漢\n", want: "café “x” 漢\n"},
+		{name: "latin-1", encoding: textEncodingLatin1, text: "café “x” 漢\n", want: "café \"x\" ?\n", unmapped: 3},
+		{name: "latin-1 page number", encoding: textEncodingLatin1, text: pageMarker(12) + "ñ—\n", want: "ñ-\n", unmapped: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := useFakeS3(t)
+
+			stored, unmapped := encodeText([]byte(tc.text), tc.encoding)
+
+			if unmapped != tc.unmapped {
+				t.Fatalf("got %d unmapped, want %d", unmapped, tc.unmapped)
+			}
+
+			fake.put("bucket", "results/old/results.txt", stored)
+
+			result, _ := json.Marshal(workflowResponseType{UnmappedChars: unmapped})
+			old := ocrConfig{bucket: "bucket", remoteResultsPrefix: "results/old"}
+			entry := newDuplicateIndexEntry(old, "results", string(result), []string{"results.txt", "results.log"})
+
+			entryText, _ := json.Marshal(entry)
+			fake.put("bucket", "index/entry.json", entryText)
+
+			ocr := ocrConfig{bucket: "bucket", remoteResultsPrefix: "results/new", textEncoding: tc.encoding}
+
+			out, err := handleDuplicate(ocr, "index/entry.json", "results", &resultsManifest{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			var res workflowResponseType
+			if err = json.Unmarshal([]byte(out), &res); err != nil {
+				t.Fatalf("failed to parse response: %s", err.Error())
+			}
+
+			if !utf8.ValidString(res.Text) || bytes.HasPrefix([]byte(res.Text), utf8Bom) {
+				t.Errorf("response text is not utf-8 without a bom: %q", res.Text)
+			}
+
+			if res.Text != tc.want {
+				t.Errorf("got text %q, want %q", res.Text, tc.want)
+			}
+
+			if res.UnmappedChars != tc.unmapped {
+				t.Errorf("got %d unmapped, want %d", res.UnmappedChars, tc.unmapped)
+			}
+
+			if len(entry.Files) != 1 {
+				t.Errorf("the command log was indexed: %v", entry.Files)
+			}
+		})
+	}
+}
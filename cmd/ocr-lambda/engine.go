@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ocr engines a request can choose from; tesseract is the default
+const (
+	engineTesseract = "tesseract"
+	engineKraken    = "kraken"
+	engineVision    = "vision"
+)
+
+var engineNames = []string{engineTesseract, engineKraken, engineVision}
+
+// output formats each engine other than tesseract can produce
+var engineFormats = map[string][]string{
+	engineKraken: {"txt", "hocr", "alto"},
+	engineVision: {"txt", "hocr", "tsv"},
+}
+
+// an ocr engine: how the source image is converted for it, and how it recognizes the
+// converted image, saving results for each output format as <resultsBase>.<extension>
+type ocrEngine interface {
+	convert(localSourceImage, sourceInput, localConvertedImage string, params convertParams) error
+	recognize(localConvertedImage, resultsBase, langStr string, outputFormats []string) error
+}
+
+// newOcrEngine returns the engine a request chose, set up with its parameters
+func newOcrEngine(ocr ocrConfig, tessdataDir string) ocrEngine {
+	switch ocr.engineName {
+	case engineKraken:
+		return krakenEngine{model: config.krakenModel}
+
+	case engineVision:
+		return visionEngine{}
+	}
+
+	return tesseractEngine{tessdataDir: tessdataDir, params: ocr.engine}
+}
+
+type tesseractEngine struct {
+	tessdataDir string
+	params      tesseractParams
+}
+
+func (e tesseractEngine) convert(localSourceImage, sourceInput, localConvertedImage string, params convertParams) error {
+	return convertImage(localSourceImage, sourceInput, localConvertedImage, params)
+}
+
+func (e tesseractEngine) recognize(localConvertedImage, resultsBase, langStr string, outputFormats []string) error {
+	return ocrImage(localConvertedImage, resultsBase, langStr, outputFormats, e.tessdataDir, e.params)
+}
+
+// kraken recognizes text with a model trained for a collection (e.g. a hand), rather than
+// with language files, which makes it better suited to handwriting than tesseract
+type krakenEngine struct {
+	model string
+}
+
+// how kraken serializes each output format it can produce, and the extension it is saved with
+// (-n is kraken's default plain text; -t would instead take a custom serialization template)
+var krakenFormats = map[string]struct{ flag, ext string }{
+	"txt":  {"-n", "txt"},
+	"hocr": {"-h", "hocr"},
+	"alto": {"-a", "xml"},
+}
+
+// kraken's baseline segmenter works on the same grayscale image tesseract does
+func (e krakenEngine) convert(localSourceImage, sourceInput, localConvertedImage string, params convertParams) error {
+	return convertImage(localSourceImage, sourceInput, localConvertedImage, params)
+}
+
+// recognize runs kraken once per output format, as it serializes a single format per run;
+// formats it cannot produce (e.g. the tsv wanted for confidences) are skipped
+func (e krakenEngine) recognize(localConvertedImage, resultsBase, langStr string, outputFormats []string) error {
+	for _, format := range outputFormats {
+		serialization, ok := krakenFormats[format]
+		if !ok {
+			continue
+		}
+
+		log.Printf("ocring image with kraken (%s)...", format)
+
+		output := fmt.Sprintf("%s.%s", resultsBase, serialization.ext)
+
+		args := []string{"-i", localConvertedImage, output, serialization.flag, "segment", "-bl", "ocr", "-m", e.model}
+
+		if out, err := runCommand("kraken", args...); err != nil {
+			return fmt.Errorf("failed to ocr converted image with kraken: [%s] (%s)", err.Error(), strings.TrimSpace(out))
+		}
+	}
+
+	return nil
+}
+
+func getKrakenVersion() string {
+	// output is of the form: "kraken, version 4.1.2"
+	out, _ := runCommand("kraken", "--version")
+	fields := strings.Fields(firstLine(out))
+
+	if len(fields) < 3 {
+		return ""
+	}
+
+	return fields[len(fields)-1]
+}
+
+func validateEngine(name string) error {
+	if !hasFormat(engineNames, name) {
+		return fmt.Errorf("unsupported ocr engine: [%s] (must be one of: %s)", name, strings.Join(engineNames, ", "))
+	}
+
+	if name == engineKraken && config.krakenModel == "" {
+		return errors.New("kraken is not enabled: no model is configured")
+	}
+
+	if name == engineVision && config.visionSecret == "" {
+		return errors.New("vision is not enabled: no credentials are configured")
+	}
+
+	return nil
+}
+
+// validateEngineRequest rejects options that depend on tesseract when another engine is
+// used: its other output formats, modes and language files; pages of multi-page sources
+// and spreads are also ocr'd by tesseract only. kraken's hocr lacks the blocks and
+// paragraphs that results derived from the hocr need.
+func validateEngineRequest(name string, req workflowRequestType, formats []string) error {
+	if name == engineTesseract {
+		return nil
+	}
+
+	for _, f := range formats {
+		if !hasFormat(engineFormats[name], f) {
+			return fmt.Errorf("output format not supported by %s: [%s]", name, f)
+		}
+	}
+
+	if req.Psm != 0 || req.Oem != nil || req.TessdataDir != "" || req.Reproducible || req.MultiPage || req.SplitSpread {
+		return fmt.Errorf("%s cannot be combined with psm, oem, tessdatadir, reproducible, multipage or splitspread", name)
+	}
+
+	if name == engineKraken && (req.Words || req.Layout || req.Epub) {
+		return errors.New("kraken cannot be combined with words, layout or epub")
+	}
+
+	return nil
+}
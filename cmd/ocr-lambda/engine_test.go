@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKrakenRecognizeArgs(t *testing.T) {
+	useConfig(t)
+	useWorkDir(t)
+
+	// the stub records each invocation's arguments on a line of its own
+	useStubCommands(t, map[string]string{
+		"kraken": `echo "$*" >> kraken-args`,
+	})
+
+	engine := krakenEngine{model: "models/hand.mlmodel"}
+
+	if err := engine.recognize("converted.tif", "results", "", []string{"txt", "tsv", "hocr", "alto"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	out, err := ioutil.ReadFile("kraken-args")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Split(strings.TrimSpace(string(out)), "\n")
+
+	want := []string{
+		"-i converted.tif results.txt -n segment -bl ocr -m models/hand.mlmodel",
+		"-i converted.tif results.hocr -h segment -bl ocr -m models/hand.mlmodel",
+		"-i converted.tif results.xml -a segment -bl ocr -m models/hand.mlmodel",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got kraken invocations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"time"
+)
+
+// identifies the book in an epub's package metadata
+type epubInfo struct {
+	identifier string
+	title      string
+	language   string
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubPackageTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">%s</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>%s</dc:language>
+    <meta property="dcterms:modified">%s</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+%s  </manifest>
+  <spine>
+%s  </spine>
+</package>
+`
+
+const epubPageTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>%s</title>
+</head>
+<body>
+%s</body>
+</html>
+`
+
+// a file within the epub container
+type epubFile struct {
+	name     string
+	contents string
+}
+
+func epubPageName(page int) string {
+	return fmt.Sprintf("page%04d.xhtml", page)
+}
+
+// epubPage renders a page's paragraphs as xhtml; the lines of a paragraph are joined,
+// so that readers can reflow them
+func epubPage(title string, paragraphs [][]string) string {
+	var body strings.Builder
+
+	for _, lines := range paragraphs {
+		fmt.Fprintf(&body, "  <p>%s</p>\n", html.EscapeString(strings.Join(lines, " ")))
+	}
+
+	return fmt.Sprintf(epubPageTemplate, html.EscapeString(title), body.String())
+}
+
+// epubNav renders the navigation document required by epub 3, listing each page
+func epubNav(title string, pages int) string {
+	var body strings.Builder
+
+	body.WriteString("  <nav epub:type=\"toc\">\n    <ol>\n")
+
+	for page := 1; page <= pages; page++ {
+		fmt.Fprintf(&body, "      <li><a href=\"%s\">Page %d</a></li>\n", epubPageName(page), page)
+	}
+
+	body.WriteString("    </ol>\n  </nav>\n")
+
+	return fmt.Sprintf(epubPageTemplate, html.EscapeString(title), body.String())
+}
+
+// saveEpub writes a minimal epub 3 book with a chapter per page of paragraphs
+func saveEpub(filename string, pages [][][]string, book epubInfo) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create epub: [%s]", err.Error())
+	}
+	defer f.Close()
+
+	var items, spine strings.Builder
+
+	files := []epubFile{
+		{"META-INF/container.xml", epubContainer},
+		{"OEBPS/nav.xhtml", epubNav(book.title, len(pages))},
+	}
+
+	for i, paragraphs := range pages {
+		name := epubPageName(i + 1)
+
+		fmt.Fprintf(&items, "    <item id=\"page%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, name)
+		fmt.Fprintf(&spine, "    <itemref idref=\"page%d\"/>\n", i+1)
+
+		files = append(files, epubFile{"OEBPS/" + name, epubPage(book.title, paragraphs)})
+	}
+
+	opf := fmt.Sprintf(epubPackageTemplate, html.EscapeString(book.identifier), html.EscapeString(book.title),
+		html.EscapeString(book.language), time.Now().UTC().Format("2006-01-02T15:04:05Z"), items.String(), spine.String())
+
+	files = append(files, epubFile{"OEBPS/content.opf", opf})
+
+	zw := zip.NewWriter(f)
+
+	// the mimetype must come first, uncompressed, so readers can identify the file
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err == nil {
+		_, err = w.Write([]byte("application/epub+zip"))
+	}
+
+	for _, file := range files {
+		if err != nil {
+			break
+		}
+
+		if w, err = zw.Create(file.name); err == nil {
+			_, err = w.Write([]byte(file.contents))
+		}
+	}
+
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err == nil {
+		err = f.Close()
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to save epub: [%s]", err.Error())
+	}
+
+	return nil
+}
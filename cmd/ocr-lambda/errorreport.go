@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// processing stages of an ocr request, as reported when it fails
+const (
+	stageSetup     = "setup"
+	stageDownload  = "download"
+	stageLanguages = "languages"
+	stageConvert   = "convert"
+	stageOcr       = "ocr"
+	stageResults   = "results"
+	stageUpload    = "upload"
+)
+
+// uploaded to the results prefix when a request fails, so that the workflow can
+// detect failures by polling s3
+type errorReportType struct {
+	Stage    string          `json:"stage"`
+	Error    string          `json:"error"`
+	TimedOut bool            `json:"timedout,omitempty"` // work was abandoned for lack of time; results may be partial
+	Time     string          `json:"time"`
+	Commands *commandHistory `json:"commands,omitempty"`
+}
+
+func errorReportFileName(resultsBase string) string {
+	return fmt.Sprintf("%s.error.json", resultsBase)
+}
+
+func saveErrorReport(resultsBase, stage string, failure error) {
+	report := errorReportType{
+		Stage:    stage,
+		Error:    failure.Error(),
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		Commands: cmds,
+	}
+
+	switch failure.(type) {
+	case *workDeadlineError, *stageTimeoutError:
+		report.TimedOut = true
+	}
+
+	reportText, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("WARNING: failed to serialize error report: [%s]", err.Error())
+		return
+	}
+
+	if err = ioutil.WriteFile(errorReportFileName(resultsBase), reportText, 0644); err != nil {
+		log.Printf("WARNING: failed to save error report: [%s]", err.Error())
+	}
+}
+
+// removeStaleErrorReport deletes the error report of an earlier failed attempt, so
+// that it is not mistaken for the outcome of a later successful one
+func removeStaleErrorReport(bucket, remoteResultsPrefix, resultsBase string) {
+	key := path.Join(remoteResultsPrefix, errorReportFileName(resultsBase))
+
+	// deleting a key that does not exist succeeds
+	if _, err := newS3Client(config.resultsS3).DeleteObject(&s3.DeleteObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: requestPayer(),
+	}); err != nil {
+		log.Printf("WARNING: failed to remove stale error report: s3://%s/%s [%s]", bucket, key, err.Error())
+	}
+}
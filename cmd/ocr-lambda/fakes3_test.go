@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// an in-memory, path-style s3 implementing the operations the lambda uses
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string]*fakeObject // by bucket/key
+	uploads  map[string]*fakeUpload // by upload id
+	requests []fakeS3Request
+	nextID   int
+
+	// if set, returns a status code to fail a request with (0 to handle it)
+	fail func(r *http.Request) int
+}
+
+type fakeObject struct {
+	data   []byte
+	header http.Header // request headers the object was stored with
+}
+
+type fakeUpload struct {
+	bucket    string
+	key       string
+	header    http.Header
+	parts     map[int][]byte
+	initiated time.Time
+}
+
+type fakeS3Request struct {
+	method string
+	path   string // bucket/key
+	query  url.Values
+	header http.Header
+}
+
+// useFakeS3 points every s3 client the lambda creates at a new fake s3 for the
+// duration of the test
+func useFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+
+	f := &fakeS3{objects: make(map[string]*fakeObject), uploads: make(map[string]*fakeUpload)}
+
+	server := httptest.NewServer(f)
+
+	savedSess, savedSource, savedResults := sess, config.sourceS3, config.resultsS3
+
+	sess = session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+
+	config.sourceS3 = s3EndpointConfig{endpoint: server.URL, forcePathStyle: true}
+	config.resultsS3 = config.sourceS3
+
+	t.Cleanup(func() {
+		server.Close()
+		sess, config.sourceS3, config.resultsS3 = savedSess, savedSource, savedResults
+	})
+
+	return f
+}
+
+func (f *fakeS3) put(bucket, key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.objects[bucket+"/"+key] = &fakeObject{data: data, header: http.Header{}}
+}
+
+// object returns a stored object, or nil
+func (f *fakeS3) object(bucket, key string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.objects[bucket+"/"+key]
+}
+
+// keys returns the keys stored in a bucket, sorted
+func (f *fakeS3) keys(bucket string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+
+	for k := range f.objects {
+		if strings.HasPrefix(k, bucket+"/") {
+			keys = append(keys, strings.TrimPrefix(k, bucket+"/"))
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// requestsFor returns the requests made with a method whose query has the given
+// parameter (any, if empty)
+func (f *fakeS3) requestsFor(method, param string) []fakeS3Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matched []fakeS3Request
+
+	for _, r := range f.requests {
+		if _, ok := r.query[param]; r.method == method && (param == "" || ok) {
+			matched = append(matched, r)
+		}
+	}
+
+	return matched
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	q := r.URL.Query()
+
+	f.requests = append(f.requests, fakeS3Request{method: r.Method, path: p, query: q, header: r.Header.Clone()})
+
+	if f.fail != nil {
+		if status := f.fail(r); status != 0 {
+			fakeS3Error(w, status, "InternalError")
+			return
+		}
+	}
+
+	bucket, key := p, ""
+	if i := strings.Index(p, "/"); i >= 0 {
+		bucket, key = p[:i], p[i+1:]
+	}
+
+	_, hasUploads := q["uploads"]
+	uploadID := q.Get("uploadId")
+
+	switch {
+	case r.Method == http.MethodPost && hasUploads:
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = &fakeUpload{bucket: bucket, key: key, header: r.Header.Clone(), parts: make(map[int][]byte), initiated: time.Now()}
+		fakeS3XML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+
+	case r.Method == http.MethodPut && uploadID != "":
+		u := f.uploads[uploadID]
+		if u == nil {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		u.parts[n] = body
+		w.Header().Set("ETag", fakeETag(body))
+
+	case r.Method == http.MethodPost && uploadID != "":
+		u := f.uploads[uploadID]
+		if u == nil {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		xml.Unmarshal(body, &complete)
+		var data []byte
+		for _, part := range complete.Parts {
+			partData, ok := u.parts[part.PartNumber]
+			if !ok || fakeETag(partData) != part.ETag {
+				fakeS3Error(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, partData...)
+		}
+		f.objects[bucket+"/"+key] = &fakeObject{data: data, header: u.header}
+		delete(f.uploads, uploadID)
+		fakeS3XML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: fakeETag(data)})
+
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet && hasUploads:
+		type upload struct {
+			Key       string
+			UploadId  string
+			Initiated string
+		}
+		var uploads []upload
+		for id, u := range f.uploads {
+			if u.bucket == bucket && strings.HasPrefix(u.key, q.Get("prefix")) {
+				uploads = append(uploads, upload{u.key, id, u.initiated.UTC().Format("2006-01-02T15:04:05.000Z")})
+			}
+		}
+		fakeS3XML(w, struct {
+			XMLName xml.Name `xml:"ListMultipartUploadsResult"`
+			Bucket  string
+			Uploads []upload `xml:"Upload"`
+		}{Bucket: bucket, Uploads: uploads})
+
+	case r.Method == http.MethodGet && uploadID != "":
+		u := f.uploads[uploadID]
+		if u == nil {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		type part struct {
+			PartNumber int
+			ETag       string
+			Size       int
+		}
+		var parts []part
+		for n, data := range u.parts {
+			parts = append(parts, part{n, fakeETag(data), len(data)})
+		}
+		sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+		fakeS3XML(w, struct {
+			XMLName xml.Name `xml:"ListPartsResult"`
+			Parts   []part   `xml:"Part"`
+		}{Parts: parts})
+
+	case r.Method == http.MethodGet && key == "":
+		type content struct {
+			Key  string
+			Size int
+		}
+		var contents []content
+		for k, obj := range f.objects {
+			if strings.HasPrefix(k, bucket+"/"+q.Get("prefix")) {
+				contents = append(contents, content{strings.TrimPrefix(k, bucket+"/"), len(obj.data)})
+			}
+		}
+		sort.Slice(contents, func(i, j int) bool { return contents[i].Key < contents[j].Key })
+		fakeS3XML(w, struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Name     string
+			KeyCount int
+			Contents []content `xml:"Contents"`
+		}{Name: bucket, KeyCount: len(contents), Contents: contents})
+
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		source, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("x-amz-copy-source"), "/"))
+		src := f.objects[source]
+		if src == nil {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.objects[bucket+"/"+key] = &fakeObject{data: src.data, header: r.Header.Clone()}
+		fakeS3XML(w, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string
+		}{ETag: fakeETag(src.data)})
+
+	case r.Method == http.MethodPut:
+		f.objects[bucket+"/"+key] = &fakeObject{data: body, header: r.Header.Clone()}
+		w.Header().Set("ETag", fakeETag(body))
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj := f.objects[bucket+"/"+key]
+		if obj == nil {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		data := obj.data
+		status := http.StatusOK
+
+		// ranged gets are used by the s3manager downloader
+		if rng := r.Header.Get("Range"); rng != "" {
+			var start, end int
+			fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			if end >= len(data) {
+				end = len(data) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+
+		if ct := obj.header.Get("Content-Type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", fakeETag(obj.data))
+		w.WriteHeader(status)
+
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+
+	default:
+		fakeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func fakeETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func fakeS3XML(w http.ResponseWriter, v interface{}) {
+	out, _ := xml.Marshal(v)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(out)
+}
+
+func fakeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// image formats we can identify from their leading bytes, and the magick coder for each
+type imageFormat struct {
+	name      string
+	extension string
+	coder     string
+	magic     [][]byte
+}
+
+var imageFormats = []imageFormat{
+	{
+		name:      "tiff",
+		extension: ".tif",
+		coder:     "tiff",
+		magic: [][]byte{
+			[]byte("II*\x00"), // little-endian
+			[]byte("MM\x00*"), // big-endian
+			[]byte("II+\x00"), // little-endian bigtiff
+			[]byte("MM\x00+"), // big-endian bigtiff
+		},
+	},
+	{
+		name:      "jpeg",
+		extension: ".jpg",
+		coder:     "jpeg",
+		magic:     [][]byte{[]byte("\xff\xd8\xff")},
+	},
+	{
+		name:      "png",
+		extension: ".png",
+		coder:     "png",
+		magic:     [][]byte{[]byte("\x89PNG\r\n\x1a\n")},
+	},
+	{
+		name:      "jp2",
+		extension: ".jp2",
+		coder:     "jp2",
+		magic: [][]byte{
+			[]byte("\x00\x00\x00\x0cjP  \r\n\x87\n"), // jp2 signature box
+			[]byte("\xff\x4f\xff\x51"),               // raw j2k codestream
+		},
+	},
+	{
+		name:      "pdf",
+		extension: ".pdf",
+		coder:     "pdf",
+		magic:     [][]byte{[]byte("%PDF-")},
+	},
+}
+
+// detectImageFormat identifies an image from its leading bytes, returning nil if unrecognized
+func detectImageFormat(header []byte) *imageFormat {
+	for i := range imageFormats {
+		for _, magic := range imageFormats[i].magic {
+			if bytes.HasPrefix(header, magic) {
+				return &imageFormats[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func detectImageFileFormat(filename string) (*imageFormat, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	return detectImageFormat(header[:n]), nil
+}
+
+// validateSourceKey rejects keys that cannot refer to an image file
+func validateSourceKey(key string) error {
+	if strings.HasSuffix(key, "/") || strings.Trim(key, "/") == "" {
+		return fmt.Errorf("source key refers to a directory, not an image: [%s]", key)
+	}
+
+	return nil
+}
+
+// canonicalizeSourceImage renames the downloaded image so its extension reflects its actual
+// contents, returning the new file name and the magick input specification for it
+func canonicalizeSourceImage(localSourceImage string) (string, string, error) {
+	info, err := os.Stat(localSourceImage)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to stat source image: [%s]", err.Error())
+	}
+
+	if info.Size() == 0 {
+		return "", "", fmt.Errorf("source image is empty: [%s]", localSourceImage)
+	}
+
+	format, err := detectImageFileFormat(localSourceImage)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read source image: [%s]", err.Error())
+	}
+
+	// leave unrecognized formats for magick to identify on its own
+	if format == nil {
+		return localSourceImage, fmt.Sprintf("%s[0]", localSourceImage), nil
+	}
+
+	canonical := fmt.Sprintf("source%s", format.extension)
+
+	if err = os.Rename(localSourceImage, canonical); err != nil {
+		return "", "", fmt.Errorf("failed to rename source image: [%s]", err.Error())
+	}
+
+	return canonical, fmt.Sprintf("%s:%s[0]", format.coder, canonical), nil
+}
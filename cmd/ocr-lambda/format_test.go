@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalizeSourceImage(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		data      string
+		wantFile  string
+		wantInput string
+		errMsg    string
+	}{
+		{name: "tiff", file: "image.jpg", data: "II*\x00rest", wantFile: "source.tif", wantInput: "tiff:source.tif[0]"},
+		{name: "big-endian bigtiff", file: "image", data: "MM\x00+rest", wantFile: "source.tif", wantInput: "tiff:source.tif[0]"},
+		{name: "jpeg", file: "image.tif", data: "\xff\xd8\xff\xe0rest", wantFile: "source.jpg", wantInput: "jpeg:source.jpg[0]"},
+		{name: "png", file: "image.png", data: "\x89PNG\r\n\x1a\nrest", wantFile: "source.png", wantInput: "png:source.png[0]"},
+		{name: "jp2", file: "image.tif", data: "\x00\x00\x00\x0cjP  \r\n\x87\nrest", wantFile: "source.jp2", wantInput: "jp2:source.jp2[0]"},
+		{name: "j2k codestream", file: "image.j2k", data: "\xff\x4f\xff\x51", wantFile: "source.jp2", wantInput: "jp2:source.jp2[0]"},
+		{name: "pdf", file: "image.tif", data: "%PDF-1.7\n", wantFile: "source.pdf", wantInput: "pdf:source.pdf[0]"},
+		{name: "unrecognized", file: "image.gif", data: "GIF89a", wantFile: "image.gif", wantInput: "image.gif[0]"},
+		{name: "shorter than a signature", file: "image.tif", data: "II", wantFile: "image.tif", wantInput: "image.tif[0]"},
+		{name: "empty", file: "image.tif", errMsg: "source image is empty"},
+		{name: "missing", errMsg: "failed to stat source image"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useWorkDir(t)
+
+			file := "missing.tif"
+
+			if tc.file != "" {
+				file = tc.file
+				if err := ioutil.WriteFile(file, []byte(tc.data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			gotFile, gotInput, err := canonicalizeSourceImage(file)
+
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Fatalf("got error %v, want one containing %q", err, tc.errMsg)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if gotFile != tc.wantFile || gotInput != tc.wantInput {
+				t.Errorf("got [%s] [%s], want [%s] [%s]", gotFile, gotInput, tc.wantFile, tc.wantInput)
+			}
+
+			if data, _ := ioutil.ReadFile(gotFile); string(data) != tc.data {
+				t.Errorf("got contents %q, want %q", data, tc.data)
+			}
+
+			if gotFile != file {
+				if _, err = os.Stat(file); !os.IsNotExist(err) {
+					t.Errorf("original file %s was not renamed", file)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSourceKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{"images/a.tif", false},
+		{"a", false},
+		{"images/", true},
+		{"/", true},
+		{"//", true},
+		{"", true},
+	}
+
+	for _, tc := range tests {
+		if err := validateSourceKey(tc.key); (err != nil) != tc.wantErr {
+			t.Errorf("%q: got error %v, want error %t", tc.key, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// output formats tesseract can produce for us; "txt" is always produced.
+// results are saved with the format name as extension, except for alto (.xml)
+var supportedFormats = []string{"txt", "hocr", "pdf", "tsv", "alto"}
+
+func isSupportedFormat(format string) bool {
+	return hasFormat(supportedFormats, format)
+}
+
+func normalizeFormats(formats []string) ([]string, error) {
+	var normalized []string
+
+	for _, f := range formats {
+		f = strings.ToLower(strings.TrimSpace(f))
+
+		if f == "" {
+			continue
+		}
+
+		if !isSupportedFormat(f) {
+			return nil, fmt.Errorf("unsupported output format: [%s] (must be one of: %s)", f, strings.Join(supportedFormats, ", "))
+		}
+
+		if !hasFormat(normalized, f) {
+			normalized = append(normalized, f)
+		}
+	}
+
+	return normalized, nil
+}
+
+// resolveFormats determines the additional (non-txt) output formats for a request.
+// requested formats are limited to those the operator allows; when none are
+// requested, the defaults for the invocation path are used instead.
+func resolveFormats(requested, defaults, allowed []string) ([]string, error) {
+	if len(requested) == 0 {
+		return additionalFormats(defaults), nil
+	}
+
+	formats, err := normalizeFormats(requested)
+	if err != nil {
+		return nil, err
+	}
+
+	var effective []string
+
+	for _, f := range formats {
+		if f == "txt" || hasFormat(allowed, f) {
+			effective = append(effective, f)
+		}
+	}
+
+	if len(effective) == 0 {
+		return nil, fmt.Errorf("none of the requested output formats are enabled: [%s] (enabled: %s)", strings.Join(formats, ", "), strings.Join(allowed, ", "))
+	}
+
+	return additionalFormats(effective), nil
+}
+
+func additionalFormats(formats []string) []string {
+	additional := []string{}
+
+	for _, f := range formats {
+		if f != "txt" {
+			additional = append(additional, f)
+		}
+	}
+
+	return additional
+}
+
+func validateFormatConfig(defaults, allowed []string) error {
+	for _, f := range defaults {
+		if f != "txt" && !hasFormat(allowed, f) {
+			return fmt.Errorf("default output format is not in the allowed set: [%s]", f)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return errors.New("allowed output formats must not be empty")
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveFormats(t *testing.T) {
+	defaults := []string{"txt", "hocr"}
+	allowed := []string{"hocr", "pdf", "alto"}
+
+	tests := []struct {
+		name      string
+		requested []string
+		want      []string
+		errMsg    string
+	}{
+		{name: "none requested", want: []string{"hocr"}},
+		{name: "txt only", requested: []string{"txt"}, want: []string{}},
+		{name: "allowed", requested: []string{"pdf", "alto"}, want: []string{"pdf", "alto"}},
+		{name: "normalized", requested: []string{" PDF ", "", "pdf", "Hocr"}, want: []string{"pdf", "hocr"}},
+		{name: "disallowed dropped", requested: []string{"tsv", "pdf"}, want: []string{"pdf"}},
+		{name: "txt and disallowed", requested: []string{"txt", "tsv"}, want: []string{}},
+		{name: "only blanks", requested: []string{" ", ""}, errMsg: "none of the requested output formats are enabled"},
+		{name: "only disallowed", requested: []string{"tsv"}, errMsg: "none of the requested output formats are enabled: [tsv] (enabled: hocr, pdf, alto)"},
+		{name: "unsupported", requested: []string{"pdf", "docx"}, errMsg: "unsupported output format: [docx]"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveFormats(tc.requested, defaults, allowed)
+
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Fatalf("got error %v, want one containing %q", err, tc.errMsg)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateFormatConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults []string
+		allowed  []string
+		wantErr  bool
+	}{
+		{name: "valid", defaults: []string{"txt", "hocr"}, allowed: []string{"hocr", "pdf"}},
+		{name: "txt default", defaults: []string{"txt"}, allowed: []string{"pdf"}},
+		{name: "default not allowed", defaults: []string{"pdf"}, allowed: []string{"hocr"}, wantErr: true},
+		{name: "nothing allowed", defaults: []string{"txt"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateFormatConfig(tc.defaults, tc.allowed); (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// minimal model of the hocr produced by tesseract for a single page
+type hocrBox struct {
+	X0 int `json:"x0"`
+	Y0 int `json:"y0"`
+	X1 int `json:"x1"`
+	Y1 int `json:"y1"`
+}
+
+type hocrWord struct {
+	ID   string
+	BBox hocrBox
+	Conf int
+	Lang string
+	Text string
+}
+
+type hocrLine struct {
+	ID    string
+	BBox  hocrBox
+	Words []hocrWord
+}
+
+type hocrPar struct {
+	ID    string
+	BBox  hocrBox
+	Lang  string
+	Lines []hocrLine
+}
+
+type hocrBlock struct {
+	ID   string
+	BBox hocrBox
+	Pars []hocrPar
+}
+
+type hocrPage struct {
+	ID     string
+	BBox   hocrBox
+	Blocks []hocrBlock
+}
+
+// classes tesseract uses for line-level elements
+var hocrLineClasses = []string{"ocr_line", "ocr_caption", "ocr_header", "ocr_textfloat"}
+
+// parseHocrTitle extracts the bbox and word confidence from an hocr title attribute,
+// e.g. "bbox 36 92 618 134; x_wconf 95"
+func parseHocrTitle(title string) (hocrBox, int) {
+	var box hocrBox
+	conf := -1
+
+	for _, prop := range strings.Split(title, ";") {
+		fields := strings.Fields(prop)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "bbox":
+			if len(fields) == 5 {
+				box.X0, _ = strconv.Atoi(fields[1])
+				box.Y0, _ = strconv.Atoi(fields[2])
+				box.X1, _ = strconv.Atoi(fields[3])
+				box.Y1, _ = strconv.Atoi(fields[4])
+			}
+
+		case "x_wconf":
+			if len(fields) == 2 {
+				conf, _ = strconv.Atoi(fields[1])
+			}
+		}
+	}
+
+	return box, conf
+}
+
+func parseHocr(r io.Reader) (*hocrPage, error) {
+	page := &hocrPage{}
+
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	// classes of currently open elements, so we know when a word ends
+	var stack []string
+	var word *hocrWord
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse hocr: [%s]", err.Error())
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var class, id, title, lang string
+
+			for _, a := range t.Attr {
+				switch a.Name.Local {
+				case "class":
+					class = a.Value
+				case "id":
+					id = a.Value
+				case "title":
+					title = a.Value
+				case "lang":
+					lang = a.Value
+				}
+			}
+
+			stack = append(stack, class)
+
+			box, conf := parseHocrTitle(title)
+
+			switch {
+			case class == "ocr_page":
+				page.ID = id
+				page.BBox = box
+
+			case class == "ocr_carea":
+				page.Blocks = append(page.Blocks, hocrBlock{ID: id, BBox: box})
+
+			case class == "ocr_par":
+				if b := page.lastBlock(); b != nil {
+					b.Pars = append(b.Pars, hocrPar{ID: id, BBox: box, Lang: lang})
+				}
+
+			case hasFormat(hocrLineClasses, class):
+				if p := page.lastPar(); p != nil {
+					p.Lines = append(p.Lines, hocrLine{ID: id, BBox: box})
+				}
+
+			case class == "ocrx_word":
+				if l := page.lastLine(); l != nil {
+					if lang == "" {
+						lang = page.lastPar().Lang
+					}
+					l.Words = append(l.Words, hocrWord{ID: id, BBox: box, Conf: conf, Lang: lang})
+					word = &l.Words[len(l.Words)-1]
+				}
+			}
+
+		case xml.EndElement:
+			if len(stack) > 0 {
+				if stack[len(stack)-1] == "ocrx_word" {
+					word = nil
+				}
+				stack = stack[:len(stack)-1]
+			}
+
+		case xml.CharData:
+			if word != nil {
+				word.Text += string(t)
+			}
+		}
+	}
+
+	return page, nil
+}
+
+func parseHocrFile(filename string) (*hocrPage, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hocr file: [%s]", err.Error())
+	}
+	defer f.Close()
+
+	return parseHocr(f)
+}
+
+func (p *hocrPage) lastBlock() *hocrBlock {
+	if len(p.Blocks) == 0 {
+		return nil
+	}
+
+	return &p.Blocks[len(p.Blocks)-1]
+}
+
+func (p *hocrPage) lastPar() *hocrPar {
+	b := p.lastBlock()
+	if b == nil || len(b.Pars) == 0 {
+		return nil
+	}
+
+	return &b.Pars[len(b.Pars)-1]
+}
+
+func (p *hocrPage) lastLine() *hocrLine {
+	par := p.lastPar()
+	if par == nil || len(par.Lines) == 0 {
+		return nil
+	}
+
+	return &par.Lines[len(par.Lines)-1]
+}
+
+// words returns every word in the block, in document order
+func (b *hocrBlock) words() []hocrWord {
+	var words []hocrWord
+
+	for _, par := range b.Pars {
+		for _, line := range par.Lines {
+			words = append(words, line.Words...)
+		}
+	}
+
+	return words
+}
+
+// dominant recognition language of a text block, for multi-language requests
+type blockLanguageType struct {
+	ID    string  `json:"id,omitempty"`
+	BBox  hocrBox `json:"bbox"`
+	Lang  string  `json:"lang,omitempty"`
+	Words int     `json:"words"`
+}
+
+func getBlockLanguages(page *hocrPage) []blockLanguageType {
+	var blocks []blockLanguageType
+
+	for _, b := range page.Blocks {
+		words := b.words()
+
+		// count words per language, remembering first-seen order to break ties
+		counts := make(map[string]int)
+		var order []string
+
+		for _, w := range words {
+			if w.Lang == "" {
+				continue
+			}
+			if counts[w.Lang] == 0 {
+				order = append(order, w.Lang)
+			}
+			counts[w.Lang]++
+		}
+
+		dominant := ""
+		for _, l := range order {
+			if counts[l] > counts[dominant] {
+				dominant = l
+			}
+		}
+
+		blocks = append(blocks, blockLanguageType{ID: b.ID, BBox: b.BBox, Lang: dominant, Words: len(words)})
+	}
+
+	return blocks
+}
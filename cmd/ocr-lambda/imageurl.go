@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// http client for source images fetched by url (e.g. from the iiif server)
+var imageURLClient *http.Client
+
+// validateImageURL ensures a source image url is well-formed and refers to a server
+// the operator has allowed, so requests cannot make the lambda fetch arbitrary urls
+func validateImageURL(imageURL string) error {
+	u, err := url.Parse(imageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid image url: [%s]", imageURL)
+	}
+
+	if len(config.imageURLPrefixes) == 0 {
+		return fmt.Errorf("image urls are not enabled: [%s]", imageURL)
+	}
+
+	for _, prefix := range config.imageURLPrefixes {
+		if strings.HasPrefix(imageURL, prefix) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("image url is not on an allowed server: [%s]", imageURL)
+}
+
+// imageURLName returns a file name for the image at a url; iiif image urls end
+// in e.g. "default.jpg", so this is mostly useful for its extension
+func imageURLName(imageURL string) string {
+	name := "image"
+
+	if u, err := url.Parse(imageURL); err == nil {
+		if base := path.Base(u.Path); base != "/" && base != "." {
+			name = base
+		}
+	}
+
+	return name
+}
+
+// downloadImageURL fetches a source image over http(s), retrying transient failures
+func downloadImageURL(imageURL, localFile string) (int64, error) {
+	policy := downloadRetries()
+	delay := policy.delay
+
+	for attempt := 0; ; attempt++ {
+		bytes, retryAfter, retryable, err := downloadImageURLAttempt(imageURL, localFile)
+		if err == nil {
+			return bytes, nil
+		}
+
+		retry := retryable && attempt < policy.maxRetries && !workTimedOut()
+
+		info := retryInfo{Operation: "image url download", Attempt: attempt + 1, Error: err.Error(), Retryable: retryable}
+
+		if !retry {
+			cmds.addRetry(info)
+			return -1, err
+		}
+
+		// honor the server's requested delay if given, otherwise back off exponentially with jitter
+		wait := retryAfter
+		if wait == 0 {
+			wait = withJitter(delay)
+		}
+
+		info.Delay = wait.String()
+		cmds.addRetry(info)
+
+		log.Printf("image url download: attempt %d of %d failed; retrying in %v: [%s]", attempt+1, policy.maxRetries+1, wait, err.Error())
+
+		time.Sleep(wait)
+		delay *= 2
+	}
+}
+
+// downloadImageURLAttempt makes a single download attempt, indicating whether a failure is worth retrying
+func downloadImageURLAttempt(imageURL, localFile string) (int64, time.Duration, bool, error) {
+	log.Printf("downloading image: %s => %s", imageURL, localFile)
+
+	req, err := http.NewRequestWithContext(workCtx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return -1, 0, false, fmt.Errorf("invalid image url: [%s]", err.Error())
+	}
+
+	res, err := imageURLClient.Do(req)
+	if err != nil {
+		return -1, 0, true, fmt.Errorf("failed to download image url: [%s]", err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("failed to download image url: [%s] (%s)", imageURL, res.Status)
+
+		switch res.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return -1, parseRetryAfter(res.Header.Get("Retry-After")), true, err
+		}
+
+		return -1, 0, false, err
+	}
+
+	// the length is unknown for e.g. chunked responses, which are common for images
+	// the iiif server generates on the fly
+	if res.ContentLength > 0 {
+		if err = checkDiskSpace(filepath.Dir(localFile), res.ContentLength, "source image"); err != nil {
+			return -1, 0, false, err
+		}
+	}
+
+	f, err := os.Create(localFile)
+	if err != nil {
+		return -1, 0, false, fmt.Errorf("failed to create local file: [%s]", err.Error())
+	}
+	defer f.Close()
+
+	// a dropped connection mid-transfer is worth retrying
+	bytes, err := io.Copy(f, res.Body)
+	if err != nil {
+		return -1, 0, true, fmt.Errorf("failed to download image url: [%s]", err.Error())
+	}
+
+	if res.ContentLength > 0 && bytes != res.ContentLength {
+		return -1, 0, true, fmt.Errorf("incomplete_download: received %d of %d bytes for %s", bytes, res.ContentLength, imageURL)
+	}
+
+	if err = f.Close(); err != nil {
+		return -1, 0, false, fmt.Errorf("failed to save downloaded image: [%s]", err.Error())
+	}
+
+	log.Printf("downloaded %d bytes", bytes)
+
+	return bytes, 0, false, nil
+}
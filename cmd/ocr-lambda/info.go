@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// set at build time (see Makefile)
+var gitCommit string
+
+// lambda build metadata and bundled tool versions, for checking compatibility with ocr-ws
+type engineInfoResponseType struct {
+	GitCommit string   `json:"gitcommit,omitempty"`
+	GoVersion string   `json:"goversion,omitempty"`
+	Tesseract string   `json:"tesseract,omitempty"`
+	Magick    string   `json:"magick,omitempty"`
+	Languages []string `json:"languages,omitempty"` // traineddata currently available without downloading
+	Formats   []string `json:"formats,omitempty"`   // output formats enabled by the operator
+}
+
+func getInstalledLanguages() []string {
+	var langs []string
+
+	matches, _ := filepath.Glob(fmt.Sprintf("%s/*.traineddata", os.Getenv("TESSDATA_PREFIX")))
+
+	for _, m := range matches {
+		langs = append(langs, strings.TrimSuffix(filepath.Base(m), ".traineddata"))
+	}
+
+	sort.Strings(langs)
+
+	return langs
+}
+
+func handleEngineInfoRequest() (string, error) {
+	log.Print("handling engine info request")
+
+	cmds = &commandHistory{}
+
+	res := engineInfoResponseType{
+		GitCommit: gitCommit,
+		GoVersion: runtime.Version(),
+		Tesseract: getTesseractVersion(),
+		Magick:    getMagickVersion(),
+		Languages: getInstalledLanguages(),
+		Formats:   config.allowedFormats,
+	}
+
+	output, err := json.Marshal(res)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize output: [%s]", err.Error())
+	}
+
+	return string(output), nil
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a stand-in for magick: reports a fixed version and image size, and "converts" by
+// writing a description of its input, failing for images containing "corrupt"
+const stubMagick = `
+case "$1" in
+--version) echo "Version: ImageMagick 7.0.11-2 Q16 x86_64 2021-03-06 https://imagemagick.org"; exit 0 ;;
+identify) echo "1200 1600"; exit 0 ;;
+convert) ;;
+*) echo "magick: unexpected arguments: $*" >&2; exit 1 ;;
+esac
+
+# the input precedes the resize options, and the output is last
+prev=
+for arg; do
+	[ "$arg" = "-filter" ] && input=$prev
+	prev=$arg
+	output=$arg
+done
+
+file=${input#*:}
+file=${file%\[*\]}
+
+if grep -q corrupt "$file"; then
+	echo "magick: improper image header '$file'" >&2
+	exit 1
+fi
+
+echo "converted $file" > "$output"
+`
+
+// a stand-in for tesseract: fails like tesseract if a language file is missing, and
+// otherwise writes each requested format, with one word recognized at 90% confidence
+const stubTesseract = `
+if [ "$1" = "--version" ]; then echo "tesseract 4.1.1"; exit 0; fi
+
+image=$1
+base=$2
+shift 2
+
+formats=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--psm|--oem|-c) shift 2 ;;
+	-l) lang=$2; shift 2 ;;
+	*) formats="$formats $1"; shift ;;
+	esac
+done
+
+for l in $(echo "$lang" | tr + ' '); do
+	if [ ! -f "$TESSDATA_PREFIX/$l.traineddata" ]; then
+		echo "Failed loading language '$l'" >&2
+		exit 1
+	fi
+done
+
+for f in $formats; do
+	case $f in
+	txt) printf 'text of %s in %s\n' "$image" "$lang" > "$base.txt" ;;
+	tsv) printf 'level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n5\t1\t1\t1\t1\t1\t10\t10\t50\t20\t90\ttext\n' > "$base.tsv" ;;
+	hocr) printf '<html><body><div class="ocr_page" title="bbox 0 0 1200 1600"></div></body></html>\n' > "$base.hocr" ;;
+	*) printf '%s of %s\n' "$f" "$image" > "$base.$f" ;;
+	esac
+done
+`
+
+// a stand-in for ghostscript's pdf rewriting: copies the input pdf, noting the image resolution
+const stubGhostscript = `
+for arg; do
+	case "$arg" in
+	-dColorImageResolution=*) res=${arg#*=} ;;
+	esac
+	[ "$prev" = "-o" ] && output=$arg
+	prev=$arg
+	input=$arg
+done
+
+cat "$input" > "$output"
+echo "images at $res dpi" >> "$output"
+`
+
+// the leading bytes of a png, which is all the lambda itself reads of a source image
+const testPng = "\x89PNG\r\n\x1a\n page image"
+
+// a test environment in which whole requests run against fakes: s3, the magick and
+// tesseract binaries, and the server language files are downloaded from
+type integrationEnv struct {
+	s3          *fakeS3
+	tessdataDir string
+
+	mu        sync.Mutex
+	languages map[string]string // served language files, by name
+	fetched   []string          // paths of language file requests
+}
+
+func useIntegrationEnv(t *testing.T) *integrationEnv {
+	t.Helper()
+
+	useConfig(t)
+
+	env := &integrationEnv{
+		s3:          useFakeS3(t),
+		tessdataDir: t.TempDir(),
+		languages:   map[string]string{"fra": "fra model", "deu": "deu model"},
+	}
+
+	useStubCommands(t, map[string]string{
+		"magick":    stubMagick,
+		"tesseract": stubTesseract,
+		"gs":        stubGhostscript,
+		"ldd":       "exit 0",
+	})
+
+	// the bundled language files
+	for _, l := range []string{"osd", "eng"} {
+		if err := ioutil.WriteFile(filepath.Join(env.tessdataDir, l+".traineddata"), []byte(l+" model"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	savedTessdata := os.Getenv("TESSDATA_PREFIX")
+	os.Setenv("TESSDATA_PREFIX", env.tessdataDir)
+
+	// requests finish in the root directory
+	savedDir, _ := os.Getwd()
+
+	server := httptest.NewServer(http.HandlerFunc(env.serveLanguage))
+
+	t.Cleanup(func() {
+		server.Close()
+		os.Setenv("TESSDATA_PREFIX", savedTessdata)
+		os.Chdir(savedDir)
+	})
+
+	config.tessdataURL = server.URL
+	config.tessdataType = "fast"
+	config.tessdataVersion = "4.0.0"
+	config.tessdataCacheBucket = ""
+	config.tessdataMaxRetries = 0
+	config.precacheLanguages = nil
+	config.settingsSource = ""
+	config.dedupIndexPrefix = ""
+	config.archiveSourcePrefix = ""
+	config.jobStatusTable = ""
+	config.metricsNamespace = ""
+	config.notifyTopicArn = ""
+	config.autoRotate = false
+	config.streamSourceImage = false
+	config.lowConfidenceThreshold = 0
+	config.originalImagePdf = false
+	config.allowedFormats = []string{"hocr", "pdf", "tsv"}
+	config.workflowFormats = []string{"hocr"}
+	config.standaloneFormats = []string{"hocr", "pdf"}
+
+	return env
+}
+
+// serveLanguage serves language files as github does, e.g. /tessdata_fast/raw/4.0.0/fra.traineddata
+func (env *integrationEnv) serveLanguage(w http.ResponseWriter, r *http.Request) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	env.fetched = append(env.fetched, r.URL.Path)
+
+	dir, name := filepath.Split(r.URL.Path)
+
+	model, ok := env.languages[strings.TrimSuffix(name, ".traineddata")]
+	if !ok || dir != "/tessdata_fast/raw/4.0.0/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Write([]byte(model))
+}
+
+func (env *integrationEnv) languageRequests() []string {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	return append([]string{}, env.fetched...)
+}
+
+// objectJSON decodes an uploaded object, failing the test if it is missing
+func (env *integrationEnv) objectJSON(t *testing.T, bucket, key string, v interface{}) {
+	t.Helper()
+
+	obj := env.s3.object(bucket, key)
+	if obj == nil {
+		t.Fatalf("object was not uploaded: [s3://%s/%s]", bucket, key)
+	}
+
+	if err := json.Unmarshal(obj.data, v); err != nil {
+		t.Fatalf("failed to parse s3://%s/%s: %s", bucket, key, err.Error())
+	}
+}
+
+func TestIntegrationWorkflowRequest(t *testing.T) {
+	env := useIntegrationEnv(t)
+
+	env.s3.put("bucket", "images/page.png", []byte(testPng))
+
+	req := lambdaRequestType{workflowRequestType: workflowRequestType{
+		Bucket:    "bucket",
+		Key:       "images/page.png",
+		Pid:       "uva-lib:2",
+		ParentPid: "uva-lib:1",
+		Lang:      "eng+fra",
+		Scale:     "50",
+	}}
+
+	output, err := handleOcrRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var res workflowResponseType
+
+	if err = json.Unmarshal([]byte(output), &res); err != nil {
+		t.Fatalf("failed to parse response: %s", err.Error())
+	}
+
+	if want := "text of source-converted.tif in eng+fra\n"; res.Text != want {
+		t.Errorf("got text %q, want %q", res.Text, want)
+	}
+
+	if !reflect.DeepEqual(res.Formats, []string{"txt", "hocr"}) {
+		t.Errorf("got formats %v", res.Formats)
+	}
+
+	if res.Confidence == nil || *res.Confidence != 90 || !reflect.DeepEqual(res.PageWords, []int{1}) {
+		t.Errorf("got confidence %v over %v words", res.Confidence, res.PageWords)
+	}
+
+	prefix := "results/uva-lib:1/uva-lib:2/50/"
+
+	wantKeys := []string{
+		"images/page.png",
+		prefix + "results.blocks.json",
+		prefix + "results.hocr",
+		prefix + "results.json",
+		prefix + "results.log",
+		prefix + "results.params.json",
+		prefix + "results.txt",
+	}
+
+	if got := env.s3.keys("bucket"); !reflect.DeepEqual(got, wantKeys) {
+		t.Errorf("got keys %v, want %v", got, wantKeys)
+	}
+
+	if got := string(env.s3.object("bucket", prefix+"results.txt").data); got != res.Text {
+		t.Errorf("uploaded text %q differs from the response", got)
+	}
+
+	var manifest resultsManifest
+
+	env.objectJSON(t, "bucket", prefix+"results.json", &manifest)
+
+	if manifest.Status != manifestSuccess || manifest.Tesseract != "4.1.1" || manifest.Magick != "7.0.11-2" || manifest.Scale != "50" {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+
+	// only the language missing locally is downloaded
+	if got, want := env.languageRequests(), []string{"/tessdata_fast/raw/4.0.0/fra.traineddata"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got language requests %v, want %v", got, want)
+	}
+}
+
+func TestIntegrationDuplicateRequest(t *testing.T) {
+	env := useIntegrationEnv(t)
+
+	config.dedupIndexPrefix = "internal/dedup"
+
+	env.s3.put("bucket", "images/page.png", []byte(testPng))
+
+	request := func(pid string) workflowResponseType {
+		req := lambdaRequestType{workflowRequestType: workflowRequestType{
+			Bucket:  "bucket",
+			Key:     "images/page.png",
+			Pid:     pid,
+			Lang:    "eng+fra",
+			Scale:   "50",
+			Formats: []string{"hocr", "tsv"},
+			Cleanup: true,
+		}}
+
+		output, err := handleOcrRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+
+		var res workflowResponseType
+
+		if err = json.Unmarshal([]byte(output), &res); err != nil {
+			t.Fatalf("failed to parse response: %s", err.Error())
+		}
+
+		return res
+	}
+
+	fresh := request("uva-lib:2")
+	duplicate := request("uva-lib:3")
+
+	if duplicate.DuplicateOf != "s3://bucket/results/uva-lib:2/50" {
+		t.Fatalf("got duplicate of %q, want the first request's results", duplicate.DuplicateOf)
+	}
+
+	// apart from where the results came from, the responses are the same
+	duplicate.DuplicateOf = ""
+
+	if !reflect.DeepEqual(duplicate, fresh) {
+		t.Errorf("got duplicate response %+v, want %+v", duplicate, fresh)
+	}
+}
+
+func TestIntegrationPdfDpi(t *testing.T) {
+	env := useIntegrationEnv(t)
+
+	env.s3.put("bucket", "images/page.png", []byte(testPng))
+
+	req := lambdaRequestType{workflowRequestType: workflowRequestType{Bucket: "bucket", Key: "images/page.png", Pid: "uva-lib:2", Formats: []string{"pdf"}, PdfDpi: 150}}
+
+	if _, err := handleOcrRequest(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// the pdf is downsampled after ocr, rather than tesseract being told the image resolution
+	if got, want := string(env.s3.object("bucket", "results/uva-lib:2/results.pdf").data), "pdf of source-converted.tif\nimages at 150 dpi\n"; got != want {
+		t.Errorf("got pdf %q, want %q", got, want)
+	}
+
+	for _, cmd := range cmds.Commands {
+		if cmd.Command == "tesseract" && strings.Contains(strings.Join(cmd.Arguments, " "), "user_defined_dpi") {
+			t.Errorf("tesseract was given the pdf resolution: %v", cmd.Arguments)
+		}
+	}
+}
+
+func TestIntegrationStandaloneRequest(t *testing.T) {
+	env := useIntegrationEnv(t)
+
+	env.s3.put("bucket", "standalone/requests/box1/page.png", []byte(testPng))
+
+	rec := s3RecordType{EventName: "ObjectCreated:Put"}
+	rec.S3.Bucket.Name = "bucket"
+	rec.S3.Object.Key = "standalone/requests/box1/page.png"
+
+	output, err := handleOcrRequest(context.Background(), lambdaRequestType{s3MessageEventType: s3MessageEventType{Records: []s3RecordType{rec}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var res workflowResponseType
+
+	if err = json.Unmarshal([]byte(output), &res); err != nil {
+		t.Fatalf("failed to parse response: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(res.Formats, []string{"txt", "hocr", "pdf"}) {
+		t.Errorf("got formats %v", res.Formats)
+	}
+
+	if res.Provenance == nil || res.Provenance.EventName != "ObjectCreated:Put" {
+		t.Errorf("got provenance %+v", res.Provenance)
+	}
+
+	prefix := "standalone/results/box1/page.png/"
+
+	for _, name := range []string{"results.txt", "results.hocr", "results.pdf", "results.json", "results.log"} {
+		if env.s3.object("bucket", prefix+name) == nil {
+			t.Errorf("result was not uploaded: %s", prefix+name)
+		}
+	}
+
+	if len(env.languageRequests()) != 0 {
+		t.Errorf("bundled languages were downloaded: %v", env.languageRequests())
+	}
+}
+
+func TestIntegrationSnsRequestWithSeveralRecords(t *testing.T) {
+	env := useIntegrationEnv(t)
+
+	var sns lambdaRequestType
+
+	// the first message carries two records
+	for _, keys := range [][]string{{"standalone/requests/a.png", "standalone/requests/b.png"}, {"standalone/requests/c.png"}} {
+		var event s3MessageEventType
+
+		for _, key := range keys {
+			env.s3.put("bucket", key, []byte(testPng))
+
+			var rec s3RecordType
+			rec.S3.Bucket.Name = "bucket"
+			rec.S3.Object.Key = key
+
+			event.Records = append(event.Records, rec)
+		}
+
+		message, _ := json.Marshal(event)
+
+		sns.Records = append(sns.Records, s3RecordType{EventSource: snsEventSource, Sns: snsMessageType{Message: string(message)}})
+	}
+
+	output, err := handleOcrRequest(context.Background(), sns)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var outputs []workflowResponseType
+
+	if err = json.Unmarshal([]byte(output), &outputs); err != nil {
+		t.Fatalf("failed to parse response: %s", err.Error())
+	}
+
+	if len(outputs) != 3 {
+		t.Fatalf("got %d responses, want 3", len(outputs))
+	}
+
+	for _, prefix := range []string{"standalone/results/a.png/", "standalone/results/b.png/", "standalone/results/c.png/"} {
+		if env.s3.object("bucket", prefix+"results.txt") == nil {
+			t.Errorf("results were not uploaded under %s", prefix)
+		}
+	}
+}
+
+func TestIntegrationFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		image  string // uploaded source image, if any
+		lang   string
+		stage  string
+		errMsg string
+	}{
+		{name: "missing source image", lang: "eng", stage: stageDownload, errMsg: "failed to get s3 file info"},
+		{name: "unavailable language", image: testPng, lang: "eng+xyz", stage: stageLanguages, errMsg: "failed to download language files"},
+		{name: "corrupt image", image: testPng + " corrupt", lang: "eng", stage: stageConvert, errMsg: "improper image header"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			env := useIntegrationEnv(t)
+
+			if tc.image != "" {
+				env.s3.put("bucket", "images/page.png", []byte(tc.image))
+			}
+
+			req := lambdaRequestType{workflowRequestType: workflowRequestType{Bucket: "bucket", Key: "images/page.png", Pid: "uva-lib:2", Lang: tc.lang}}
+
+			_, err := handleOcrRequest(context.Background(), req)
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Fatalf("got error %v, want one containing %q", err, tc.errMsg)
+			}
+
+			// the failure is reported alongside whatever results there are
+			var report errorReportType
+
+			env.objectJSON(t, "bucket", "results/uva-lib:2/results.error.json", &report)
+
+			if report.Stage != tc.stage || report.Error != err.Error() {
+				t.Errorf("got error report for stage %q: %q", report.Stage, report.Error)
+			}
+
+			var manifest resultsManifest
+
+			env.objectJSON(t, "bucket", "results/uva-lib:2/results.json", &manifest)
+
+			if manifest.Status != manifestFailure || manifest.Error != err.Error() {
+				t.Errorf("got manifest status %q: %q", manifest.Status, manifest.Error)
+			}
+
+			if env.s3.object("bucket", "results/uva-lib:2/results.txt") != nil {
+				t.Error("results text was uploaded for a failed request")
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// states of an ocr job, as recorded in the job status table
+const (
+	jobReceived    = "received"
+	jobDownloading = "downloading"
+	jobConverting  = "converting"
+	jobOcring      = "ocring"
+	jobUploading   = "uploading"
+	jobDone        = "done"
+	jobFailed      = "failed"
+)
+
+// records the state transitions of the request being handled in the job status table,
+// keyed by pid (or source location, for standalone requests) and lambda request id
+type jobTracker struct {
+	ctx       context.Context
+	svc       *dynamodb.DynamoDB
+	pid       string
+	requestID string
+}
+
+var jobs *jobTracker
+
+// startJobTracking begins tracking a request, if a job status table is configured
+func startJobTracking(ctx context.Context, pid string) *jobTracker {
+	if config.jobStatusTable == "" {
+		return nil
+	}
+
+	requestID := "local"
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestID = lc.AwsRequestID
+	}
+
+	j := &jobTracker{ctx: ctx, svc: dynamodb.New(sess), pid: pid, requestID: requestID}
+
+	j.transition(jobReceived)
+
+	return j
+}
+
+// update sets the given attributes of the job's item (along with its state change
+// time and expiry); failures are logged, as tracking is not essential to the job
+func (j *jobTracker) update(attrs map[string]string, numbers map[string]int) {
+	now := time.Now().UTC()
+
+	names := map[string]*string{"#updated": aws.String("updated"), "#expires": aws.String("expires")}
+	values := map[string]*dynamodb.AttributeValue{
+		":updated": {S: aws.String(now.Format(time.RFC3339))},
+		":expires": {N: aws.String(strconv.FormatInt(now.AddDate(0, 0, config.jobStatusTTLDays).Unix(), 10))},
+	}
+	expr := "SET #updated = :updated, #expires = :expires"
+
+	// attribute names such as "state" and "error" are reserved words, so all are aliased
+	for name, value := range attrs {
+		names["#"+name] = aws.String(name)
+		values[":"+name] = &dynamodb.AttributeValue{S: aws.String(value)}
+		expr += fmt.Sprintf(", #%s = :%s", name, name)
+	}
+
+	for name, value := range numbers {
+		names["#"+name] = aws.String(name)
+		values[":"+name] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(value))}
+		expr += fmt.Sprintf(", #%s = :%s", name, name)
+	}
+
+	if _, err := j.svc.UpdateItemWithContext(j.ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(config.jobStatusTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pid":       {S: aws.String(j.pid)},
+			"requestid": {S: aws.String(j.requestID)},
+		},
+		UpdateExpression:          aws.String(expr),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}); err != nil {
+		log.Printf("WARNING: failed to update job status: [%s]", err.Error())
+	}
+}
+
+func (j *jobTracker) transition(state string) {
+	if j == nil {
+		return
+	}
+
+	j.update(map[string]string{"state": state}, nil)
+}
+
+// progress records how many pages of a multi-page source have been ocr'd
+func (j *jobTracker) progress(page, pages int) {
+	if j == nil {
+		return
+	}
+
+	j.update(nil, map[string]int{"page": page, "pages": pages})
+}
+
+// finish records the outcome of the job
+func (j *jobTracker) finish(err error) {
+	if j == nil {
+		return
+	}
+
+	if err != nil {
+		j.update(map[string]string{"state": jobFailed, "error": err.Error()}, nil)
+		return
+	}
+
+	j.update(map[string]string{"state": jobDone}, nil)
+}
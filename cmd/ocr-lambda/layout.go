@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+)
+
+// blocks wider than this fraction of the page span columns (e.g. headlines), and
+// separate the columns above them from those below
+const layoutSpanningFraction = 0.5
+
+// blocks overlapping a column horizontally by at least this fraction of the narrower
+// of the two belong to that column
+const layoutColumnOverlap = 0.5
+
+// a column of blocks within a band of the page
+type layoutColumn struct {
+	x0, x1 int
+	blocks []hocrBlock
+}
+
+// overlap returns the horizontal overlap of a block with the column, as a fraction
+// of the narrower of the two
+func (c *layoutColumn) overlap(b hocrBlock) float64 {
+	width := minInt(c.x1-c.x0, b.BBox.X1-b.BBox.X0)
+	if width <= 0 {
+		return 0
+	}
+
+	return float64(minInt(c.x1, b.BBox.X1)-maxInt(c.x0, b.BBox.X0)) / float64(width)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// orderBand orders the blocks of a band column by column, left to right and top to bottom
+func orderBand(band []hocrBlock) []hocrBlock {
+	sorted := append([]hocrBlock{}, band...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].BBox.X0 < sorted[j].BBox.X0 })
+
+	var columns []*layoutColumn
+
+	for _, b := range sorted {
+		var col *layoutColumn
+
+		for _, c := range columns {
+			if c.overlap(b) >= layoutColumnOverlap {
+				col = c
+				break
+			}
+		}
+
+		if col == nil {
+			col = &layoutColumn{x0: b.BBox.X0, x1: b.BBox.X1}
+			columns = append(columns, col)
+		}
+
+		col.x0, col.x1 = minInt(col.x0, b.BBox.X0), maxInt(col.x1, b.BBox.X1)
+		col.blocks = append(col.blocks, b)
+	}
+
+	var ordered []hocrBlock
+
+	for _, c := range columns {
+		sort.SliceStable(c.blocks, func(i, j int) bool { return c.blocks[i].BBox.Y0 < c.blocks[j].BBox.Y0 })
+		ordered = append(ordered, c.blocks...)
+	}
+
+	return ordered
+}
+
+// readingOrder returns the blocks of a page in reading order: the page is split into
+// bands by blocks spanning columns, and each band is read column by column
+func readingOrder(page *hocrPage) []hocrBlock {
+	blocks := append([]hocrBlock{}, page.Blocks...)
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].BBox.Y0 < blocks[j].BBox.Y0 })
+
+	spanning := int(float64(page.BBox.X1-page.BBox.X0) * layoutSpanningFraction)
+
+	var ordered, band []hocrBlock
+
+	for _, b := range blocks {
+		if b.BBox.X1-b.BBox.X0 > spanning {
+			ordered = append(ordered, orderBand(band)...)
+			ordered = append(ordered, b)
+			band = nil
+			continue
+		}
+
+		band = append(band, b)
+	}
+
+	return append(ordered, orderBand(band)...)
+}
+
+// layoutParagraphs returns the paragraphs of a page in reading order, each as its lines of text
+func layoutParagraphs(page *hocrPage) [][]string {
+	var paragraphs [][]string
+
+	for _, b := range readingOrder(page) {
+		for _, par := range b.Pars {
+			var lines []string
+
+			for _, line := range par.Lines {
+				var words []string
+
+				for _, w := range line.Words {
+					if text := strings.TrimSpace(w.Text); text != "" {
+						words = append(words, text)
+					}
+				}
+
+				if len(words) > 0 {
+					lines = append(lines, strings.Join(words, " "))
+				}
+			}
+
+			if len(lines) > 0 {
+				paragraphs = append(paragraphs, lines)
+			}
+		}
+	}
+
+	return paragraphs
+}
+
+// layoutText renders pages of paragraphs as plain text: paragraphs are separated by
+// blank lines, and (as in tesseract's text output) each page ends with a form feed
+func layoutText(pages [][][]string) string {
+	var b strings.Builder
+
+	for _, paragraphs := range pages {
+		for i, lines := range paragraphs {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+
+			for _, line := range lines {
+				b.WriteString(line)
+				b.WriteString("\n")
+			}
+		}
+
+		b.WriteString("\f")
+	}
+
+	return b.String()
+}
+
+// readLayoutPages parses the hocr results of each page into paragraphs in reading order
+func readLayoutPages(resultsBase string) ([][][]string, error) {
+	hocrFiles := hocrResultsFiles(resultsBase)
+	if len(hocrFiles) == 0 {
+		return nil, fmt.Errorf("no hocr results found")
+	}
+
+	var pages [][][]string
+
+	for _, hocrFile := range hocrFiles {
+		page, err := parseHocrFile(hocrFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pages = append(pages, layoutParagraphs(page))
+	}
+
+	return pages, nil
+}
+
+// saveLayoutResults saves the text in reading order as <resultsBase>.layout.txt, and
+// optionally as <resultsBase>.epub, for multi-column pages whose tesseract text output
+// interleaves the columns
+func saveLayoutResults(resultsBase string, text, epub bool, book epubInfo) error {
+	pages, err := readLayoutPages(resultsBase)
+	if err != nil {
+		return fmt.Errorf("failed to determine reading order: [%s]", err.Error())
+	}
+
+	if text {
+		log.Print("saving reading order text...")
+
+		if err = ioutil.WriteFile(fmt.Sprintf("%s.layout.txt", resultsBase), []byte(layoutText(pages)), 0644); err != nil {
+			return fmt.Errorf("failed to save reading order text: [%s]", err.Error())
+		}
+	}
+
+	if epub {
+		log.Print("saving epub...")
+
+		if err = saveEpub(fmt.Sprintf("%s.epub", resultsBase), pages, book); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
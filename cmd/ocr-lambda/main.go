@@ -1,6 +1,7 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,16 +9,23 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -25,16 +33,84 @@ import (
 
 // json for workflow <-> lambda communication
 type workflowRequestType struct {
-	Lang      string `json:"lang,omitempty"`      // language to use for ocr
-	Scale     string `json:"scale,omitempty"`     // converted image scale factor
-	Bucket    string `json:"bucket,omitempty"`    // s3 bucket for source image
-	Key       string `json:"key,omitempty"`       // s3 key for source image
-	ParentPid string `json:"parentpid,omitempty"` // pid of metadata parent, if applicable
-	Pid       string `json:"pid,omitempty"`       // pid of this master_file image
+	Lang           string   `json:"lang,omitempty"`           // language to use for ocr
+	Scale          string   `json:"scale,omitempty"`          // converted image scale factor
+	Bucket         string   `json:"bucket,omitempty"`         // s3 bucket for source image
+	Key            string   `json:"key,omitempty"`            // s3 key for source image
+	ParentPid      string   `json:"parentpid,omitempty"`      // pid of metadata parent, if applicable
+	Pid            string   `json:"pid,omitempty"`            // pid of this master_file image
+	ResultPrefix   string   `json:"resultprefix,omitempty"`   // base name for result files (default "results")
+	PdfDpi         int      `json:"pdfdpi,omitempty"`         // maximum resolution of the page images in pdf output (downsampled after ocr, which is unaffected)
+	Formats        []string `json:"formats,omitempty"`        // output formats to produce (limited to those enabled by the operator)
+	Reproducible   bool     `json:"reproducible,omitempty"`   // verify the toolchain against the pinned manifest before running
+	SaveConverted  bool     `json:"saveconverted,omitempty"`  // keep the converted image in s3 for later format regenerations
+	ReuseConverted bool     `json:"reuseconverted,omitempty"` // ocr a previously saved converted image, skipping download and conversion
+	ResumeUpload   string   `json:"resumeupload,omitempty"`   // s3 key (in bucket) of the state of an interrupted upload to resume (best effort: only the interrupted container has the data)
+	Binarize       string   `json:"binarize,omitempty"`       // binarization before ocr: "threshold" or "adaptive" (default: grayscale only)
+	Threshold      int      `json:"threshold,omitempty"`      // threshold binarization: intensity percentage (default 50)
+	Window         int      `json:"window,omitempty"`         // adaptive binarization: window size in pixels (default 25)
+	Offset         int      `json:"offset,omitempty"`         // adaptive binarization: offset percentage (default 0)
+	Preprocess     []string `json:"preprocess,omitempty"`     // cleanup before ocr: any of "deskew", "despeckle", "binarize" (adaptive, unless binarize is set)
+	Action         string   `json:"action,omitempty"`         // non-ocr request: "engine-info" returns build and tool versions
+	SplitSpread    bool     `json:"splitspread,omitempty"`    // ocr the left and right halves of a double-page spread independently
+	TextEncoding   string   `json:"textencoding,omitempty"`   // encoding of results text files: "utf-8" (default), "utf-8-bom" or "latin-1"
+	Banner         *bool    `json:"banner,omitempty"`         // also produce a delivery copy of the text marked as machine-generated (default from operator settings)
+	TessdataDir    string   `json:"tessdatadir,omitempty"`    // s3 prefix (in bucket, or s3://bucket/prefix) of custom language files to use
+
+	CompareWithPrevious bool `json:"comparewithprevious,omitempty"` // report how the text differs from that of the results being replaced
+	PageNumber          int  `json:"pagenumber,omitempty"`          // page number within the parent work, recorded at the top of the text and hocr results
+	MultiPage           bool `json:"multipage,omitempty"`           // ocr every page of a multi-page tiff or pdf, rather than only the first
+	Psm                 int  `json:"psm,omitempty"`                 // tesseract page segmentation mode (default 1: automatic, with orientation detection)
+	Oem                 *int `json:"oem,omitempty"`                 // tesseract ocr engine mode (default 3: whatever the language files support)
+
+	ImageURL     string `json:"imageurl,omitempty"`     // http(s) url (e.g. iiif) to fetch the source image from, instead of key; results still go to bucket
+	TessdataType string `json:"tessdatatype,omitempty"` // language file quality tier: "fast", "best" or "standard" (default from operator config)
+	AutoRotate   *bool  `json:"autorotate,omitempty"`   // rotate the image upright if osd finds it sideways or upside down (default from operator config)
+	TaskToken    string `json:"tasktoken,omitempty"`    // step functions task token to report the outcome to, in addition to returning it
+	Words        bool   `json:"words,omitempty"`        // include word and line coordinates in the response (and results.words.json)
+	Pdfa         bool   `json:"pdfa,omitempty"`         // produce pdf/a-2b compliant pdf output, titled with the pid (requires pdf format)
+	Layout       bool   `json:"layout,omitempty"`       // also produce the text in reading order, by paragraph and column (results.layout.txt)
+	Epub         bool   `json:"epub,omitempty"`         // also produce a minimal epub of the text in reading order (results.epub)
+	Cleanup      bool   `json:"cleanup,omitempty"`      // clean up results.txt for ingest (normalization, dehyphenation, etc. as configured by the operator)
+	Engine       string `json:"engine,omitempty"`       // ocr engine: "tesseract" (default), "kraken" (e.g. for handwriting; txt, hocr and alto only) or "vision" (google cloud vision; txt, hocr and tsv only)
+
+	TextractFallback bool `json:"textractfallback,omitempty"` // use amazon textract's text instead if tesseract finds (almost) none, within the operator's daily limit
+
+	Keys []string `json:"keys,omitempty"` // s3 keys (in bucket) of several source images to ocr in turn, instead of key
 }
 
 type workflowResponseType struct {
-	Text string `json:"text,omitempty"`
+	Text              string              `json:"text,omitempty"`
+	Formats           []string            `json:"formats,omitempty"`           // output formats actually produced
+	Blocks            []blockLanguageType `json:"blocks,omitempty"`            // dominant language per text block, for multi-language requests
+	DuplicateOf       string              `json:"duplicateof,omitempty"`       // location of earlier identical results that were reused, if any
+	Settings          map[string]string   `json:"settings,omitempty"`          // layer that supplied each defaulted setting (request, collection, global, compiled)
+	SkippedSmallImage bool                `json:"skippedsmallimage,omitempty"` // image was too small to ocr meaningfully; text is empty
+	UnmappedChars     int                 `json:"unmappedchars,omitempty"`     // characters replaced or transliterated to fit the requested text encoding
+	Provenance        *provenanceType     `json:"provenance,omitempty"`        // origin of the source image, for standalone requests
+	DeliveryFile      string              `json:"deliveryfile,omitempty"`      // results text prefixed with a machine-generated banner, for delivery to patrons
+	PyramidLevel      *pyramidLevelType   `json:"pyramidlevel,omitempty"`      // lower resolution level of a pyramidal tiff the image was converted from, if any
+	DiffStats         *diffStatsType      `json:"diffstats,omitempty"`         // line changes from the previous results text, if requested
+	PageNumber        int                 `json:"pagenumber,omitempty"`        // page number recorded in the results, if requested
+	Pages             int                 `json:"pages,omitempty"`             // number of pages in a multi-page source; formats other than txt are per page only
+	Confidence        *float64            `json:"confidence,omitempty"`        // mean word confidence (0-100), if any words were recognized
+	PageWords         []int               `json:"pagewords,omitempty"`         // number of words recognized on each page (or half of a spread)
+	Scale             string              `json:"scale,omitempty"`             // scale the image was actually converted at, if reduced to fit limits
+	Lang              string              `json:"lang,omitempty"`              // languages used, if detected automatically
+	Rotated           int                 `json:"rotated,omitempty"`           // degrees the image was rotated clockwise to make it upright, if requested
+	Words             []wordsPageType     `json:"words,omitempty"`             // word and line coordinates of each page, if requested
+	Cleanup           []string            `json:"cleanup,omitempty"`           // text cleanup steps applied to the results text, if requested
+	Quality           *qualityScore       `json:"quality,omitempty"`           // dictionary and noise based quality score of the text, if it has any words
+	Textract          bool                `json:"textract,omitempty"`          // the text (only) is amazon textract's, as tesseract found almost none
+}
+
+// who supplied a standalone source image, and when, as reported by its s3 event;
+// older event versions may lack some of these
+type provenanceType struct {
+	EventName       string `json:"eventName,omitempty"` // e.g. ObjectCreated:Put vs. ObjectCreated:Copy
+	EventTime       string `json:"eventTime,omitempty"`
+	PrincipalID     string `json:"principalId,omitempty"`
+	SourceIPAddress string `json:"sourceIPAddress,omitempty"`
 }
 
 // json for s3 message -> lambda communication
@@ -85,12 +161,29 @@ type s3RecordType struct {
 	RequestParameters s3RequestParametersType `json:"requestParameters,omitempty"`
 	ResponseElements  s3ResponseElementsType  `json:"responseElements,omitempty"`
 	S3                s3Type                  `json:"s3,omitempty"`
+	Sns               snsMessageType          `json:"Sns,omitempty"`       // present when s3 events are delivered via sns
+	MessageID         string                  `json:"messageId,omitempty"` // present when requests are delivered via sqs
+	Body              string                  `json:"body,omitempty"`      // present when requests are delivered via sqs
 }
 
 type s3MessageEventType struct {
 	Records []s3RecordType `json:"Records,omitempty"`
 }
 
+// json for sns message -> lambda communication, where each message is a json-encoded s3 event
+type snsMessageType struct {
+	Message string `json:"Message,omitempty"`
+}
+
+type snsRecordType struct {
+	EventSource string         `json:"EventSource,omitempty"`
+	Sns         snsMessageType `json:"Sns,omitempty"`
+}
+
+type snsS3Event struct {
+	Records []snsRecordType `json:"Records,omitempty"`
+}
+
 // combined request type that encompasses various ways in which this lambda could be invoked
 type lambdaRequestType struct {
 	workflowRequestType
@@ -99,24 +192,109 @@ type lambdaRequestType struct {
 
 // json for logged command history
 type commandInfo struct {
-	Command   string   `json:"command,omitempty"`
-	Arguments []string `json:"arguments,omitempty"`
-	Output    string   `json:"output,omitempty"`
-	Duration  string   `json:"duration,omitempty"`
+	Command     string   `json:"command,omitempty"`
+	Arguments   []string `json:"arguments,omitempty"`
+	Output      string   `json:"output,omitempty"`
+	OutputBytes int      `json:"outputbytes,omitempty"` // original size of the output, if it was truncated
+	Duration    string   `json:"duration,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	TimedOut    bool     `json:"timedout,omitempty"` // killed for exceeding the stage time limit or the lambda deadline
+}
+
+// a failed attempt at an s3 operation
+type retryInfo struct {
+	Operation string `json:"operation,omitempty"`
+	Attempt   int    `json:"attempt,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Retryable bool   `json:"retryable"`       // transient failure (e.g. throttling), as opposed to e.g. a missing object
+	Delay     string `json:"delay,omitempty"` // wait before the next attempt, if there was one
 }
 
+// the commands and retries of a request; s3 operations in worker goroutines record
+// into it concurrently, so additions are made under its lock
 type commandHistory struct {
-	Commands []commandInfo `json:"commands,omitempty"`
+	mu         sync.Mutex
+	Provenance *provenanceType  `json:"provenance,omitempty"`
+	Commands   []commandInfo    `json:"commands,omitempty"`
+	Truncated  bool             `json:"truncated,omitempty"` // older commands were dropped to limit the log size
+	Retries    []retryInfo      `json:"retries,omitempty"`   // failed s3 operation attempts
+	Attempts   []ocrAttemptInfo `json:"attempts,omitempty"`  // conversions and ocrs of a low confidence image
 }
 
+// number of commands at each end of the history that are never dropped
+const keptCommandHistory = 10
+
 // ocr config for generic conversions irrespective of request source
 type ocrConfig struct {
-	remoteResultsPrefix string
-	languages           string
-	scale               string
-	bucket              string
-	key                 string
-	additionalFormats   []string
+	remoteResultsPrefix  string
+	languages            string
+	scale                string
+	bucket               string
+	key                  string
+	pid                  string
+	batchName            string
+	imageURL             string
+	resultsBase          string
+	additionalFormats    []string
+	pdfDpi               int
+	reproducible         bool
+	saveConverted        bool
+	reuseConverted       bool
+	convertOperations    []string
+	splitSpread          bool
+	settings             *resolvedSettings
+	textEncoding         string
+	provenance           *provenanceType
+	tessdataDir          string
+	tessdataType         string
+	autoRotate           bool
+	words                bool
+	originalPdf          bool
+	pdfa                 bool
+	layout               bool
+	epub                 bool
+	cleanup              bool
+	cleanupIntermediates bool
+	compareWithPrevious  bool
+	pageNumber           int
+	multiPage            bool
+	engineName           string
+	engine               tesseractParams
+	textractFallback     bool
+}
+
+// needsHocr reports whether any requested results are derived from the hocr
+func (ocr ocrConfig) needsHocr() bool {
+	return ocr.words || ocr.layout || ocr.epub
+}
+
+const defaultResultsBase = "results"
+const maxResultPrefixLength = 64
+const minPdfDpi = 70
+const maxPdfDpi = 2400
+
+// longest server-requested delay we will wait before retrying a download
+const maxRetryAfter = 60 * time.Second
+
+// maximum lambda execution time; multipart uploads older than this are no longer in progress
+const maxLambdaRuntime = 15 * time.Minute
+
+var resultPrefixRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// working files share the work directory with the results, so a result prefix beginning
+// with one of their names could have them uploaded as results, or its results overwritten
+var reservedWorkingNames = []string{
+	"source", // local source images, and the images converted from them
+	retryResultsBase,
+	workingNameStem(retryConvertedImage),
+	workingNameStem(pdfaDefinitionFile),
+	workingNameStem(pdfaOutputFile),
+	workingNameStem(pdfDownsampleOutputFile),
+	workingNameStem(visionImage),
+}
+
+func workingNameStem(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
 }
 
 var sess *session.Session
@@ -124,389 +302,2315 @@ var cmds *commandHistory
 var home string
 
 func downloadImage(bucket, key, localFile string) (int64, error) {
-	log.Printf("downloading image: s3://%s/%s => %s", bucket, key, localFile)
+	// determine the expected size, so that truncated downloads can be detected
 
-	downloader := s3manager.NewDownloader(sess)
+	var head *s3.HeadObjectOutput
 
-	f, fileErr := os.Create(localFile)
-	if fileErr != nil {
-		return -1, fmt.Errorf("failed to create local file: [%s]", fileErr.Error())
+	headErr := withRetries(cmds, "download info", downloadRetries(), func() error {
+		var err error
+		head, err = newS3Client(config.sourceS3).HeadObjectWithContext(workCtx,
+			&s3.HeadObjectInput{
+				Bucket:       aws.String(bucket),
+				Key:          aws.String(key),
+				RequestPayer: requestPayer(),
+			})
+		return err
+	})
+
+	if headErr != nil {
+		return -1, fmt.Errorf("failed to get s3 file info: [%s]", headErr.Error())
 	}
-	defer f.Close()
 
-	bytes, dlErr := downloader.Download(f,
-		&s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-		})
+	expected := aws.Int64Value(head.ContentLength)
 
-	if dlErr != nil {
-		return -1, fmt.Errorf("failed to download s3 file: [%s]", dlErr.Error())
+	if err := checkDiskSpace(filepath.Dir(localFile), expected, "source image"); err != nil {
+		return -1, err
 	}
 
-	return bytes, nil
+	for attempt := 0; ; attempt++ {
+		bytes, err := downloadImageAttempt(bucket, key, localFile)
+		if err != nil {
+			return -1, err
+		}
+
+		if bytes == expected {
+			return bytes, nil
+		}
+
+		if attempt >= config.downloadMaxRetries {
+			return -1, fmt.Errorf("incomplete_download: received %d of %d bytes for s3://%s/%s after %d attempts", bytes, expected, bucket, key, attempt+1)
+		}
+
+		log.Printf("download attempt %d of %d was incomplete (received %d of %d bytes); retrying", attempt+1, config.downloadMaxRetries+1, bytes, expected)
+	}
 }
 
-func uploadResult(uploader *s3manager.Uploader, bucket, remoteResultsPrefix, resultFile string) error {
-	s3File := path.Join(remoteResultsPrefix, resultFile)
+// archiveSource copies the source image to the archive prefix in the background,
+// returning a channel that is closed once the copy has finished (or failed)
+func archiveSource(bucket, key string) <-chan struct{} {
+	done := make(chan struct{})
 
-	log.Printf("uploading file: %s => s3://%s/%s", resultFile, bucket, s3File)
+	go func() {
+		defer close(done)
 
-	f, err := os.Open(resultFile)
-	if err != nil {
-		return fmt.Errorf("failed to open results file: [%s]", err.Error())
+		src := path.Join(bucket, key)
+		dst := path.Join(config.archiveSourcePrefix, key)
+
+		log.Printf("archiving source image: s3://%s => s3://%s/%s", src, bucket, dst)
+
+		if _, err := newS3Client(config.sourceS3).CopyObject(&s3.CopyObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(dst),
+			CopySource:   aws.String((&url.URL{Path: src}).EscapedPath()),
+			RequestPayer: requestPayer(),
+		}); err != nil {
+			log.Printf("WARNING: failed to archive source image: [%s]", err.Error())
+		}
+	}()
+
+	return done
+}
+
+func downloadImageAttempt(bucket, key, localFile string) (int64, error) {
+	log.Printf("downloading image: s3://%s/%s => %s", bucket, key, localFile)
+
+	downloader := s3manager.NewDownloaderWithClient(newS3Client(config.sourceS3))
+
+	f, fileErr := os.Create(localFile)
+	if fileErr != nil {
+		return -1, fmt.Errorf("failed to create local file: [%s]", fileErr.Error())
 	}
 	defer f.Close()
 
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(s3File),
-		Body:   f,
+	var bytes int64
+
+	dlErr := withRetries(cmds, "download", downloadRetries(), func() error {
+		var err error
+		bytes, err = downloader.DownloadWithContext(workCtx, f,
+			&s3.GetObjectInput{
+				Bucket:       aws.String(bucket),
+				Key:          aws.String(key),
+				RequestPayer: requestPayer(),
+			})
+		return err
 	})
 
-	return err
-}
+	if dlErr != nil {
+		return -1, fmt.Errorf("failed to download s3 file: [%s]", dlErr.Error())
+	}
 
-func uploadResults(bucket, remoteResultsPrefix string) error {
-	log.Print("uploading results")
+	return bytes, nil
+}
 
-	uploader := s3manager.NewUploader(sess)
+// how many times, and how soon, a failed s3 operation is retried
+type retryPolicy struct {
+	maxRetries int
+	delay      time.Duration // before the first retry; doubled for each subsequent one
+}
 
-	matches, globErr := filepath.Glob("results.*")
+func uploadRetries() retryPolicy {
+	return retryPolicy{maxRetries: config.uploadMaxRetries, delay: time.Duration(config.uploadRetryDelayMs) * time.Millisecond}
+}
 
-	if globErr != nil {
-		return fmt.Errorf("failed to find results file(s): [%s]", globErr.Error())
-	}
+func downloadRetries() retryPolicy {
+	return retryPolicy{maxRetries: config.downloadMaxRetries, delay: time.Duration(config.downloadRetryDelayMs) * time.Millisecond}
+}
 
-	for _, resultFile := range matches {
-		if err := uploadResult(uploader, bucket, remoteResultsPrefix, resultFile); err != nil {
-			return fmt.Errorf("failed to upload result: [%s]", err.Error())
-		}
+// withJitter randomizes a delay by up to the configured percentage either way, so
+// that concurrent lambdas do not retry in lockstep
+func withJitter(delay time.Duration) time.Duration {
+	spread := int64(delay) * int64(config.retryJitterPct) / 100
+	if spread <= 0 {
+		return delay
 	}
 
-	return nil
+	return delay - time.Duration(spread) + time.Duration(rand.Int63n(2*spread+1))
 }
 
-func runCommand(command string, arguments ...string) (string, error) {
-	start := time.Now()
+// withRetries runs an s3 operation, retrying transient failures with exponential backoff;
+// every failed attempt is recorded in the given command history, if any. goroutines other
+// than the request's are passed the history to record into rather than using cmds, which
+// is replaced by each request.
+func withRetries(history *commandHistory, what string, policy retryPolicy, op func() error) error {
+	delay := policy.delay
 
-	out, err := exec.Command(command, arguments...).CombinedOutput()
+	for attempt := 0; ; attempt++ {
+		err := op()
 
-	duration := time.Since(start).Seconds()
+		if err == nil {
+			return nil
+		}
 
-	output := string(out)
+		retry := attempt < policy.maxRetries && isRetryableS3Error(err)
 
-	cmd := commandInfo{Command: command, Arguments: arguments, Output: output, Duration: fmt.Sprintf("%0.3f", duration)}
+		info := retryInfo{Operation: what, Attempt: attempt + 1, Error: err.Error(), Retryable: isRetryableS3Error(err)}
+
+		if !retry {
+			history.addRetry(info)
+			return err
+		}
 
-	cmds.Commands = append(cmds.Commands, cmd)
+		wait := withJitter(delay)
+		info.Delay = wait.String()
+		history.addRetry(info)
 
-	log.Printf("command: [%s]  arguments: [%s]  duration: [%s]", cmd.Command, strings.Join(cmd.Arguments, " "), cmd.Duration)
+		log.Printf("%s: attempt %d of %d failed; retrying in %v: [%s]", what, attempt+1, policy.maxRetries+1, wait, err.Error())
 
-	return output, err
+		time.Sleep(wait)
+		delay *= 2
+	}
 }
 
-func downloadFile(url, filename string) error {
-	log.Printf("downloading file: [%s]", url)
+func uploadResult(ctx context.Context, uploader *s3manager.Uploader, bucket, remoteResultsPrefix, resultFile, contentType string) error {
+	s3File := path.Join(remoteResultsPrefix, resultFile)
+
+	log.Printf("uploading file: %s => s3://%s/%s", resultFile, bucket, s3File)
 
-	res, err := http.Get(url)
+	f, err := os.Open(resultFile)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to open results file: [%s]", err.Error())
 	}
-	defer res.Body.Close()
+	defer f.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download language file: [%s] (%s)", url, res.Status)
+	// large files are uploaded in explicitly managed parts, so they can be resumed if the lambda runs out of time
+
+	if info, statErr := f.Stat(); statErr == nil && info.Size() > int64(config.multipartThresholdMB)*1024*1024 {
+		return multipartUpload(ctx, newS3Client(config.resultsS3), bucket, s3File, resultFile)
 	}
 
-	f, err := os.Create(filename)
+	// the checksum covers the whole file, so it must be sent in a single request rather
+	// than split into parts by the uploader (the part size only needs to exceed the
+	// size of files that reach here)
+	var options []func(*s3manager.Uploader)
+
+	checksumOption, err := checksumRequestOption(resultFile)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	_, err = io.Copy(f, res.Body)
+	if checksumOption != nil {
+		options = append(options, s3manager.WithUploaderRequestOptions(checksumOption), func(u *s3manager.Uploader) {
+			if partSize := int64(config.multipartThresholdMB+1) * 1024 * 1024; partSize > u.PartSize {
+				u.PartSize = partSize
+			}
+		})
+	}
 
-	return err
-}
+	return withRetries(cmds, "upload", uploadRetries(), func() error {
+		// rewind the file so each attempt sends the entire contents
+		if _, seekErr := f.Seek(0, io.SeekStart); seekErr != nil {
+			return fmt.Errorf("failed to rewind results file: [%s]", seekErr.Error())
+		}
 
-func checkLanguages(langStr string) error {
-	langs := strings.Split(langStr, "+")
+		input := &s3manager.UploadInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(s3File),
+			Body:         f,
+			StorageClass: aws.String(storageClassFor(resultFile)),
+			RequestPayer: requestPayer(),
+		}
 
-	// certain languages depend on other language files, make sure they are pulled in
+		if contentType != "" {
+			input.ContentType = aws.String(contentType)
+		}
 
-	langsMap := map[string]string{
-		"aze":      "aze_cyrl",
-		"aze_cyrl": "aze",
-		"uzb":      "uzb_cyrl",
-		"uzb_cyrl": "uzb",
-	}
+		if config.resultGrantRead != "" {
+			input.GrantRead = aws.String(config.resultGrantRead)
+		}
 
-	// osd should always be present, if not specified in language list
-	langsAll := []string{"osd"}
+		_, uploadErr := uploader.Upload(input, options...)
 
-	for _, l := range langs {
-		if l == "" {
-			continue
+		return uploadErr
+	})
+}
+
+func isRetryableS3Error(err error) bool {
+	// walk the chain of wrapped aws errors (s3manager wraps multipart failures)
+	for err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return false
 		}
 
-		langsAll = append(langsAll, l)
+		switch aerr.Code() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException",
+			"ServiceUnavailable", "InternalError", "RequestTimeout", "RequestError":
+			return true
 
-		langDep := langsMap[l]
-		if langDep != "" {
-			langsAll = append(langsAll, langDep)
+		case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "NoSuchBucket", "NoSuchKey",
+			"InvalidBucketName", "KeyTooLongError", "InvalidArgument", "InvalidRequest":
+			return false
+		}
+
+		if rerr, isReqErr := err.(awserr.RequestFailure); isReqErr {
+			if rerr.StatusCode() == http.StatusTooManyRequests || rerr.StatusCode() >= http.StatusInternalServerError {
+				return true
+			}
 		}
+
+		err = aerr.OrigErr()
 	}
 
-	langType := "fast"
-	langBranch := "4.0.0"
-	langURLTemplate := "https://github.com/tesseract-ocr/tessdata_%s/raw/%s/%s%s.traineddata"
+	return false
+}
 
-	for _, l := range langsAll {
-		var err error
+func uploadResults(ctx context.Context, bucket, remoteResultsPrefix, resultsBase, textEncoding string) ([]string, error) {
+	log.Print("uploading results")
 
-		// check if language file exists
-		langFile := fmt.Sprintf("%s/%s.traineddata", os.Getenv("TESSDATA_PREFIX"), l)
-		if _, err = os.Stat(langFile); err == nil {
-			continue
-		}
+	uploader := s3manager.NewUploaderWithClient(newS3Client(config.resultsS3))
 
-		// attempt to download as language file
-		langURL := fmt.Sprintf(langURLTemplate, langType, langBranch, "", l)
-		if err = downloadFile(langURL, langFile); err == nil {
-			continue
-		}
+	// include per-page results of multi-page sources and double-page spreads
+	patterns := []string{fmt.Sprintf("%s.*", resultsBase), pageResultsPattern(resultsBase)}
 
-		// attempt to download as script file
-		scriptURL := fmt.Sprintf(langURLTemplate, langType, langBranch, "script/", l)
-		if err = downloadFile(scriptURL, langFile); err == nil {
-			continue
+	for _, base := range spreadResultsBases(resultsBase) {
+		patterns = append(patterns, fmt.Sprintf("%s.*", base))
+	}
+
+	var matches []string
+
+	for _, pattern := range patterns {
+		baseMatches, globErr := filepath.Glob(pattern)
+		if globErr != nil {
+			return nil, fmt.Errorf("failed to find results file(s): [%s]", globErr.Error())
 		}
 
-		// both downloads failed; give up
-		return err
+		matches = append(matches, baseMatches...)
 	}
 
-	return nil
-}
+	// upload smallest files first, so that the most results are saved if we run short on time
+	sort.Slice(matches, func(i, j int) bool { return fileSize(matches[i]) < fileSize(matches[j]) })
 
-func convertImage(localSourceImage, localConvertedImage, scale string) error {
-	log.Print("converting image...")
+	for _, resultFile := range matches {
+		if err := uploadResult(ctx, uploader, bucket, remoteResultsPrefix, resultFile, textContentType(resultFile, textEncoding)); err != nil {
+			if _, ok := err.(*uploadInterruptedError); ok {
+				return nil, err
+			}
+
+			return nil, fmt.Errorf("failed to upload result: [%s]", err.Error())
+		}
+	}
 
-	cmd := "magick"
-	args := []string{"convert", "-units", "PixelsPerInch", "-type", "Grayscale", "+compress", "+repage", fmt.Sprintf("%s[0]", localSourceImage), "-filter", "Lanczos", "-resize", fmt.Sprintf("%s%%", scale), localConvertedImage}
+	return matches, nil
+}
 
-	if out, err := runCommand(cmd, args...); err != nil {
-		return fmt.Errorf("failed to convert source image: [%s] (%s)", err.Error(), out)
+func fileSize(filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0
 	}
 
-	return nil
+	return info.Size()
 }
 
-func ocrImage(localConvertedImage, resultsBase, langStr string, outputFormats []string) error {
-	log.Print("ocring image...")
+func validateResultPrefix(prefix string) error {
+	if prefix == "" {
+		return errors.New("result prefix must not be empty")
+	}
 
-	cmd := "tesseract"
-	args := []string{localConvertedImage, resultsBase, "--psm", "1", "-l", langStr}
-	args = append(args, outputFormats...)
+	if len(prefix) > maxResultPrefixLength {
+		return fmt.Errorf("result prefix exceeds %d characters: [%s]", maxResultPrefixLength, prefix)
+	}
 
-	if out, err := runCommand(cmd, args...); err != nil {
-		return fmt.Errorf("failed to ocr converted image: [%s] (%s)", err.Error(), out)
+	if !resultPrefixRegex.MatchString(prefix) {
+		return fmt.Errorf("result prefix may only contain letters, digits, hyphens, and underscores: [%s]", prefix)
+	}
+
+	for _, name := range reservedWorkingNames {
+		if strings.HasPrefix(prefix, name) {
+			return fmt.Errorf("result prefix must not begin with the working name %q: [%s]", name, prefix)
+		}
 	}
 
 	return nil
 }
 
-func getLibraryVersions() {
-	var files []string
+// add records a command, dropping the oldest commands other than the initial
+// (version check) ones once the history reaches its configured maximum
+func (h *commandHistory) add(cmd commandInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	if matches, err := filepath.Glob(fmt.Sprintf("%s/bin/*", home)); err == nil {
-		files = append(files, matches...)
+	h.Commands = append(h.Commands, cmd)
+
+	if len(h.Commands) > config.maxCommandHistory {
+		h.Commands = append(h.Commands[:keptCommandHistory], h.Commands[keptCommandHistory+1:]...)
+		h.Truncated = true
 	}
+}
 
-	if matches, err := filepath.Glob(fmt.Sprintf("%s/lib/*", home)); err == nil {
-		files = append(files, matches...)
+// addRetry records a failed s3 operation attempt, if a history is being kept
+func (h *commandHistory) addRetry(info retryInfo) {
+	if h == nil {
+		return
 	}
 
-	runCommand("ldd", files...)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.Retries = append(h.Retries, info)
 }
 
-func getSoftwareVersions() {
-	runCommand("magick", "--version")
-	runCommand("tesseract", "--version")
+func runCommand(command string, arguments ...string) (string, error) {
+	return runCommandEnv(nil, command, arguments...)
+}
 
-	getLibraryVersions()
+// runCommandEnv runs a command with the given environment (nil inherits this process's)
+func runCommandEnv(env []string, command string, arguments ...string) (string, error) {
+	return runCommandIO(env, nil, command, arguments...)
 }
 
-func saveCommandHistory(resultsBase string) {
-	cmdsText, jsonErr := json.Marshal(cmds)
-	if jsonErr != nil {
-		return
+// runCommandInput runs a command that reads its standard input from the given reader
+func runCommandInput(stdin io.Reader, command string, arguments ...string) (string, error) {
+	return runCommandIO(nil, stdin, command, arguments...)
+}
+
+func runCommandIO(env []string, stdin io.Reader, command string, arguments ...string) (string, error) {
+	if !hasFormat(config.allowedCommands, filepath.Base(command)) {
+		log.Printf("refusing to run command not in ALLOWED_COMMANDS: [%s]", command)
+		return "", fmt.Errorf("command not allowed: [%s]", command)
 	}
 
-	cmdsFile := fmt.Sprintf("%s.log", resultsBase)
+	start := time.Now()
 
-	if err := ioutil.WriteFile(cmdsFile, cmdsText, 0644); err != nil {
-		return
-	}
-}
+	// commands still running when the work deadline passes, or the current stage's
+	// time limit is exceeded, are killed
+	ctx := stageCtx
 
-func handleGenericOcrRequest(ocr ocrConfig) (string, error) {
-	// set file/path variables
+	c := exec.CommandContext(ctx, command, arguments...)
+	c.Env = env
+	c.Stdin = stdin
 
-	cmds = &commandHistory{}
+	out, err := c.CombinedOutput()
 
-	localWorkDir := "/tmp/ocr-lambda"
+	duration := time.Since(start).Seconds()
 
-	// files matching results* are uploaded to s3 at the end of the process
-	resultsBase := "results"
-	localResultsTxt := fmt.Sprintf("%s.txt", resultsBase)
-	localSourceImage := fmt.Sprintf("source-%s", path.Base(ocr.key))
-	localConvertedImage := "source-converted.tif"
+	output := string(out)
 
-	outputFormats := []string{"txt"}
-	outputFormats = append(outputFormats, ocr.additionalFormats...)
+	cmd := commandInfo{Command: command, Arguments: arguments, Output: output, Duration: fmt.Sprintf("%0.3f", duration)}
 
-	// set default language if none specified
-	langStr := ocr.languages
-	if langStr == "" {
-		langStr = "eng"
+	// keep the log a manageable size; callers still receive the full output
+	if len(output) > config.maxCommandOutputBytes {
+		cmd.Output, cmd.OutputBytes = truncateText(output, config.maxCommandOutputBytes, config.truncationMarker)
 	}
 
-	// create and change to temporary working directory
-
-	if err := os.MkdirAll(localWorkDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create work dir: [%s]", err.Error())
+	if err != nil {
+		cmd.Error = err.Error()
+		cmd.TimedOut = ctx.Err() == context.DeadlineExceeded
 	}
 
-	defer func() {
-		// upload whatever results/logs we have, and clean up
-		saveCommandHistory(resultsBase)
-		uploadResults(ocr.bucket, ocr.remoteResultsPrefix)
-		os.Chdir("/")
-		os.RemoveAll(localWorkDir)
-	}()
+	cmds.add(cmd)
 
-	if err := os.Chdir(localWorkDir); err != nil {
-		return "", fmt.Errorf("failed to change to work dir: [%s]", err.Error())
-	}
+	log.Printf("command: [%s]  arguments: [%s]  duration: [%s]", cmd.Command, strings.Join(cmd.Arguments, " "), cmd.Duration)
 
-	// download image from s3
+	return output, err
+}
 
-	_, dlErr := downloadImage(ocr.bucket, ocr.key, localSourceImage)
-	if dlErr != nil {
-		return "", dlErr
-	}
+func downloadFile(url, filename string) error {
+	for attempt := 0; ; attempt++ {
+		retryAfter, retryable, err := downloadFileAttempt(url, filename)
 
-	// log versions of software we are using
+		if err == nil || !retryable || attempt >= config.tessdataMaxRetries {
+			return err
+		}
 
-	getSoftwareVersions()
+		// honor the server's requested delay if given, otherwise back off exponentially with jitter
+		delay := retryAfter
+		if delay == 0 {
+			backoff := time.Duration(config.tessdataRetryDelayMs) * time.Millisecond << uint(attempt)
+			delay = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		}
 
-	// ensure we have all languages/scripts needed, downloading if necessary
+		log.Printf("download attempt %d of %d failed; retrying in %v: %s", attempt+1, config.tessdataMaxRetries+1, delay, err.Error())
 
-	runCommand("find", os.Getenv("TESSDATA_PREFIX"))
-	runCommand("ls", "-laFR", os.Getenv("TESSDATA_PREFIX"))
-	if err := checkLanguages(langStr); err != nil {
-		return "", err
+		time.Sleep(delay)
 	}
-	runCommand("find", os.Getenv("TESSDATA_PREFIX"))
-	runCommand("ls", "-laFR", os.Getenv("TESSDATA_PREFIX"))
+}
 
-	// run magick
+// parseRetryAfter interprets a Retry-After header, which is either a number of seconds or an http date
+func parseRetryAfter(value string) time.Duration {
+	var delay time.Duration
 
-	if err := convertImage(localSourceImage, localConvertedImage, ocr.scale); err != nil {
-		return "", err
+	if secs, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		delay = time.Until(t)
 	}
 
-	// run tesseract
+	if delay < 0 {
+		delay = 0
+	}
 
-	if err := ocrImage(localConvertedImage, resultsBase, langStr, outputFormats); err != nil {
-		return "", err
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
 	}
 
-	// read ocr text results
+	return delay
+}
 
-	resultsText, readErr := ioutil.ReadFile(localResultsTxt)
-	if readErr != nil {
-		return "", fmt.Errorf("failed to read ocr results file: [%s]", readErr.Error())
-	}
+// downloadFileAttempt makes a single download attempt, indicating whether a failure is worth retrying
+func downloadFileAttempt(url, filename string) (time.Duration, bool, error) {
+	log.Printf("downloading file: [%s]", url)
 
-	// send response
+	res, err := tessdataHTTPClient.Get(url)
+	if err != nil {
+		return 0, true, err
+	}
+	defer res.Body.Close()
 
-	res := workflowResponseType{}
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("failed to download language file: [%s] (%s)", url, res.Status)
 
-	res.Text = string(resultsText)
+		switch res.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return parseRetryAfter(res.Header.Get("Retry-After")), true, err
+		}
 
-	output, jsonErr := json.Marshal(res)
-	if jsonErr != nil {
-		return "", fmt.Errorf("failed to serialize output: [%s]", jsonErr.Error())
+		return 0, false, err
 	}
 
-	return string(output), nil
-}
+	// some mirrors serve language files gzip-compressed; the http client only
+	// decompresses transparently when it requested compression itself
+	var body io.Reader = res.Body
 
-func handleWorkflowOcrRequest(req lambdaRequestType) (string, error) {
-	log.Print("handling workflow ocr request")
+	if strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		gz, gzErr := gzip.NewReader(res.Body)
+		if gzErr != nil {
+			return 0, false, fmt.Errorf("failed to decompress language file: [%s] (%s)", url, gzErr.Error())
+		}
+		defer gz.Close()
 
-	ocr := &ocrConfig{}
+		body = gz
+	}
 
-	// set values from request json
+	// download to a temporary file and rename it into place, so that a partial file is never
+	// mistaken for a complete one (e.g. by a concurrent language pre-cache)
+	f, err := ioutil.TempFile(filepath.Dir(filename), fmt.Sprintf("%s.*.download", filepath.Base(filename)))
+	if err != nil {
+		return 0, false, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
 
-	ocr.bucket = req.Bucket
-	ocr.key = req.Key
-	ocr.languages = req.Lang
-	ocr.scale = req.Scale
-	ocr.additionalFormats = []string{"hocr"}
+	// a dropped connection mid-transfer is worth retrying
+	n, err := io.Copy(f, io.LimitReader(body, maxTessdataDownloadBytes+1))
+	if err != nil {
+		return 0, true, err
+	}
 
-	// build s3 results path
+	if n > maxTessdataDownloadBytes {
+		return 0, false, fmt.Errorf("language file exceeds %d bytes: [%s]", maxTessdataDownloadBytes, url)
+	}
 
-	remoteSubDir := req.Pid
-	if req.Pid != req.ParentPid {
-		remoteSubDir = path.Join(req.ParentPid, req.Pid)
+	if err = f.Close(); err != nil {
+		return 0, false, err
 	}
 
-	ocr.remoteResultsPrefix = path.Join("results", remoteSubDir, req.Scale)
+	if err = os.Rename(f.Name(), filename); err != nil {
+		return 0, false, err
+	}
 
-	return handleGenericOcrRequest(*ocr)
+	return 0, false, nil
 }
 
-func handleStandaloneOcrRequest(req lambdaRequestType) (string, error) {
-	log.Print("handling standalone ocr request")
+// http client for language file downloads, so a stalled server cannot block a request indefinitely
+var tessdataHTTPClient *http.Client
 
-	ocr := &ocrConfig{}
+// largest language file we will download (the largest "best" models are well under this)
+const maxTessdataDownloadBytes = 100 * 1024 * 1024
 
-	// set values from request json
+// languages being downloaded in the background at startup
+var precache sync.WaitGroup
 
-	ocr.bucket = req.Records[0].S3.Bucket.Name
-	ocr.key = req.Records[0].S3.Object.Key
-	ocr.languages = ""
-	ocr.scale = "100"
-	ocr.additionalFormats = []string{"hocr", "pdf"}
+func precacheLanguages() {
+	if len(config.precacheLanguages) == 0 {
+		return
+	}
 
-	// build s3 results path
+	precache.Add(1)
 
-	strippedPath := strings.Replace(ocr.key, "standalone/requests/", "", -1)
+	go func() {
+		defer precache.Done()
 
-	ocr.remoteResultsPrefix = path.Join("standalone", "results", strippedPath)
+		langStr := strings.Join(config.precacheLanguages, "+")
 
-	log.Printf("key: [%s] => [%s] => [%s] => [%s]", ocr.key, path.Dir(ocr.key), strippedPath, ocr.remoteResultsPrefix)
+		// no request is being handled, so there is no history to record retries in
+		if err := checkLanguages(nil, langStr, config.tessdataType); err != nil {
+			log.Printf("WARNING: failed to pre-cache languages [%s]: %s", langStr, err.Error())
+			return
+		}
 
-	return handleGenericOcrRequest(*ocr)
+		log.Printf("pre-cached languages: [%s]", langStr)
+	}()
 }
 
-func handleOcrRequest(ctx context.Context, req lambdaRequestType) (string, error) {
-	if req.Pid != "" {
-		return handleWorkflowOcrRequest(req)
-	}
+// waitForPrecache waits (for a limited time) for startup language downloads to finish
+func waitForPrecache() {
+	done := make(chan struct{})
+
+	go func() {
+		precache.Wait()
+		close(done)
+	}()
 
-	if len(req.Records) > 0 {
-		return handleStandaloneOcrRequest(req)
+	select {
+	case <-done:
+	case <-time.After(time.Duration(config.precacheTimeoutSecs) * time.Second):
+		log.Printf("WARNING: language pre-cache still running after %d seconds; continuing", config.precacheTimeoutSecs)
 	}
+}
 
-	return "", errors.New("unhandled request type")
+// certain languages depend on other language files; LANG_DEPS entries are merged in at init time
+var langDeps = map[string]string{
+	"aze":      "aze_cyrl",
+	"aze_cyrl": "aze",
+	"uzb":      "uzb_cyrl",
+	"uzb_cyrl": "uzb",
 }
 
-func init() {
-	// initialize aws session
+func loadLanguageDependencies() {
+	value := envString("LANG_DEPS", "")
+	if value == "" {
+		return
+	}
+
+	var deps map[string]string
+
+	if err := json.Unmarshal([]byte(value), &deps); err != nil {
+		log.Fatalf("invalid value for LANG_DEPS: [%s]", err.Error())
+	}
+
+	for lang, dep := range deps {
+		langDeps[lang] = dep
+	}
+
+	log.Printf("[CONFIG] langDeps = [%v]", langDeps)
+}
+
+// checkLanguages ensures the files for the languages in langStr (and any they
+// depend on) are present in the local directory of the given tessdata tier; failed
+// downloads from the cache are recorded in the given history, if any
+func checkLanguages(history *commandHistory, langStr, langType string) error {
+	langs := strings.Split(langStr, "+")
+
+	// make sure languages that others depend on are pulled in
+
+	// osd should always be present, if not specified in language list
+	langsAll := []string{"osd"}
+
+	for _, l := range langs {
+		if l == "" {
+			continue
+		}
+
+		langsAll = append(langsAll, l)
+
+		langDep := langDeps[l]
+		if langDep != "" {
+			langsAll = append(langsAll, langDep)
+		}
+	}
+
+	// download any missing language files concurrently; each is written to a temporary
+	// file and renamed into place, so concurrent downloads never see partial files
+
+	dir := tessdataTypeDir(langType)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create tessdata dir: [%s]", err.Error())
+	}
+
+	var missing []string
+	seen := make(map[string]bool)
+
+	for _, l := range langsAll {
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+
+		langFile := fmt.Sprintf("%s/%s.traineddata", dir, l)
+		if _, err := os.Stat(langFile); err == nil {
+			continue
+		}
+
+		missing = append(missing, l)
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var failures []string
+
+	queue := make(chan string)
+
+	workers := config.tessdataConcurrency
+	if workers > len(missing) {
+		workers = len(missing)
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for l := range queue {
+				if err := downloadLanguage(history, l, langType, dir); err != nil {
+					mu.Lock()
+					failures = append(failures, err.Error())
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, l := range missing {
+		queue <- l
+	}
+
+	close(queue)
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("failed to download language files: [%s]", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// downloadLanguage fetches the traineddata file for a language or script into dir
+func downloadLanguage(history *commandHistory, l, langType, dir string) error {
+	langBranch := config.tessdataVersion
+	langURLTemplate := config.tessdataURL + "/%s/raw/%s/%s%s.traineddata"
+
+	langFile := fmt.Sprintf("%s/%s.traineddata", dir, l)
+
+	// prefer our own cache, which avoids depending on github
+
+	cached := config.tessdataCacheBucket != ""
+
+	if cached && fetchCachedLanguage(history, langType, langBranch, l, langFile) {
+		return nil
+	}
+
+	// attempt to download as language file, then as script file
+	langURL := fmt.Sprintf(langURLTemplate, tessdataRepos[langType], langBranch, "", l)
+
+	if err := downloadFile(langURL, langFile); err != nil {
+		scriptURL := fmt.Sprintf(langURLTemplate, tessdataRepos[langType], langBranch, "script/", l)
+
+		// both downloads failed; give up
+		if err = downloadFile(scriptURL, langFile); err != nil {
+			return err
+		}
+	}
+
+	if cached {
+		storeCachedLanguage(langType, langBranch, l, langFile)
+	}
+
+	return nil
+}
+
+func convertImage(localSourceImage, sourceInput, localConvertedImage string, params convertParams) error {
+	log.Print("converting image...")
+
+	if err := runConvert(sourceInput, localConvertedImage, params); err != nil {
+		return convertWithFallbacks(localSourceImage, localConvertedImage, params, err)
+	}
+
+	return nil
+}
+
+// how tesseract segments and recognizes the page
+type tesseractParams struct {
+	psm int // page segmentation mode
+	oem int // ocr engine mode
+}
+
+// automatic page segmentation with orientation and script detection, using the
+// default engine (lstm, or legacy if that is all the language files support)
+var defaultTesseractParams = tesseractParams{psm: 1, oem: 3}
+
+const maxPsm = 13
+const maxOem = 3
+
+// resolveTesseractParams validates the requested modes, using defaults for any left unset
+// (zero page segmentation mode, or nil engine mode)
+func resolveTesseractParams(psm int, oem *int) (tesseractParams, error) {
+	params := defaultTesseractParams
+
+	// modes 0 (orientation and script detection only) and 2 (segmentation only) produce no text
+	if psm != 0 {
+		if psm < 1 || psm == 2 || psm > maxPsm {
+			return params, fmt.Errorf("page segmentation mode must be 1 or between 3 and %d: [%d]", maxPsm, psm)
+		}
+
+		params.psm = psm
+	}
+
+	if oem != nil {
+		if *oem < 0 || *oem > maxOem {
+			return params, fmt.Errorf("ocr engine mode must be between 0 and %d: [%d]", maxOem, *oem)
+		}
+
+		params.oem = *oem
+	}
+
+	return params, nil
+}
+
+func ocrImage(localConvertedImage, resultsBase, langStr string, outputFormats []string, tessdataDir string, engine tesseractParams) error {
+	log.Print("ocring image...")
+
+	cmd := "tesseract"
+	args := []string{localConvertedImage, resultsBase, "--psm", strconv.Itoa(engine.psm), "--oem", strconv.Itoa(engine.oem), "-l", langStr}
+
+	args = append(args, outputFormats...)
+
+	if out, err := runCommandEnv(tessdataEnv(tessdataDir), cmd, args...); err != nil {
+		return fmt.Errorf("failed to ocr converted image: [%s] (%s)", err.Error(), out)
+	}
+
+	return nil
+}
+
+func isSmallImage(localConvertedImage string) (bool, error) {
+	width, height, err := imageSize(localConvertedImage)
+	if err != nil {
+		return false, err
+	}
+
+	if width < config.minImageWidth || height < config.minImageHeight {
+		log.Printf("skipping ocr: image is %dx%d, below minimum of %dx%d", width, height, config.minImageWidth, config.minImageHeight)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// removeIntermediate deletes a working file as soon as it is no longer needed,
+// rather than leaving it for the cleanup at the end of the request
+func removeIntermediate(filename string) {
+	size := fileSize(filename)
+
+	if err := os.Remove(filename); err != nil {
+		log.Printf("WARNING: failed to remove intermediate file: [%s]", err.Error())
+		return
+	}
+
+	log.Printf("removed intermediate file %s, freeing %d bytes", filename, size)
+}
+
+func hasFormat(formats []string, format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validatePdfDpi(dpi int) error {
+	// page images below this range are illegible, and no scan is above it
+	if dpi < minPdfDpi || dpi > maxPdfDpi {
+		return fmt.Errorf("pdf dpi must be between %d and %d: [%d]", minPdfDpi, maxPdfDpi, dpi)
+	}
+
+	return nil
+}
+
+func saveBlockLanguages(resultsBase string) []blockLanguageType {
+	page, err := parseHocrFile(fmt.Sprintf("%s.hocr", resultsBase))
+	if err != nil {
+		log.Printf("skipping block languages: %s", err.Error())
+		return nil
+	}
+
+	blocks := getBlockLanguages(page)
+
+	blocksText, err := json.Marshal(blocks)
+	if err != nil {
+		log.Printf("failed to serialize block languages: [%s]", err.Error())
+		return blocks
+	}
+
+	if err = ioutil.WriteFile(fmt.Sprintf("%s.blocks.json", resultsBase), blocksText, 0644); err != nil {
+		log.Printf("failed to save block languages: [%s]", err.Error())
+	}
+
+	return blocks
+}
+
+func getLibraryVersions() {
+	var files []string
+
+	if matches, err := filepath.Glob(fmt.Sprintf("%s/bin/*", home)); err == nil {
+		files = append(files, matches...)
+	}
+
+	if matches, err := filepath.Glob(fmt.Sprintf("%s/lib/*", home)); err == nil {
+		files = append(files, matches...)
+	}
+
+	runCommand("ldd", files...)
+}
+
+// getSoftwareVersions logs the versions of the tools and libraries we use, returning
+// those of magick and tesseract
+func getSoftwareVersions() (string, string) {
+	magick := getMagickVersion()
+	tesseract := getTesseractVersion()
+
+	getLibraryVersions()
+
+	return magick, tesseract
+}
+
+func saveCommandHistory(resultsBase string) {
+	cmdsText, jsonErr := json.Marshal(cmds)
+	if jsonErr != nil {
+		return
+	}
+
+	cmdsFile := fmt.Sprintf("%s.log", resultsBase)
+
+	if err := ioutil.WriteFile(cmdsFile, cmdsText, 0644); err != nil {
+		return
+	}
+}
+
+// state of an ocr request as it moves through the stages of handleGenericOcrRequest
+type ocrRequest struct {
+	ctx context.Context
+	ocr ocrConfig
+
+	localWorkDir string
+
+	// files matching <resultsBase>.* are uploaded to s3 at the end of the process
+	resultsBase     string
+	localResultsTxt string
+
+	localSourceImage    string
+	sourceInput         string // magick input specification for the source image
+	localConvertedImage string
+	convertedPrefix     string
+
+	outputFormats []string
+	langStr       string
+	autoLang      bool
+	jobID         string
+
+	// reported along with the error if the request fails
+	stage string
+
+	// set when results are eligible for the duplicate index
+	dedupKey        string
+	checkDuplicates bool
+
+	// uploaded last, describing the results and how they were produced
+	manifest *resultsManifest
+
+	// emitted to cloudwatch when the request finishes, if enabled
+	metrics *requestMetrics
+
+	// the text of any previous results, for comparison
+	compare      bool
+	previousText string
+	hasPrevious  bool
+
+	// set when the source image was converted while downloading, and so never saved
+	streamed bool
+
+	tessdataDir  string
+	engine       ocrEngine
+	pages        int
+	retryLowConf bool
+
+	// the parameters the image was converted with
+	params convertParams
+
+	res workflowResponseType
+}
+
+func newOcrRequest(ctx context.Context, ocr ocrConfig) *ocrRequest {
+	r := &ocrRequest{
+		ctx:                 ctx,
+		ocr:                 ocr,
+		localWorkDir:        "/tmp/ocr-lambda",
+		resultsBase:         ocr.resultsBase,
+		localConvertedImage: "source-converted.tif",
+		convertedPrefix:     path.Join(config.convertedPrefix, ocr.remoteResultsPrefix),
+		outputFormats:       append([]string{"txt"}, ocr.additionalFormats...),
+		langStr:             ocr.languages,
+		stage:               stageSetup,
+		metrics:             &requestMetrics{},
+		pages:               1,
+	}
+
+	if r.resultsBase == "" {
+		r.resultsBase = defaultResultsBase
+	}
+
+	r.localResultsTxt = fmt.Sprintf("%s.txt", r.resultsBase)
+
+	sourceName := path.Base(ocr.key)
+	if ocr.imageURL != "" {
+		sourceName = imageURLName(ocr.imageURL)
+	}
+
+	r.localSourceImage = fmt.Sprintf("source-%s", sourceName)
+
+	// set default language if none specified
+	if r.langStr == "" {
+		r.langStr = defaultLanguage
+	}
+
+	r.autoLang = r.langStr == autoLanguage
+
+	r.manifest = &resultsManifest{Bucket: ocr.bucket, Source: ocr.key, SourceURL: ocr.imageURL, Languages: r.langStr, Scale: ocr.scale}
+
+	// progress is recorded in the job status table, if enabled; standalone requests
+	// have no pid, so are identified by their source instead
+	r.jobID = ocr.pid
+	if r.jobID != "" && ocr.batchName != "" {
+		r.jobID = path.Join(r.jobID, ocr.batchName)
+	}
+	if r.jobID == "" {
+		r.jobID = ocr.imageURL
+	}
+	if r.jobID == "" {
+		r.jobID = fmt.Sprintf("s3://%s/%s", ocr.bucket, ocr.key)
+	}
+
+	return r
+}
+
+func handleGenericOcrRequest(ctx context.Context, ocr ocrConfig) (result string, resultErr error) {
+	cmds = &commandHistory{Provenance: ocr.provenance}
+
+	r := newOcrRequest(ctx, ocr)
+
+	// work still in progress shortly before the lambda times out is abandoned, so
+	// that the results and reports so far can be uploaded
+	endWork := startWorkDeadline(ctx)
+	defer endWork()
+
+	// create and change to temporary working directory
+
+	if err := os.MkdirAll(r.localWorkDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work dir: [%s]", err.Error())
+	}
+
+	jobs = startJobTracking(ctx, r.jobID)
+
+	defer func() {
+		result, resultErr = r.finish(result, resultErr)
+	}()
+
+	if err := os.Chdir(r.localWorkDir); err != nil {
+		return "", fmt.Errorf("failed to change to work dir: [%s]", err.Error())
+	}
+
+	// abort any earlier multipart uploads of these results that can no longer be resumed
+
+	abortAbandonedUploads(newS3Client(config.resultsS3), ocr.bucket, ocr.remoteResultsPrefix)
+
+	r.fetchPreviousText()
+
+	// archive the original source image while it is downloaded; the copy is not
+	// essential, but is allowed to finish before this request completes
+
+	if config.archiveSourcePrefix != "" && !ocr.reuseConverted && ocr.imageURL == "" {
+		archived := archiveSource(ocr.bucket, ocr.key)
+		defer func() { <-archived }()
+	}
+
+	if err := r.download(); err != nil {
+		return "", err
+	}
+
+	if output, ok := r.reuseDuplicate(); ok {
+		return output, nil
+	}
+
+	if err := r.prepareLanguages(); err != nil {
+		return "", err
+	}
+
+	if err := r.convert(); err != nil {
+		return "", err
+	}
+
+	if output, skipped, err := r.skipSmallImage(); err != nil || skipped {
+		return output, err
+	}
+
+	if err := r.checkOrientation(); err != nil {
+		return "", err
+	}
+
+	if err := r.recognize(); err != nil {
+		return "", err
+	}
+
+	if err := r.saveResults(); err != nil {
+		return "", err
+	}
+
+	return r.response()
+}
+
+// finish uploads whatever results and logs the request produced, reports how it went,
+// and cleans up, returning the request's final outcome (which a failed upload can change)
+func (r *ocrRequest) finish(result string, resultErr error) (string, error) {
+	ocr := r.ocr
+
+	// failures caused by running out of time are reported as such
+	if _, ok := resultErr.(*workDeadlineError); resultErr != nil && !ok {
+		if timeoutErr := timeoutError(r.stage); timeoutErr != nil {
+			log.Printf("failed after running out of time: %s", resultErr.Error())
+			resultErr = timeoutErr
+		}
+	}
+
+	jobs.transition(jobUploading)
+
+	saveCommandHistory(r.resultsBase)
+
+	if ocr.settings != nil {
+		saveResolvedSettings(r.resultsBase, *ocr.settings)
+	}
+
+	if resultErr != nil {
+		saveErrorReport(r.resultsBase, r.stage, resultErr)
+	}
+
+	// let the caller know if a large upload needs to be resumed
+	uploadStart := time.Now()
+
+	uploaded, err := uploadResults(r.ctx, ocr.bucket, ocr.remoteResultsPrefix, r.resultsBase, ocr.textEncoding)
+	if err != nil {
+		if _, ok := err.(*uploadInterruptedError); ok && resultErr == nil {
+			result, resultErr = "", err
+		} else if resultErr == nil {
+			// the report itself may well fail to upload too, but is worth a try
+			saveErrorReport(r.resultsBase, stageUpload, err)
+			uploader := s3manager.NewUploaderWithClient(newS3Client(config.resultsS3))
+			if reportErr := uploadResult(r.ctx, uploader, ocr.bucket, ocr.remoteResultsPrefix, errorReportFileName(r.resultsBase), "application/json"); reportErr != nil {
+				log.Printf("WARNING: failed to upload error report: [%s]", reportErr.Error())
+			}
+		}
+	}
+
+	if err == nil && resultErr == nil {
+		removeStaleErrorReport(ocr.bucket, ocr.remoteResultsPrefix, r.resultsBase)
+	}
+
+	r.manifest.timeStage(stageUpload, uploadStart)
+	r.manifest.addFiles(ocr.remoteResultsPrefix, uploaded)
+
+	if resultErr != nil {
+		r.manifest.finish(resultErr)
+		r.metrics.emit(r.stage)
+	} else {
+		r.manifest.finish(err)
+
+		if err != nil {
+			r.metrics.emit(stageUpload)
+		} else {
+			r.metrics.emit("")
+		}
+	}
+
+	uploadManifest(r.ctx, ocr.bucket, ocr.remoteResultsPrefix, r.resultsBase, r.manifest)
+
+	if resultErr != nil {
+		jobs.finish(resultErr)
+	} else {
+		jobs.finish(err)
+	}
+	jobs = nil
+
+	notifyCompletion(r.ctx, completionNotification{
+		Pid:          ocr.pid,
+		Bucket:       ocr.bucket,
+		ResultPrefix: ocr.remoteResultsPrefix,
+		ResultsBase:  r.resultsBase,
+		Status:       r.manifest.Status,
+		Error:        r.manifest.Error,
+		Confidence:   r.metrics.confidence,
+	})
+
+	// index successfully uploaded results so identical requests can reuse them
+	if err == nil && resultErr == nil && r.dedupKey != "" {
+		entry := newDuplicateIndexEntry(ocr, r.resultsBase, result, uploaded)
+		saveDuplicateIndexEntry(newS3Client(config.resultsS3), ocr.bucket, r.dedupKey, entry)
+	}
+
+	os.Chdir("/")
+	os.RemoveAll(r.localWorkDir)
+
+	return result, resultErr
+}
+
+// fetchPreviousText keeps the text of any previous results for comparison, before these results replace them
+func (r *ocrRequest) fetchPreviousText() {
+	r.compare = r.ocr.compareWithPrevious
+
+	if !r.compare {
+		return
+	}
+
+	var err error
+	if r.previousText, r.hasPrevious, err = fetchPreviousText(r.ocr.bucket, path.Join(r.ocr.remoteResultsPrefix, r.localResultsTxt)); err != nil {
+		log.Printf("WARNING: skipping comparison with previous results: %s", err.Error())
+		r.compare = false
+	}
+}
+
+// download fetches the source image from s3 (or a previously converted image, which skips conversion)
+func (r *ocrRequest) download() error {
+	ocr := r.ocr
+
+	r.stage = stageDownload
+	jobs.transition(jobDownloading)
+
+	downloadStart := time.Now()
+
+	// custom language files may change at any time, so results using them are never
+	// reused; a comparison with previous results is only meaningful if the text is regenerated;
+	// pdf/a and epub results are titled with the pid they were produced for; whether
+	// textract's text is used depends on its daily limit; and reproducible requests must
+	// be ocr'd by the verified toolchain, which is only checked after the download
+	r.checkDuplicates = config.dedupIndexPrefix != "" && !ocr.reuseConverted && ocr.tessdataDir == "" && !ocr.compareWithPrevious && !ocr.pdfa && !ocr.epub && !ocr.textractFallback && !ocr.reproducible
+
+	if ocr.reuseConverted {
+		bytes, err := downloadImage(ocr.bucket, path.Join(r.convertedPrefix, r.localConvertedImage), r.localConvertedImage)
+		if err != nil {
+			return fmt.Errorf("failed to download previously converted image: [%s]", err.Error())
+		}
+
+		r.metrics.downloadBytes = bytes
+		r.manifest.timeStage(stageDownload, downloadStart)
+
+		return nil
+	}
+
+	// formats that can be decoded sequentially are converted as they are downloaded,
+	// if enabled; anything else (or any failure) falls back to downloading the image
+	// (the duplicate check and multi-page sources need the downloaded image)
+	if config.streamSourceImage && !ocr.multiPage && !r.checkDuplicates && ocr.imageURL == "" && !ocr.originalPdf {
+		params := convertParams{scale: ocr.scale, operations: ocr.convertOperations}
+
+		bytes, ok, err := streamConvertImage(ocr.bucket, ocr.key, r.localConvertedImage, params)
+		if err != nil {
+			log.Printf("failed to stream source image; downloading it instead: %s", err.Error())
+		}
+
+		r.streamed = ok && err == nil
+
+		if r.streamed {
+			r.metrics.downloadBytes = bytes
+		}
+	}
+
+	if ocr.imageURL != "" {
+		bytes, err := downloadImageURL(ocr.imageURL, r.localSourceImage)
+		if err != nil {
+			return err
+		}
+
+		r.metrics.downloadBytes = bytes
+	} else if !r.streamed {
+		bytes, err := downloadImage(ocr.bucket, ocr.key, r.localSourceImage)
+		if err != nil {
+			return err
+		}
+
+		r.metrics.downloadBytes = bytes
+	}
+
+	if !r.streamed {
+		// name the image after its actual format, which may not match the key
+		var err error
+		if r.localSourceImage, r.sourceInput, err = canonicalizeSourceImage(r.localSourceImage); err != nil {
+			return err
+		}
+	}
+
+	r.manifest.timeStage(stageDownload, downloadStart)
+
+	return nil
+}
+
+// reuseDuplicate returns the response for the copied results of an earlier identical request,
+// if any; otherwise it remembers where these results should be indexed once uploaded
+func (r *ocrRequest) reuseDuplicate() (string, bool) {
+	if !r.checkDuplicates {
+		return "", false
+	}
+
+	sourceHash, err := hashFile(r.localSourceImage)
+	if err != nil {
+		log.Printf("skipping duplicate check: failed to hash source image: [%s]", err.Error())
+		return "", false
+	}
+
+	indexKey := dedupIndexKey(sourceHash, newDedupParams(r.ocr).hash())
+
+	res, dupErr := handleDuplicate(r.ocr, indexKey, r.resultsBase, r.manifest)
+	if dupErr == nil {
+		return res, true
+	}
+
+	if dupErr != errNoDuplicate {
+		log.Printf("duplicate results unusable; processing normally: %s", dupErr.Error())
+	}
+
+	r.dedupKey = indexKey
+
+	return "", false
+}
+
+// prepareLanguages logs the versions of the software in use, ensures the language files
+// the request needs are present, and sets up the engine that will use them
+func (r *ocrRequest) prepareLanguages() error {
+	ocr := r.ocr
+
+	r.stage = stageLanguages
+
+	if err := beginStage(r.stage); err != nil {
+		return err
+	}
+
+	r.manifest.Magick, r.manifest.Tesseract = getSoftwareVersions()
+
+	if ocr.engineName != engineTesseract {
+		r.manifest.Engine = ocr.engineName
+	}
+
+	if ocr.engineName == engineKraken {
+		r.manifest.Kraken = getKrakenVersion()
+	}
+
+	// ensure we have all languages/scripts needed, downloading if necessary
+
+	waitForPrecache()
+
+	languageDir := tessdataTypeDir(ocr.tessdataType)
+
+	// languages to detect are only known once the image is converted; until then
+	// only osd (which checkLanguages always includes) is needed
+	initialLangs := r.langStr
+	if r.autoLang {
+		initialLangs = ""
+	}
+
+	runCommand("find", languageDir)
+	runCommand("ls", "-laFR", languageDir)
+	if err := checkLanguages(cmds, initialLangs, ocr.tessdataType); err != nil {
+		return err
+	}
+	runCommand("find", languageDir)
+	runCommand("ls", "-laFR", languageDir)
+
+	// fetch custom trained language files, which are used for this request only;
+	// otherwise use the language files of the requested tier
+
+	if ocr.tessdataDir != "" {
+		r.tessdataDir = filepath.Join(r.localWorkDir, "tessdata")
+
+		if err := downloadTessdataDir(ocr.tessdataDir, ocr.bucket, r.tessdataDir, languageDir, r.langStr); err != nil {
+			return err
+		}
+	} else if languageDir != os.Getenv("TESSDATA_PREFIX") {
+		r.tessdataDir = languageDir
+	}
+
+	r.engine = newOcrEngine(ocr, r.tessdataDir)
+
+	// refuse to run reproducible requests if the toolchain has drifted
+
+	if ocr.reproducible {
+		if err := verifyToolchain(r.langStr, r.resultsBase); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// convert runs magick on single-page sources, keeping a copy of the converted image for
+// later format regenerations if requested; multi-page sources are converted and ocr'd
+// page by page
+func (r *ocrRequest) convert() error {
+	ocr := r.ocr
+
+	r.stage = stageConvert
+
+	if err := beginStage(r.stage); err != nil {
+		return err
+	}
+
+	jobs.transition(jobConverting)
+
+	if ocr.multiPage {
+		count, err := countPages(r.localSourceImage)
+		if err != nil {
+			return err
+		}
+
+		if config.maxPages > 0 && count > config.maxPages {
+			return fmt.Errorf("source image has too many pages: [%d] (maximum %d)", count, config.maxPages)
+		}
+
+		r.pages = count
+	}
+
+	// low confidence results are retried at a larger scale, which needs the source image
+	// (and the confidences only tesseract reports); reproducible requests are always
+	// converted at the scale requested, so that their results can be regenerated
+	r.retryLowConf = config.lowConfidenceThreshold > 0 && r.pages == 1 && !ocr.splitSpread && !ocr.reuseConverted && !r.streamed && ocr.engineName == engineTesseract && !ocr.reproducible
+
+	if ocr.reuseConverted || r.pages != 1 {
+		return nil
+	}
+
+	convertStart := time.Now()
+
+	scale := ocr.scale
+
+	// limit the size of the converted image, and fail early (or convert at a lower
+	// scale) if it will not fit on disk
+
+	if !r.streamed {
+		width, height, err := imageSize(r.localSourceImage)
+		if err != nil {
+			return err
+		}
+
+		capped, err := capOutputPixels(width, height, scale)
+		if err != nil {
+			return err
+		}
+
+		// the space available varies between invocations, so results at a scale reduced
+		// to fit it are not what an identical request would expect; reproducible requests
+		// fail instead, and others are not indexed as duplicates
+		reduce := config.diskSpaceReduceScale && !ocr.reproducible
+
+		fitted, err := fitConvertedImage(r.localWorkDir, width, height, capped, reduce)
+		if err != nil {
+			return err
+		}
+
+		if fitted != capped {
+			r.dedupKey = ""
+		}
+
+		if fitted != scale {
+			scale = fitted
+			r.res.Scale = scale
+			r.manifest.Scale = scale
+		}
+	}
+
+	r.params = convertParams{scale: scale, operations: ocr.convertOperations}
+
+	// pyramidal tiffs are converted from the smallest sufficient level, when that works
+	converted := r.streamed
+
+	if !converted && path.Ext(r.localSourceImage) == ".tif" {
+		if level := selectPyramidLevel(r.localSourceImage, scale); level != nil {
+			levelInput := fmt.Sprintf("tiff:%s[%d]", r.localSourceImage, level.Level)
+			levelParams := convertParams{scale: level.Scale, operations: ocr.convertOperations}
+
+			if err := runConvert(levelInput, r.localConvertedImage, levelParams); err != nil {
+				log.Printf("failed to convert pyramid level; converting full resolution image: %s", err.Error())
+			} else {
+				r.res.PyramidLevel = level
+				converted = true
+			}
+		}
+	}
+
+	if !converted {
+		if err := r.engine.convert(r.localSourceImage, r.sourceInput, r.localConvertedImage, r.params); err != nil {
+			return err
+		}
+	}
+
+	logConvertedSize(r.localSourceImage, r.localConvertedImage)
+	r.manifest.timeStage(stageConvert, convertStart)
+	r.metrics.convertSeconds = time.Since(convertStart).Seconds()
+
+	// nothing further needs the (often large) source image, unless it is the pdf page
+	// image or may be converted again
+	if ocr.cleanupIntermediates && !r.streamed && !ocr.originalPdf && !r.retryLowConf {
+		removeIntermediate(r.localSourceImage)
+	}
+
+	if ocr.saveConverted {
+		uploader := s3manager.NewUploaderWithClient(newS3Client(config.resultsS3))
+		if err := uploadResult(r.ctx, uploader, ocr.bucket, r.convertedPrefix, r.localConvertedImage, ""); err != nil {
+			log.Printf("WARNING: failed to save converted image: [%s]", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// skipSmallImage saves empty results for images too small to produce meaningful text,
+// returning the response for them (pages of multi-page sources are always ocr'd)
+func (r *ocrRequest) skipSmallImage() (string, bool, error) {
+	if r.pages != 1 {
+		return "", false, nil
+	}
+
+	small, err := isSmallImage(r.localConvertedImage)
+	if err != nil || !small {
+		return "", false, err
+	}
+
+	if err = ioutil.WriteFile(r.localResultsTxt, []byte{}, 0644); err != nil {
+		return "", false, fmt.Errorf("failed to save empty ocr results: [%s]", err.Error())
+	}
+
+	if r.ocr.pageNumber != 0 {
+		if err = injectPageNumber(r.resultsBase, r.ocr.pageNumber); err != nil {
+			return "", false, err
+		}
+	}
+
+	output, jsonErr := json.Marshal(workflowResponseType{Formats: []string{"txt"}, SkippedSmallImage: true, PageNumber: r.ocr.pageNumber})
+	if jsonErr != nil {
+		return "", false, fmt.Errorf("failed to serialize output: [%s]", jsonErr.Error())
+	}
+
+	return string(output), true, nil
+}
+
+// checkOrientation detects the orientation and script of the image, if needed to rotate it
+// upright or to choose languages for it; multi-page sources have no single image to check
+func (r *ocrRequest) checkOrientation() error {
+	if (!r.autoLang && !r.ocr.autoRotate) || r.pages != 1 {
+		return nil
+	}
+
+	osd, err := detectOrientation(r.localConvertedImage, r.tessdataDir)
+	if err != nil {
+		log.Printf("WARNING: %s", err.Error())
+	}
+
+	if r.ocr.autoRotate && osd != nil {
+		if r.res.Rotated, err = rotateUpright(r.localConvertedImage, osd); err != nil {
+			return err
+		}
+	}
+
+	if r.autoLang {
+		r.stage = stageLanguages
+
+		r.langStr = detectLanguages(osd)
+
+		if err = checkLanguages(cmds, r.langStr, r.ocr.tessdataType); err != nil {
+			return err
+		}
+
+		r.manifest.Languages = r.langStr
+		r.res.Lang = r.langStr
+	}
+
+	return nil
+}
+
+// recognize runs tesseract, on each page separately for multi-page sources and double-page
+// spreads, and summarizes the confidence of the words it found; tsv output is always
+// produced, for its word confidences, as is hocr if results derived from it were requested
+func (r *ocrRequest) recognize() error {
+	ocr := r.ocr
+
+	r.stage = stageOcr
+
+	if err := beginStage(r.stage); err != nil {
+		return err
+	}
+
+	jobs.transition(jobOcring)
+
+	ocrFormats := r.outputFormats
+	if !hasFormat(ocrFormats, "tsv") {
+		ocrFormats = append(append([]string{}, r.outputFormats...), "tsv")
+	}
+
+	if ocr.needsHocr() && !hasFormat(ocrFormats, "hocr") {
+		ocrFormats = append(append([]string{}, ocrFormats...), "hocr")
+	}
+
+	ocrStart := time.Now()
+
+	if r.pages > 1 {
+		log.Printf("source image has %d pages", r.pages)
+
+		params := convertParams{scale: ocr.scale, operations: ocr.convertOperations}
+
+		if err := ocrPages(r.localSourceImage, r.pages, r.resultsBase, r.langStr, ocrFormats, r.tessdataDir, ocr.engine, params, ocr.cleanupIntermediates); err != nil {
+			return err
+		}
+
+		if ocr.cleanupIntermediates {
+			removeIntermediate(r.localSourceImage)
+		}
+
+		r.res.Pages = r.pages
+	} else if ocr.splitSpread {
+		if err := ocrSpread(r.localConvertedImage, r.resultsBase, r.langStr, ocrFormats, r.tessdataDir, ocr.engine, ocr.cleanupIntermediates); err != nil {
+			return err
+		}
+	} else {
+		if err := r.engine.recognize(r.localConvertedImage, r.resultsBase, r.langStr, ocrFormats); err != nil {
+			return err
+		}
+
+		if r.retryLowConf {
+			scale, err := retryLowConfidence(r.engine, r.localSourceImage, r.sourceInput, r.localConvertedImage, r.resultsBase, r.langStr, ocrFormats, r.params, r.res.Rotated)
+			if err != nil {
+				return err
+			}
+
+			if scale != r.params.scale {
+				r.res.Scale = scale
+				r.manifest.Scale = scale
+			}
+
+			if ocr.cleanupIntermediates && !ocr.originalPdf {
+				removeIntermediate(r.localSourceImage)
+			}
+		}
+
+		// the pdf of the converted image is still a usable (if lesser) deliverable
+		if ocr.originalPdf {
+			if err := saveOriginalImagePdf(r.sourceInput, r.resultsBase, r.langStr, r.tessdataDir, ocr.engine, r.res.Rotated); err != nil {
+				log.Printf("WARNING: keeping pdf of converted image: %s", err.Error())
+			}
+		}
+	}
+
+	r.manifest.timeStage(stageOcr, ocrStart)
+	r.metrics.ocrSeconds = time.Since(ocrStart).Seconds()
+
+	// summarize word confidences so that low quality ocr can be flagged for review
+
+	tsvFiles := tsvResultsFiles(r.resultsBase)
+
+	if confidence, pageWords, err := ocrConfidence(tsvFiles); err != nil {
+		log.Printf("WARNING: skipping confidence summary: %s", err.Error())
+	} else {
+		r.res.Confidence = confidence
+		r.res.PageWords = pageWords
+
+		r.metrics.confidence = confidence
+		for _, words := range pageWords {
+			r.metrics.words += words
+		}
+	}
+
+	// use textract's text instead if tesseract found (almost) none, and the request allows it;
+	// the other results are still tesseract's
+
+	if ocr.textractFallback && r.metrics.words < config.textractMinWords {
+		if text := textractFallback(ocr.bucket, ocr.key, r.localResultsTxt); text != nil {
+			r.res.Textract = true
+			r.res.Confidence = text.confidence
+			r.res.PageWords = []int{text.words}
+
+			r.metrics.confidence = text.confidence
+			r.metrics.words = text.words
+
+			r.manifest.Textract = true
+		}
+	}
+
+	if !hasFormat(r.outputFormats, "tsv") {
+		for _, tsvFile := range tsvFiles {
+			os.Remove(tsvFile)
+		}
+	}
+
+	return nil
+}
+
+// saveResults derives the other requested results from tesseract's output
+func (r *ocrRequest) saveResults() error {
+	ocr := r.ocr
+
+	r.stage = stageResults
+
+	if err := beginStage(r.stage); err != nil {
+		return err
+	}
+
+	// extract word coordinates for viewers and text in reading order, before the page
+	// number is recorded in the hocr
+
+	if ocr.words {
+		r.res.Words = saveWordCoordinates(r.resultsBase)
+	}
+
+	if ocr.layout || ocr.epub {
+		book := epubInfo{identifier: r.jobID, title: r.jobID, language: strings.Split(r.langStr, "+")[0]}
+
+		if err := saveLayoutResults(r.resultsBase, ocr.layout, ocr.epub, book); err != nil {
+			return err
+		}
+	}
+
+	if ocr.needsHocr() && !hasFormat(r.outputFormats, "hocr") {
+		removeHocrResults(r.resultsBase)
+	}
+
+	// reduce the resolution of the pdf page images, if requested; tesseract has already
+	// recognized the converted image at full resolution
+
+	if ocr.pdfDpi > 0 && hasFormat(r.outputFormats, "pdf") {
+		if err := downsamplePdfResults(r.resultsBase, ocr.pdfDpi); err != nil {
+			return err
+		}
+	}
+
+	// make pdf output acceptable to the preservation system, if requested
+
+	if ocr.pdfa {
+		if err := convertResultsToPdfa(r.resultsBase, ocr.pid); err != nil {
+			return err
+		}
+	}
+
+	// determine dominant language per text block when multiple languages were requested
+
+	if strings.Contains(r.langStr, "+") && hasFormat(r.outputFormats, "hocr") && r.pages == 1 {
+		r.res.Blocks = saveBlockLanguages(r.resultsBase)
+	}
+
+	// clean up the text for ingest, if requested; other text results are left as is
+
+	if ocr.cleanup {
+		if err := cleanupTextFile(r.localResultsTxt); err != nil {
+			return err
+		}
+
+		r.res.Cleanup = config.cleanupSteps
+	}
+
+	return nil
+}
+
+// response reads the ocr text results into the response, and finalizes the text files
+func (r *ocrRequest) response() (string, error) {
+	ocr := r.ocr
+	res := &r.res
+
+	resultsText, readErr := ioutil.ReadFile(r.localResultsTxt)
+	if readErr != nil {
+		return "", fmt.Errorf("failed to read ocr results file: [%s]", readErr.Error())
+	}
+
+	// score the text so that poor results can be routed for re-ocr or transcription
+
+	res.Quality = scoreText(string(resultsText), r.langStr)
+	r.manifest.Quality = res.Quality
+
+	res.Text = string(resultsText)
+	res.Formats = r.outputFormats
+	res.Provenance = ocr.provenance
+
+	if r.compare {
+		stats := diffStatsType{SimilarityPct: -1}
+		if r.hasPrevious {
+			stats = compareText(stripPageMarker(r.previousText), res.Text)
+		}
+		res.DiffStats = &stats
+	}
+
+	// record the page number in the results files; the response reports it separately
+
+	if ocr.pageNumber != 0 {
+		if err := injectPageNumber(r.resultsBase, ocr.pageNumber); err != nil {
+			return "", err
+		}
+
+		res.PageNumber = ocr.pageNumber
+	}
+
+	// the delivery copy carries the banner; results.txt (and the response text) never do
+
+	textFiles := []string{r.localResultsTxt}
+
+	if ocr.settings != nil && ocr.settings.Banner {
+		res.DeliveryFile = deliveryFileName(r.resultsBase)
+
+		if err := saveDeliveryText(res.DeliveryFile, *ocr.settings, resultsText, time.Now()); err != nil {
+			return "", err
+		}
+
+		textFiles = append(textFiles, res.DeliveryFile)
+	}
+
+	// text files are re-encoded only after the (utf-8) response text has been read
+
+	if ocr.layout {
+		textFiles = append(textFiles, fmt.Sprintf("%s.layout.txt", r.resultsBase))
+	}
+
+	for _, base := range spreadResultsBases(r.resultsBase) {
+		textFiles = append(textFiles, fmt.Sprintf("%s.txt", base))
+	}
+
+	textFiles = append(textFiles, pageTextFiles(r.resultsBase)...)
+
+	for _, textFile := range textFiles {
+		if _, err := os.Stat(textFile); err != nil {
+			continue
+		}
+
+		unmapped, err := encodeTextFile(textFile, ocr.textEncoding)
+		if err != nil {
+			return "", err
+		}
+
+		// the other files repeat (parts of) the same text
+		if textFile == r.localResultsTxt {
+			res.UnmappedChars = unmapped
+		}
+	}
+
+	if ocr.settings != nil {
+		res.Settings = ocr.settings.Sources
+	}
+
+	output, jsonErr := json.Marshal(res)
+	if jsonErr != nil {
+		return "", fmt.Errorf("failed to serialize output: [%s]", jsonErr.Error())
+	}
+
+	return string(output), nil
+}
+
+var errNoDuplicate = errors.New("no duplicate results found")
+
+// handleDuplicate copies indexed results for an identical earlier request, returning the response
+func handleDuplicate(ocr ocrConfig, indexKey, resultsBase string, manifest *resultsManifest) (string, error) {
+	svc := newS3Client(config.resultsS3)
+
+	entry, err := lookupDuplicate(svc, ocr.bucket, indexKey)
+	if err != nil {
+		return "", err
+	}
+
+	if entry == nil {
+		return "", errNoDuplicate
+	}
+
+	log.Printf("source image was previously processed with identical parameters: %s", entry.location())
+
+	text, copied, err := copyDuplicateResults(svc, entry, ocr.bucket, ocr.remoteResultsPrefix, resultsBase)
+	if err != nil {
+		return "", err
+	}
+
+	var words []wordsPageType
+
+	if ocr.words {
+		if words, err = readDuplicateWords(svc, entry); err != nil {
+			return "", err
+		}
+	}
+
+	res := entry.response()
+
+	manifest.DuplicateOf = entry.location()
+	manifest.Files = append(manifest.Files, copied...)
+	manifest.Quality = res.Quality
+
+	if res.Lang != "" {
+		manifest.Languages = res.Lang
+	}
+
+	if res.Scale != "" {
+		manifest.Scale = res.Scale
+	}
+
+	// the response text is always utf-8, whatever the results text was encoded as
+	res.Text = stripPageMarker(decodeText(text, ocr.textEncoding))
+	res.Words = words
+	res.DuplicateOf = entry.location()
+	res.Provenance = ocr.provenance
+	res.PageNumber = ocr.pageNumber
+
+	if res.Formats == nil {
+		res.Formats = append([]string{"txt"}, ocr.additionalFormats...)
+	}
+
+	if ocr.settings != nil {
+		res.Settings = ocr.settings.Sources
+	}
+
+	// requests with a banner only match results that have a delivery copy with the same banner
+	if ocr.settings != nil && ocr.settings.Banner {
+		res.DeliveryFile = deliveryFileName(resultsBase)
+	}
+
+	output, err := json.Marshal(res)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize output: [%s]", err.Error())
+	}
+
+	return string(output), nil
+}
+
+// buildWorkflowOcrConfig validates a workflow request and derives its ocr settings and results location
+func buildWorkflowOcrConfig(req workflowRequestType) (*ocrConfig, error) {
+	ocr := &ocrConfig{}
+
+	// set values from request json
+
+	ocr.bucket = req.Bucket
+	ocr.key = req.Key
+	ocr.pid = req.Pid
+	ocr.imageURL = req.ImageURL
+
+	settings := resolveSettings(operatorSettingsCache.get(), req.ParentPid, settingsDefaults{Lang: req.Lang, Scale: req.Scale, Banner: req.Banner})
+
+	ocr.languages = settings.Lang
+	ocr.scale = settings.Scale
+	ocr.settings = &settings
+	ocr.reproducible = req.Reproducible
+	ocr.saveConverted = req.SaveConverted
+	ocr.reuseConverted = req.ReuseConverted
+	ocr.splitSpread = req.SplitSpread
+	ocr.cleanupIntermediates = config.cleanupIntermediates
+	ocr.tessdataDir = req.TessdataDir
+	ocr.tessdataType = config.tessdataType
+	ocr.autoRotate = config.autoRotate
+	ocr.compareWithPrevious = req.CompareWithPrevious
+	ocr.multiPage = req.MultiPage
+	ocr.words = req.Words
+
+	// the converted image and page halves are only meaningful for single-page sources
+	if ocr.multiPage && (ocr.splitSpread || ocr.reuseConverted) {
+		return nil, errors.New("multipage cannot be combined with splitspread or reuseconverted")
+	}
+
+	if req.AutoRotate != nil {
+		ocr.autoRotate = *req.AutoRotate
+	}
+
+	if req.TessdataType != "" {
+		if err := validateTessdataType(req.TessdataType); err != nil {
+			return nil, err
+		}
+
+		ocr.tessdataType = req.TessdataType
+	}
+
+	// the pinned toolchain describes the operator's configured language files
+	if ocr.reproducible && ocr.tessdataType != config.tessdataType {
+		return nil, fmt.Errorf("reproducible requests must use the configured tessdata type: [%s]", config.tessdataType)
+	}
+
+	// custom language files are not pinned, so the toolchain check could not cover them
+	if ocr.reproducible && ocr.tessdataDir != "" {
+		return nil, errors.New("tessdatadir cannot be combined with reproducible")
+	}
+
+	// detected languages are only known after the toolchain is verified and any
+	// custom language files are fetched, and there is a single image to detect them in
+	if ocr.languages == autoLanguage && (ocr.reproducible || ocr.tessdataDir != "" || ocr.multiPage) {
+		return nil, errors.New("lang auto cannot be combined with reproducible, tessdatadir or multipage")
+	}
+
+	if req.TextEncoding != "" {
+		if err := validateTextEncoding(req.TextEncoding); err != nil {
+			return nil, err
+		}
+
+		ocr.textEncoding = req.TextEncoding
+	}
+
+	formats, err := resolveFormats(req.Formats, config.workflowFormats, config.allowedFormats)
+	if err != nil {
+		return nil, err
+	}
+
+	ocr.additionalFormats = formats
+
+	if req.Pdfa && !hasFormat(formats, "pdf") {
+		return nil, errors.New("pdfa requires pdf output")
+	}
+
+	ocr.pdfa = req.Pdfa
+	ocr.layout = req.Layout
+	ocr.epub = req.Epub
+	ocr.cleanup = req.Cleanup
+	ocr.engineName = engineTesseract
+
+	if req.Engine != "" {
+		if err = validateEngine(req.Engine); err != nil {
+			return nil, err
+		}
+
+		ocr.engineName = req.Engine
+	}
+
+	if err = validateEngineRequest(ocr.engineName, req, formats); err != nil {
+		return nil, err
+	}
+
+	if req.TextractFallback {
+		if err = validateTextractRequest(req, ocr.engineName); err != nil {
+			return nil, err
+		}
+
+		ocr.textractFallback = true
+	}
+
+	if req.ResultPrefix != "" {
+		if err = validateResultPrefix(req.ResultPrefix); err != nil {
+			return nil, err
+		}
+
+		ocr.resultsBase = req.ResultPrefix
+	}
+
+	if req.PdfDpi != 0 {
+		if err = validatePdfDpi(req.PdfDpi); err != nil {
+			return nil, err
+		}
+
+		ocr.pdfDpi = req.PdfDpi
+	}
+
+	if err = validatePageNumber(req.PageNumber); err != nil {
+		return nil, err
+	}
+
+	ocr.pageNumber = req.PageNumber
+
+	if ocr.convertOperations, err = preprocessOperations(req.Preprocess, req.Binarize, req.Threshold, req.Window, req.Offset); err != nil {
+		return nil, err
+	}
+
+	if ocr.engine, err = resolveTesseractParams(req.Psm, req.Oem); err != nil {
+		return nil, err
+	}
+
+	// build s3 results path
+
+	prefix, desc, err := buildResultsPrefix(resultsKindWorkflow, resultsPrefixParams{pid: req.Pid, parentPid: req.ParentPid, scale: req.Scale})
+	if err != nil {
+		return nil, err
+	}
+
+	ocr.remoteResultsPrefix = prefix
+
+	log.Printf("results prefix: %s", desc)
+
+	return ocr, nil
+}
+
+func handleWorkflowOcrRequest(ctx context.Context, req lambdaRequestType) (string, error) {
+	if len(req.Keys) > 0 {
+		return handleWorkflowBatchRequest(ctx, req)
+	}
+
+	log.Print("handling workflow ocr request")
+
+	ocr, err := buildWorkflowOcrConfig(req.workflowRequestType)
+	if err != nil {
+		return "", err
+	}
+
+	return handleGenericOcrRequest(ctx, *ocr)
+}
+
+// buildStandaloneOcrConfig derives ocr settings and results location from the first s3 record
+func buildStandaloneOcrConfig(req lambdaRequestType) (*ocrConfig, error) {
+	ocr := &ocrConfig{}
+
+	// set values from request json
+
+	ocr.bucket = req.Records[0].S3.Bucket.Name
+	ocr.key = req.Records[0].S3.Object.Key
+	ocr.provenance = recordProvenance(req.Records[0])
+
+	settings := resolveSettings(operatorSettingsCache.get(), "", settingsDefaults{})
+
+	ocr.languages = settings.Lang
+	ocr.scale = settings.Scale
+	ocr.settings = &settings
+	ocr.additionalFormats = additionalFormats(config.standaloneFormats)
+	ocr.originalPdf = config.originalImagePdf && hasFormat(ocr.additionalFormats, "pdf")
+	ocr.engineName = engineTesseract
+	ocr.engine = defaultTesseractParams
+	ocr.tessdataType = config.tessdataType
+	ocr.autoRotate = config.autoRotate
+	ocr.cleanupIntermediates = config.cleanupIntermediates
+
+	// build s3 results path
+
+	prefix, desc, err := buildResultsPrefix(resultsKindStandalone, resultsPrefixParams{key: ocr.key})
+	if err != nil {
+		return nil, err
+	}
+
+	ocr.remoteResultsPrefix = prefix
+
+	log.Printf("results prefix: %s", desc)
+
+	return ocr, nil
+}
+
+// recordProvenance extracts the origin of an s3 event's object, or nil if the event has none
+func recordProvenance(rec s3RecordType) *provenanceType {
+	p := provenanceType{
+		EventName:       rec.EventName,
+		EventTime:       rec.EventTime,
+		PrincipalID:     rec.UserIdentity.PrincipalID,
+		SourceIPAddress: rec.RequestParameters.SourceIPAddress,
+	}
+
+	if p == (provenanceType{}) {
+		return nil
+	}
+
+	return &p
+}
+
+func handleStandaloneOcrRequest(ctx context.Context, req lambdaRequestType) (string, error) {
+	log.Print("handling standalone ocr request")
+
+	ocr, err := buildStandaloneOcrConfig(req)
+	if err != nil {
+		return "", err
+	}
+
+	return handleGenericOcrRequest(ctx, *ocr)
+}
+
+// a way in which this lambda can be invoked; to add a new invocation mode,
+// add an entry to requestModes in order of precedence
+type requestMode struct {
+	name     string
+	matches  func(req lambdaRequestType) bool
+	validate func(req lambdaRequestType) error
+	handle   func(ctx context.Context, req lambdaRequestType) (string, error)
+}
+
+var requestModes = []requestMode{
+	{
+		name:     "engine info",
+		matches:  func(req lambdaRequestType) bool { return req.Action == actionEngineInfo },
+		validate: func(req lambdaRequestType) error { return nil },
+		handle:   func(ctx context.Context, req lambdaRequestType) (string, error) { return handleEngineInfoRequest() },
+	},
+	{
+		name:     "resume upload",
+		matches:  func(req lambdaRequestType) bool { return req.ResumeUpload != "" },
+		validate: validateResumeUploadRequest,
+		handle:   handleResumeUploadRequest,
+	},
+	{
+		name:     "workflow",
+		matches:  func(req lambdaRequestType) bool { return req.Pid != "" },
+		validate: validateWorkflowOcrRequest,
+		handle:   handleWorkflowOcrRequest,
+	},
+	{
+		name:     "sns-wrapped standalone",
+		matches:  isSnsRequest,
+		validate: func(req lambdaRequestType) error { return nil },
+		handle:   handleSnsOcrRequest,
+	},
+	{
+		name:     "standalone",
+		matches:  func(req lambdaRequestType) bool { return len(req.Records) > 0 },
+		validate: validateStandaloneOcrRequest,
+		handle:   handleStandaloneOcrRequest,
+	},
+}
+
+// actions for non-ocr requests
+const actionEngineInfo = "engine-info"
+
+func validateWorkflowOcrRequest(req lambdaRequestType) error {
+	if len(req.Keys) > 0 {
+		return validateWorkflowBatchRequest(req)
+	}
+
+	// results are always written to the bucket, even if the image is fetched by url
+	if req.ImageURL != "" {
+		if req.Bucket == "" || req.Key != "" {
+			return errors.New("workflow request with imageurl must have a bucket, and no key")
+		}
+
+		return validateImageURL(req.ImageURL)
+	}
+
+	if req.Bucket == "" || req.Key == "" {
+		return errors.New("workflow request is missing bucket and/or key")
+	}
+
+	return validateSourceKey(req.Key)
+}
+
+func validateStandaloneOcrRequest(req lambdaRequestType) error {
+	if req.Records[0].S3.Bucket.Name == "" || req.Records[0].S3.Object.Key == "" {
+		return errors.New("standalone request is missing s3 bucket name and/or object key")
+	}
+
+	return validateSourceKey(req.Records[0].S3.Object.Key)
+}
+
+const snsEventSource = "aws:sns"
+
+func isSnsRequest(req lambdaRequestType) bool {
+	return len(req.Records) > 0 && req.Records[0].EventSource == snsEventSource
+}
+
+// snsEventFromRequest recovers the sns layer of a request that was decoded as an s3 event
+func snsEventFromRequest(req lambdaRequestType) snsS3Event {
+	var event snsS3Event
+
+	for _, rec := range req.Records {
+		event.Records = append(event.Records, snsRecordType{EventSource: rec.EventSource, Sns: rec.Sns})
+	}
+
+	return event
+}
+
+// unwrapSnsS3Event decodes the s3 event embedded in each sns message, returning all s3 records found
+func unwrapSnsS3Event(event snsS3Event) ([]s3RecordType, error) {
+	var records []s3RecordType
+
+	for i, rec := range event.Records {
+		var inner s3MessageEventType
+
+		if err := json.Unmarshal([]byte(rec.Sns.Message), &inner); err != nil {
+			return nil, fmt.Errorf("failed to parse s3 event in sns record %d: [%s]", i, err.Error())
+		}
+
+		records = append(records, inner.Records...)
+	}
+
+	return records, nil
+}
+
+func handleSnsOcrRequest(ctx context.Context, req lambdaRequestType) (string, error) {
+	log.Print("handling sns-wrapped standalone ocr request(s)")
+
+	records, err := unwrapSnsS3Event(snsEventFromRequest(req))
+	if err != nil {
+		return "", err
+	}
+
+	if len(records) == 0 {
+		return "", errors.New("sns message(s) contained no s3 records")
+	}
+
+	var outputs []json.RawMessage
+	var failures []string
+
+	for i, rec := range records {
+		s3Req := lambdaRequestType{s3MessageEventType: s3MessageEventType{Records: []s3RecordType{rec}}}
+
+		if err = validateStandaloneOcrRequest(s3Req); err != nil {
+			failures = append(failures, fmt.Sprintf("record %d: %s", i, err.Error()))
+			continue
+		}
+
+		output, ocrErr := handleStandaloneOcrRequest(ctx, s3Req)
+		if ocrErr != nil {
+			failures = append(failures, fmt.Sprintf("record %d (%s): %s", i, rec.S3.Object.Key, ocrErr.Error()))
+			continue
+		}
+
+		outputs = append(outputs, json.RawMessage(output))
+	}
+
+	if len(failures) > 0 {
+		return "", fmt.Errorf("failed to process %d of %d s3 records: %s", len(failures), len(records), strings.Join(failures, "; "))
+	}
+
+	output, err := json.Marshal(outputs)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize output: [%s]", err.Error())
+	}
+
+	return string(output), nil
+}
+
+func handleOcrRequest(ctx context.Context, req lambdaRequestType) (string, error) {
+	result, err := dispatchOcrRequest(ctx, req)
+
+	// a state machine waiting on the request is told its outcome, whatever it was
+	if req.TaskToken != "" {
+		sendTaskResult(ctx, req.TaskToken, result, err)
+	}
+
+	return result, err
+}
+
+func dispatchOcrRequest(ctx context.Context, req lambdaRequestType) (string, error) {
+	for _, mode := range requestModes {
+		if !mode.matches(req) {
+			continue
+		}
+
+		if err := mode.validate(req); err != nil {
+			return "", fmt.Errorf("invalid %s request: %s", mode.name, err.Error())
+		}
+
+		return mode.handle(ctx, req)
+	}
+
+	if req.Action != "" {
+		return "", fmt.Errorf("unhandled request type: unknown action [%s]", req.Action)
+	}
+
+	return "", errors.New("unhandled request type: no pid or s3 records present")
+}
+
+func init() {
+	// load configuration
+
+	loadConfig()
+	loadLanguageDependencies()
+
+	rand.Seed(time.Now().UnixNano())
+
+	tessdataHTTPClient = &http.Client{
+		Timeout:   time.Duration(config.tessdataTimeoutSecs) * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConns: 4},
+	}
+
+	imageURLClient = &http.Client{
+		Timeout:   time.Duration(config.imageURLTimeoutSecs) * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConns: 4},
+	}
+
+	visionHTTPClient = &http.Client{
+		Timeout:   time.Duration(config.visionTimeoutSecs) * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConns: 4},
+	}
+
+	// initialize aws session
 
 	sess = session.Must(session.NewSession())
 
@@ -525,8 +2629,12 @@ func init() {
 
 	os.RemoveAll(tessdataLocal)
 	exec.Command("cp", "-R", "-p", tessdataLambda, tessdataLocal).Run()
+
+	// start downloading commonly used languages before the first request arrives
+
+	precacheLanguages()
 }
 
 func main() {
-	lambda.Start(handleOcrRequest)
+	lambda.Start(handleLambdaRequest)
 }
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestWithRetriesRecordsIntoGivenHistory(t *testing.T) {
+	saved := cmds
+	defer func() { cmds = saved }()
+
+	history := &commandHistory{}
+	policy := retryPolicy{maxRetries: 2}
+
+	const workers = 8
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			withRetries(history, "upload part", policy, func() error {
+				return awserr.New("SlowDown", "slow down", nil)
+			})
+		}()
+	}
+
+	// a new request replacing the global history must not affect the workers
+	for i := 0; i < workers; i++ {
+		cmds = &commandHistory{}
+	}
+
+	wg.Wait()
+
+	if got, want := len(history.Retries), workers*(policy.maxRetries+1); got != want {
+		t.Errorf("got %d retries, want %d", got, want)
+	}
+
+	if len(cmds.Retries) != 0 {
+		t.Errorf("retries were recorded in the global history: %d", len(cmds.Retries))
+	}
+}
+
+func TestWithRetriesStopsOnPermanentErrors(t *testing.T) {
+	history := &commandHistory{}
+	calls := 0
+
+	err := withRetries(history, "download", retryPolicy{maxRetries: 3}, func() error {
+		calls++
+		return awserr.New("NoSuchKey", "not found", nil)
+	})
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if calls != 1 {
+		t.Errorf("got %d attempts, want 1", calls)
+	}
+
+	if len(history.Retries) != 1 || history.Retries[0].Retryable {
+		t.Errorf("unexpected retries recorded: %+v", history.Retries)
+	}
+}
+
+func TestWithRetriesWithoutHistory(t *testing.T) {
+	calls := 0
+
+	err := withRetries(nil, "tessdata cache download", retryPolicy{maxRetries: 1}, func() error {
+		calls++
+		if calls == 1 {
+			return awserr.New("ServiceUnavailable", "unavailable", nil)
+		}
+		return nil
+	})
+
+	if err != nil || calls != 2 {
+		t.Errorf("got error %v after %d attempts, want success after 2", err, calls)
+	}
+}
+
+// useConfig restores the lambda configuration once the test is done with it
+func useConfig(t *testing.T) {
+	t.Helper()
+
+	saved := config
+
+	t.Cleanup(func() { config = saved })
+}
+
+// useWorkDir runs the rest of the test in a new temporary directory
+func useWorkDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	savedDir, _ := os.Getwd()
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.Chdir(savedDir) })
+
+	return dir
+}
+
+// useEnv sets environment variables (unsetting those given as empty) for the duration of the test
+func useEnv(t *testing.T, vars map[string]string) {
+	t.Helper()
+
+	for name, value := range vars {
+		saved, ok := os.LookupEnv(name)
+
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+
+		name := name
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, saved)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+// useOperatorSettings serves the given operator settings document for the duration of the test
+func useOperatorSettings(t *testing.T, settings string) {
+	t.Helper()
+
+	useConfig(t)
+
+	source := filepath.Join(t.TempDir(), "settings.json")
+
+	if err := ioutil.WriteFile(source, []byte(settings), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config.settingsSource = source
+	config.settingsTTLSecs = 3600
+
+	reset := func() {
+		operatorSettingsCache.mu.Lock()
+		defer operatorSettingsCache.mu.Unlock()
+
+		operatorSettingsCache.current = nil
+		operatorSettingsCache.loadedAt = time.Time{}
+	}
+
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestBuildWorkflowOcrConfigValidation(t *testing.T) {
+	useConfig(t)
+
+	config.allowedFormats = []string{"hocr", "pdf", "tsv"}
+	config.workflowFormats = []string{"hocr"}
+	config.tessdataType = "fast"
+	config.textractDailyLimit = 100
+
+	valid := workflowRequestType{Bucket: "bucket", Key: "image.tif", Pid: "uva-lib:2"}
+
+	tests := []struct {
+		name   string
+		modify func(req *workflowRequestType)
+		errMsg string
+	}{
+		{"invalid pid", func(req *workflowRequestType) { req.Pid = "a/b" }, "invalid pid"},
+		{"invalid parent pid", func(req *workflowRequestType) { req.ParentPid = ".." }, "invalid parent pid"},
+		{"invalid scale", func(req *workflowRequestType) { req.Scale = "../50" }, "invalid scale"},
+		{"multipage with splitspread", func(req *workflowRequestType) { req.MultiPage, req.SplitSpread = true, true }, "multipage cannot be combined"},
+		{"invalid tessdata type", func(req *workflowRequestType) { req.TessdataType = "huge" }, "invalid tessdata type"},
+		{"reproducible with another tessdata type", func(req *workflowRequestType) { req.Reproducible, req.TessdataType = true, "best" }, "configured tessdata type"},
+		{"reproducible with tessdatadir", func(req *workflowRequestType) { req.Reproducible, req.TessdataDir = true, "custom" }, "tessdatadir cannot be combined with reproducible"},
+		{"auto language with tessdatadir", func(req *workflowRequestType) { req.Lang, req.TessdataDir = autoLanguage, "custom" }, "lang auto cannot be combined"},
+		{"invalid text encoding", func(req *workflowRequestType) { req.TextEncoding = "ebcdic" }, "unsupported text encoding"},
+		{"unknown format", func(req *workflowRequestType) { req.Formats = []string{"docx"} }, "docx"},
+		{"no enabled format", func(req *workflowRequestType) { req.Formats = []string{"alto"} }, "none of the requested output formats are enabled"},
+		{"pdfa without pdf", func(req *workflowRequestType) { req.Pdfa = true }, "pdfa requires pdf output"},
+		{"unknown engine", func(req *workflowRequestType) { req.Engine = "ocropus" }, "unsupported ocr engine"},
+		{"invalid result prefix", func(req *workflowRequestType) { req.ResultPrefix = "../results" }, "result prefix may only contain"},
+		{"source result prefix", func(req *workflowRequestType) { req.ResultPrefix = "source-page" }, "working name \"source\""},
+		{"retry result prefix", func(req *workflowRequestType) { req.ResultPrefix = "retry-results" }, "working name \"retry-results\""},
+		{"retry image result prefix", func(req *workflowRequestType) { req.ResultPrefix = "retry-converted" }, "working name \"retry-converted\""},
+		{"pdfa result prefix", func(req *workflowRequestType) { req.ResultPrefix = "pdfa-output" }, "working name \"pdfa-output\""},
+		{"vision result prefix", func(req *workflowRequestType) { req.ResultPrefix = "vision-input_2" }, "working name \"vision-input\""},
+		{"pdf dpi out of range", func(req *workflowRequestType) { req.PdfDpi = 1 }, "pdf dpi must be between"},
+		{"negative page number", func(req *workflowRequestType) { req.PageNumber = -1 }, "page number must not be negative"},
+		{"textract fallback with reproducible", func(req *workflowRequestType) { req.TextractFallback, req.Reproducible = true, true }, "textractfallback cannot be combined with reproducible"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := valid
+			tc.modify(&req)
+
+			ocr, err := buildWorkflowOcrConfig(req)
+			if err == nil {
+				t.Fatalf("expected an error, got %+v", ocr)
+			}
+
+			if !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("got error %q, want one containing %q", err.Error(), tc.errMsg)
+			}
+		})
+	}
+
+	if _, err := buildWorkflowOcrConfig(valid); err != nil {
+		t.Errorf("unexpected error for a valid request: %s", err.Error())
+	}
+}
+
+func TestBuildWorkflowOcrConfigResultsPrefix(t *testing.T) {
+	useConfig(t)
+
+	config.settingsSource = ""
+
+	tests := []struct {
+		name       string
+		pid        string
+		parentPid  string
+		scale      string
+		prefix     string
+		wantPrefix string
+		wantBase   string
+	}{
+		{name: "pid", pid: "uva-lib:2", wantPrefix: "results/uva-lib:2"},
+		{name: "pid and scale", pid: "uva-lib:2", scale: "50", wantPrefix: "results/uva-lib:2/50"},
+		{name: "parent pid", pid: "uva-lib:2", parentPid: "uva-lib:1", scale: "50", wantPrefix: "results/uva-lib:1/uva-lib:2/50"},
+		{name: "same parent pid", pid: "uva-lib:2", parentPid: "uva-lib:2", wantPrefix: "results/uva-lib:2"},
+		{name: "result prefix", pid: "uva-lib:2", prefix: "page_0001", wantPrefix: "results/uva-lib:2", wantBase: "page_0001"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ocr, err := buildWorkflowOcrConfig(workflowRequestType{Pid: tc.pid, ParentPid: tc.parentPid, Scale: tc.scale, ResultPrefix: tc.prefix})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if ocr.remoteResultsPrefix != tc.wantPrefix {
+				t.Errorf("got results prefix %q, want %q", ocr.remoteResultsPrefix, tc.wantPrefix)
+			}
+
+			if ocr.resultsBase != tc.wantBase {
+				t.Errorf("got results base %q, want %q", ocr.resultsBase, tc.wantBase)
+			}
+		})
+	}
+}
+
+func TestBuildWorkflowOcrConfigSettings(t *testing.T) {
+	useOperatorSettings(t, `{
+		"defaults": {"lang": "deu", "banner": true},
+		"collections": {
+			"uva-lib:1": {"lang": "fra", "scale": "150"},
+			"uva-lib:12": {"scale": "200", "bannertemplate": "[{date}]"}
+		}
+	}`)
+
+	no := false
+
+	tests := []struct {
+		name      string
+		req       workflowRequestType
+		want      resolvedSettings
+		wantLayer map[string]string
+	}{
+		{
+			name: "global defaults",
+			req:  workflowRequestType{Pid: "uva-lib:2"},
+			want: resolvedSettings{Lang: "deu", Scale: defaultScale, Banner: true, BannerTemplate: defaultBannerTemplate},
+			wantLayer: map[string]string{
+				"lang": settingsLayerGlobal, "scale": settingsLayerCompiled, "banner": settingsLayerGlobal, "bannertemplate": settingsLayerCompiled,
+			},
+		},
+		{
+			name: "collection",
+			req:  workflowRequestType{Pid: "uva-lib:2", ParentPid: "uva-lib:100"},
+			want: resolvedSettings{Lang: "fra", Scale: "150", Banner: true, BannerTemplate: defaultBannerTemplate},
+			wantLayer: map[string]string{
+				"lang": "collection:uva-lib:1", "scale": "collection:uva-lib:1", "banner": settingsLayerGlobal, "bannertemplate": settingsLayerCompiled,
+			},
+		},
+		{
+			name: "longest collection prefix",
+			req:  workflowRequestType{Pid: "uva-lib:2", ParentPid: "uva-lib:1234"},
+			want: resolvedSettings{Lang: "deu", Scale: "200", Banner: true, BannerTemplate: "[{date}]"},
+			wantLayer: map[string]string{
+				"lang": settingsLayerGlobal, "scale": "collection:uva-lib:12", "banner": settingsLayerGlobal, "bannertemplate": "collection:uva-lib:12",
+			},
+		},
+		{
+			name: "request",
+			req:  workflowRequestType{Pid: "uva-lib:2", ParentPid: "uva-lib:100", Lang: "spa", Scale: "75", Banner: &no},
+			want: resolvedSettings{Lang: "spa", Scale: "75", Banner: false, BannerTemplate: defaultBannerTemplate},
+			wantLayer: map[string]string{
+				"lang": settingsLayerRequest, "scale": settingsLayerRequest, "banner": settingsLayerRequest, "bannertemplate": settingsLayerCompiled,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ocr, err := buildWorkflowOcrConfig(tc.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			got := *ocr.settings
+
+			if got.Lang != tc.want.Lang || got.Scale != tc.want.Scale || got.Banner != tc.want.Banner || got.BannerTemplate != tc.want.BannerTemplate {
+				t.Errorf("got settings %+v, want %+v", got, tc.want)
+			}
+
+			if !reflect.DeepEqual(got.Sources, tc.wantLayer) {
+				t.Errorf("got layers %v, want %v", got.Sources, tc.wantLayer)
+			}
+
+			if ocr.languages != tc.want.Lang || ocr.scale != tc.want.Scale {
+				t.Errorf("got languages %q and scale %q, want %q and %q", ocr.languages, ocr.scale, tc.want.Lang, tc.want.Scale)
+			}
+		})
+	}
+}
+
+func TestBuildStandaloneOcrConfig(t *testing.T) {
+	useOperatorSettings(t, `{"defaults": {"lang": "lat"}, "collections": {"": {"lang": "fra"}}}`)
+
+	config.allowedFormats = []string{"hocr", "pdf", "tsv"}
+	config.standaloneFormats = []string{"txt", "hocr", "pdf"}
+	config.originalImagePdf = true
+
+	record := func(key string) s3RecordType {
+		rec := s3RecordType{EventName: "ObjectCreated:Put"}
+		rec.S3.Bucket.Name = "bucket"
+		rec.S3.Object.Key = key
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		wantPrefix string
+		wantErr    bool
+	}{
+		{name: "request", key: "standalone/requests/a/image.tif", wantPrefix: "standalone/results/a/image.tif"},
+		{name: "quarantined", key: "elsewhere/image.tif", wantPrefix: "standalone/quarantine/elsewhere/image.tif"},
+		{name: "dot dot segment", key: "standalone/requests/../image.tif", wantErr: true},
+		{name: "empty segment", key: "standalone/requests//image.tif", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ocr, err := buildStandaloneOcrConfig(lambdaRequestType{s3MessageEventType: s3MessageEventType{Records: []s3RecordType{record(tc.key)}}})
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got results prefix %q", ocr.remoteResultsPrefix)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if ocr.remoteResultsPrefix != tc.wantPrefix {
+				t.Errorf("got results prefix %q, want %q", ocr.remoteResultsPrefix, tc.wantPrefix)
+			}
+
+			// standalone requests have no parent pid, so only the global settings apply
+			if ocr.languages != "lat" || ocr.settings.Sources["lang"] != settingsLayerGlobal {
+				t.Errorf("got languages %q from %q, want the global default", ocr.languages, ocr.settings.Sources["lang"])
+			}
+
+			if !reflect.DeepEqual(ocr.additionalFormats, []string{"hocr", "pdf"}) || !ocr.originalPdf {
+				t.Errorf("got formats %v (original pdf %t)", ocr.additionalFormats, ocr.originalPdf)
+			}
+
+			if ocr.provenance == nil || ocr.provenance.EventName != "ObjectCreated:Put" {
+				t.Errorf("got provenance %+v", ocr.provenance)
+			}
+		})
+	}
+}
+
+// snsLambdaEvent builds an sns-delivered lambda event wrapping each of the given messages
+func snsLambdaEvent(t *testing.T, messages ...string) lambdaRequestType {
+	t.Helper()
+
+	var event snsS3Event
+	for _, msg := range messages {
+		event.Records = append(event.Records, snsRecordType{EventSource: snsEventSource, Sns: snsMessageType{Message: msg}})
+	}
+
+	data, _ := json.Marshal(event)
+
+	var req lambdaRequestType
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	return req
+}
+
+func TestUnwrapSnsS3Event(t *testing.T) {
+	s3Event := func(keys ...string) string {
+		var event s3MessageEventType
+		for _, key := range keys {
+			rec := s3RecordType{EventSource: "aws:s3", EventName: "ObjectCreated:Put"}
+			rec.S3.Bucket.Name = "bucket"
+			rec.S3.Object.Key = key
+			event.Records = append(event.Records, rec)
+		}
+
+		data, _ := json.Marshal(event)
+		return string(data)
+	}
+
+	tests := []struct {
+		name     string
+		messages []string
+		wantKeys []string
+		errMsg   string
+	}{
+		{name: "one record", messages: []string{s3Event("a.tif")}, wantKeys: []string{"a.tif"}},
+		{name: "several records", messages: []string{s3Event("a.tif", "b.tif")}, wantKeys: []string{"a.tif", "b.tif"}},
+		{name: "several messages", messages: []string{s3Event("a.tif"), s3Event("b.tif", "c.tif")}, wantKeys: []string{"a.tif", "b.tif", "c.tif"}},
+		{name: "s3 test event", messages: []string{`{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"bucket"}`}},
+		{name: "invalid message", messages: []string{s3Event("a.tif"), "not json"}, errMsg: "failed to parse s3 event in sns record 1"},
+		{name: "empty message", messages: []string{""}, errMsg: "failed to parse s3 event in sns record 0"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := snsLambdaEvent(t, tc.messages...)
+
+			if !isSnsRequest(req) {
+				t.Fatal("request was not recognized as sns-delivered")
+			}
+
+			records, err := unwrapSnsS3Event(snsEventFromRequest(req))
+
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Fatalf("got error %v, want one containing %q", err, tc.errMsg)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			var keys []string
+			for _, rec := range records {
+				if rec.S3.Bucket.Name != "bucket" || rec.EventName != "ObjectCreated:Put" {
+					t.Errorf("unexpected record: %+v", rec)
+				}
+				keys = append(keys, rec.S3.Object.Key)
+			}
+
+			if !reflect.DeepEqual(keys, tc.wantKeys) {
+				t.Errorf("got keys %v, want %v", keys, tc.wantKeys)
+			}
+		})
+	}
+}
+
+func TestIsSnsRequest(t *testing.T) {
+	direct := lambdaRequestType{s3MessageEventType: s3MessageEventType{Records: []s3RecordType{{EventSource: "aws:s3"}}}}
+
+	if isSnsRequest(direct) || isSnsRequest(lambdaRequestType{}) {
+		t.Error("request not delivered via sns was recognized as sns-delivered")
+	}
+}
+
+// useLanguageDependencies restores the language dependencies once the test is done
+func useLanguageDependencies(t *testing.T) {
+	t.Helper()
+
+	saved := make(map[string]string)
+	for lang, dep := range langDeps {
+		saved[lang] = dep
+	}
+
+	t.Cleanup(func() { langDeps = saved })
+}
+
+func TestLoadLanguageDependencies(t *testing.T) {
+	useLanguageDependencies(t)
+
+	useEnv(t, map[string]string{"LANG_DEPS": ""})
+	loadLanguageDependencies()
+
+	if langDeps["uzb"] != "uzb_cyrl" || len(langDeps) != 4 {
+		t.Errorf("got dependencies %v without LANG_DEPS, want the built-in ones", langDeps)
+	}
+
+	useEnv(t, map[string]string{"LANG_DEPS": `{"srp_latn":"srp","aze":"tur"}`})
+	loadLanguageDependencies()
+
+	want := map[string]string{"aze": "tur", "aze_cyrl": "aze", "uzb": "uzb_cyrl", "uzb_cyrl": "uzb", "srp_latn": "srp"}
+
+	if !reflect.DeepEqual(langDeps, want) {
+		t.Errorf("got dependencies %v, want %v", langDeps, want)
+	}
+}
+
+func TestLoadLanguageDependenciesInvalid(t *testing.T) {
+	// an invalid value stops the lambda at init time, so check it in a new test process
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), `LANG_DEPS={"aze":`)
+
+	out, err := cmd.CombinedOutput()
+
+	if _, ok := err.(*exec.ExitError); !ok || !strings.Contains(string(out), "invalid value for LANG_DEPS") {
+		t.Errorf("got error %v with output %q, want an exit for the invalid value", err, out)
+	}
+}
+
+func TestCheckLanguagesDownloadsDependencies(t *testing.T) {
+	env := useIntegrationEnv(t)
+	useLanguageDependencies(t)
+
+	config.tessdataConcurrency = 2
+	langDeps["fra"] = "deu"
+
+	if err := checkLanguages(nil, "eng+fra", "fast"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	for _, l := range []string{"fra", "deu"} {
+		if data, _ := ioutil.ReadFile(filepath.Join(env.tessdataDir, l+".traineddata")); string(data) != l+" model" {
+			t.Errorf("%s: got language file %q", l, data)
+		}
+	}
+
+	// bundled languages are not downloaded
+	fetched := env.languageRequests()
+	sort.Strings(fetched)
+
+	if want := []string{"/tessdata_fast/raw/4.0.0/deu.traineddata", "/tessdata_fast/raw/4.0.0/fra.traineddata"}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("got language requests %v, want %v", fetched, want)
+	}
+}
+
+func TestRecordProvenance(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  *provenanceType
+	}{
+		{
+			name: "full event",
+			event: `{"eventVersion":"2.1","eventSource":"aws:s3","awsRegion":"us-east-1","eventTime":"2021-03-04T12:00:00.000Z","eventName":"ObjectCreated:Put",
+				"userIdentity":{"principalId":"AWS:AIDAEXAMPLE"},"requestParameters":{"sourceIPAddress":"192.0.2.10"},
+				"responseElements":{"x-amz-request-id":"C3D13FE58DE4C810"},"s3":{"bucket":{"name":"bucket"},"object":{"key":"standalone/requests/a.tif"}}}`,
+			want: &provenanceType{EventName: "ObjectCreated:Put", EventTime: "2021-03-04T12:00:00.000Z", PrincipalID: "AWS:AIDAEXAMPLE", SourceIPAddress: "192.0.2.10"},
+		},
+		{
+			name:  "copied object without requester",
+			event: `{"eventVersion":"2.0","eventTime":"2021-03-04T12:00:00.000Z","eventName":"ObjectCreated:Copy","s3":{"bucket":{"name":"bucket"},"object":{"key":"a.tif"}}}`,
+			want:  &provenanceType{EventName: "ObjectCreated:Copy", EventTime: "2021-03-04T12:00:00.000Z"},
+		},
+		{
+			name:  "no origin",
+			event: `{"s3":{"bucket":{"name":"bucket"},"object":{"key":"a.tif"}}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var rec s3RecordType
+
+			if err := json.Unmarshal([]byte(tc.event), &rec); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := recordProvenance(rec); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunCommandAllowList(t *testing.T) {
+	useConfig(t)
+	useWorkDir(t)
+
+	// each stub leaves a marker when it runs
+	dir := useStubCommands(t, map[string]string{
+		"magick":    `echo "magick $*"; touch ran-magick`,
+		"tesseract": `touch ran-tesseract`,
+		"cat":       `touch ran-cat; while read -r line; do echo "read $line"; done`,
+	})
+
+	config.allowedCommands = []string{"magick", "cat"}
+	config.maxCommandHistory = 10
+	config.maxCommandOutputBytes = 1000
+
+	tests := []struct {
+		name    string
+		command string
+		stdin   string
+		want    string
+		allowed bool
+	}{
+		{name: "allowed", command: "magick", want: "magick -version\n", allowed: true},
+		{name: "allowed by path", command: filepath.Join(dir, "magick"), want: "magick -version\n", allowed: true},
+		{name: "allowed with input", command: "cat", stdin: "text", want: "read text\n", allowed: true},
+		{name: "not allowed", command: "tesseract"},
+		{name: "not allowed by path", command: filepath.Join(dir, "tesseract")},
+		{name: "allowed name in another command's path", command: filepath.Join(dir, "magick", "..", "tesseract")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cmds = &commandHistory{}
+
+			var out string
+			var err error
+
+			if tc.stdin != "" {
+				out, err = runCommandInput(strings.NewReader(tc.stdin+"\n"), tc.command)
+			} else {
+				out, err = runCommand(tc.command, "-version")
+			}
+
+			marker := "ran-" + filepath.Base(tc.command)
+			_, statErr := os.Stat(marker)
+			os.Remove(marker)
+
+			if !tc.allowed {
+				if err == nil || !strings.Contains(err.Error(), "command not allowed") {
+					t.Errorf("got error %v, want the command refused", err)
+				}
+
+				if statErr == nil || len(cmds.Commands) != 0 {
+					t.Error("refused command was run")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if out != tc.want || statErr != nil {
+				t.Errorf("got output %q, want %q", out, tc.want)
+			}
+
+			if len(cmds.Commands) != 1 || cmds.Commands[0].Command != tc.command {
+				t.Errorf("got history %+v, want the command recorded", cmds.Commands)
+			}
+		})
+	}
+}
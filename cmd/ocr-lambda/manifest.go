@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// summary of a request's results, uploaded after all other results so that its
+// presence indicates the request has finished
+type resultsManifest struct {
+	Bucket      string          `json:"bucket"`
+	Source      string          `json:"source"`              // key of the source image
+	SourceURL   string          `json:"sourceurl,omitempty"` // url the source image was fetched from, if not from s3
+	Languages   string          `json:"languages"`
+	Scale       string          `json:"scale"`
+	Engine      string          `json:"engine,omitempty"` // ocr engine used, if not tesseract
+	Tesseract   string          `json:"tesseract,omitempty"`
+	Kraken      string          `json:"kraken,omitempty"` // version, if the request used kraken
+	Magick      string          `json:"magick,omitempty"`
+	Stages      []manifestStage `json:"stages,omitempty"`
+	Files       []manifestFile  `json:"files,omitempty"`
+	DuplicateOf string          `json:"duplicateof,omitempty"` // location of earlier results the files were copied from
+	Quality     *qualityScore   `json:"quality,omitempty"`
+	Textract    bool            `json:"textract,omitempty"` // the text is amazon textract's
+	Status      string          `json:"status"`             // "success", "failure" or "timeout"
+	Error       string          `json:"error,omitempty"`
+}
+
+type manifestStage struct {
+	Name     string `json:"name"`
+	Duration string `json:"duration"`
+}
+
+type manifestFile struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+}
+
+const manifestSuccess = "success"
+const manifestFailure = "failure"
+const manifestTimeout = "timeout"
+
+func manifestFileName(resultsBase string) string {
+	return fmt.Sprintf("%s.json", resultsBase)
+}
+
+// timeStage records how long a processing stage took, given when it started
+func (m *resultsManifest) timeStage(name string, start time.Time) {
+	m.Stages = append(m.Stages, manifestStage{Name: name, Duration: time.Since(start).String()})
+}
+
+// addFiles records uploaded local results files
+func (m *resultsManifest) addFiles(remoteResultsPrefix string, files []string) {
+	for _, f := range files {
+		m.Files = append(m.Files, manifestFile{Key: path.Join(remoteResultsPrefix, f), Bytes: fileSize(f)})
+	}
+}
+
+// finish records the outcome of the request
+func (m *resultsManifest) finish(err error) {
+	m.Status = manifestSuccess
+
+	if err != nil {
+		m.Status = manifestFailure
+		m.Error = err.Error()
+	}
+
+	switch err.(type) {
+	case *workDeadlineError, *stageTimeoutError:
+		m.Status = manifestTimeout
+	}
+}
+
+func uploadManifest(ctx context.Context, bucket, remoteResultsPrefix, resultsBase string, m *resultsManifest) {
+	manifestText, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Printf("WARNING: failed to serialize results manifest: [%s]", err.Error())
+		return
+	}
+
+	manifestFile := manifestFileName(resultsBase)
+
+	if err = ioutil.WriteFile(manifestFile, manifestText, 0644); err != nil {
+		log.Printf("WARNING: failed to save results manifest: [%s]", err.Error())
+		return
+	}
+
+	uploader := s3manager.NewUploaderWithClient(newS3Client(config.resultsS3))
+
+	if err = uploadResult(ctx, uploader, bucket, remoteResultsPrefix, manifestFile, "application/json"); err != nil {
+		log.Printf("WARNING: failed to upload results manifest: [%s]", err.Error())
+	}
+}
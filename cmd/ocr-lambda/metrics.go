@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// measurements of an ocr request, emitted as cloudwatch metrics when it finishes
+type requestMetrics struct {
+	downloadBytes  int64
+	convertSeconds float64
+	ocrSeconds     float64
+	words          int
+	confidence     *float64 // mean word confidence, if any words were recognized
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// printEmf writes a record in cloudwatch embedded metric format; lambda forwards
+// stdout to cloudwatch logs, which extracts the metrics from it. values holds the
+// metric values and any dimension values, by name.
+func printEmf(dimensions []string, metrics []emfMetric, values map[string]interface{}) {
+	record := map[string]interface{}{
+		"_aws": emfMetadata{
+			Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+			CloudWatchMetrics: []emfDirective{
+				{Namespace: config.metricsNamespace, Dimensions: [][]string{dimensions}, Metrics: metrics},
+			},
+		},
+	}
+
+	for name, value := range values {
+		record[name] = value
+	}
+
+	text, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("WARNING: failed to serialize metrics: [%s]", err.Error())
+		return
+	}
+
+	// the record must be a line of its own, without the log package's prefix
+	fmt.Println(string(text))
+}
+
+// emit writes the request's metrics, along with a failure count by stage if it failed
+func (m *requestMetrics) emit(failedStage string) {
+	if config.metricsNamespace == "" {
+		return
+	}
+
+	metrics := []emfMetric{
+		{Name: "DownloadBytes", Unit: "Bytes"},
+		{Name: "ConvertSeconds", Unit: "Seconds"},
+		{Name: "OcrSeconds", Unit: "Seconds"},
+		{Name: "WordCount", Unit: "Count"},
+	}
+
+	values := map[string]interface{}{
+		"DownloadBytes":  m.downloadBytes,
+		"ConvertSeconds": m.convertSeconds,
+		"OcrSeconds":     m.ocrSeconds,
+		"WordCount":      m.words,
+	}
+
+	if m.confidence != nil {
+		metrics = append(metrics, emfMetric{Name: "Confidence", Unit: "None"})
+		values["Confidence"] = *m.confidence
+	}
+
+	printEmf([]string{}, metrics, values)
+
+	if failedStage != "" {
+		printEmf([]string{"Stage"}, []emfMetric{{Name: "Failures", Unit: "Count"}}, map[string]interface{}{"Stage": failedStage, "Failures": 1})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// resolution at which pdf pages are rendered before conversion
+const pdfRenderDpi = 300
+
+// source formats that may hold multiple pages, by canonical extension, and their magick coders
+var multiPageCoders = map[string]string{
+	".tif": "tiff",
+	".pdf": "pdf",
+}
+
+func pageResultsBase(resultsBase string, page int) string {
+	return fmt.Sprintf("%s-page%04d", resultsBase, page)
+}
+
+// pageResultsPattern matches the per-page results files of a multi-page source
+func pageResultsPattern(resultsBase string) string {
+	return fmt.Sprintf("%s-page[0-9]*.*", resultsBase)
+}
+
+func pageTextFiles(resultsBase string) []string {
+	files, _ := filepath.Glob(fmt.Sprintf("%s-page[0-9]*.txt", resultsBase))
+	return files
+}
+
+// countPages returns the number of pages in the source image; pyramidal tiffs hold
+// a single page at several resolutions
+func countPages(localSourceImage string) (int, error) {
+	if _, ok := multiPageCoders[path.Ext(localSourceImage)]; !ok {
+		return 1, nil
+	}
+
+	frames, err := identifyFrames(localSourceImage)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(frames) == 0 || isPyramid(frames) {
+		return 1, nil
+	}
+
+	return len(frames), nil
+}
+
+// ocrPages converts and ocrs each page of a multi-page source separately, keeping the
+// results of each page and concatenating their text into the results for the whole source
+func ocrPages(localSourceImage string, pages int, resultsBase, langStr string, outputFormats []string, tessdataDir string, engine tesseractParams, params convertParams, cleanup bool) error {
+	coder := multiPageCoders[path.Ext(localSourceImage)]
+
+	// pdf pages are rendered at a low resolution unless told otherwise
+	var settings []string
+	if coder == "pdf" {
+		settings = []string{"-density", strconv.Itoa(pdfRenderDpi)}
+	}
+
+	var text []byte
+
+	for page := 1; page <= pages; page++ {
+		log.Printf("converting page %d of %d...", page, pages)
+
+		pageImage := fmt.Sprintf("source-converted-page%04d.tif", page)
+		pageInput := fmt.Sprintf("%s:%s[%d]", coder, localSourceImage, page-1)
+
+		if err := runConvert(pageInput, pageImage, params, settings...); err != nil {
+			return fmt.Errorf("failed to convert page %d: [%s]", page, err.Error())
+		}
+
+		pageBase := pageResultsBase(resultsBase, page)
+
+		if err := ocrImage(pageImage, pageBase, langStr, outputFormats, tessdataDir, engine); err != nil {
+			return err
+		}
+
+		if cleanup {
+			removeIntermediate(pageImage)
+		}
+
+		pageText, err := ioutil.ReadFile(fmt.Sprintf("%s.txt", pageBase))
+		if err != nil {
+			return fmt.Errorf("failed to read ocr results file: [%s]", err.Error())
+		}
+
+		text = append(text, pageText...)
+
+		jobs.progress(page, pages)
+	}
+
+	// other formats are only available per page
+
+	if err := ioutil.WriteFile(fmt.Sprintf("%s.txt", resultsBase), text, 0644); err != nil {
+		return fmt.Errorf("failed to save combined ocr results: [%s]", err.Error())
+	}
+
+	return nil
+}
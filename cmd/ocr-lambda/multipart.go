@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// where local data for interrupted uploads is kept, outside of the per-request work dir.
+// it survives only as long as the container that was interrupted, so resuming is best
+// effort: a resume request handled by any other container fails, and the ocr request
+// must be re-run instead.
+const multipartSpoolDir = "/tmp/ocr-lambda-uploads"
+
+// persisted state of an explicit multipart upload, allowing it to be resumed by a later invocation
+type multipartPartState struct {
+	PartNumber int64  `json:"partnumber"`
+	ETag       string `json:"etag"`
+}
+
+type multipartState struct {
+	Bucket    string               `json:"bucket"`
+	Key       string               `json:"key"`
+	UploadID  string               `json:"uploadid"`
+	LocalFile string               `json:"-"` // the results file, or its spooled copy (see spoolFileName)
+	Size      int64                `json:"size"`
+	PartSize  int64                `json:"partsize"`
+	Parts     []multipartPartState `json:"parts,omitempty"`
+}
+
+type resumeUploadResponseType struct {
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+// returned when an upload was stopped ahead of the lambda deadline, and can be resumed
+type uploadInterruptedError struct {
+	stateKey string
+}
+
+func (e *uploadInterruptedError) Error() string {
+	return fmt.Sprintf("upload_interrupted: resume with resumeupload=[%s]", e.stateKey)
+}
+
+func multipartStateKey(key string) string {
+	return path.Join(config.multipartStatePrefix, fmt.Sprintf("%s.json", key))
+}
+
+// spoolFileName returns where the local data of an interrupted upload is spooled.  it is
+// derived from the upload id, rather than saved with the state, so that a state object
+// cannot direct a resume to upload any other local file.
+func spoolFileName(uploadID string) (string, error) {
+	if uploadID == "" || uploadID == "." || uploadID == ".." || uploadID != filepath.Base(uploadID) {
+		return "", fmt.Errorf("invalid upload id: [%s]", uploadID)
+	}
+
+	return filepath.Join(multipartSpoolDir, uploadID), nil
+}
+
+func deadlineApproaching(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+
+	return ok && time.Until(deadline) < time.Duration(config.uploadDeadlineMarginSecs)*time.Second
+}
+
+func multipartUpload(ctx context.Context, svc *s3.S3, bucket, key, localFile string) error {
+	info, err := os.Stat(localFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat results file: [%s]", err.Error())
+	}
+
+	absFile, err := filepath.Abs(localFile)
+	if err != nil {
+		return fmt.Errorf("failed to resolve results file path: [%s]", err.Error())
+	}
+
+	var out *s3.CreateMultipartUploadOutput
+
+	err = withRetries(cmds, "create multipart upload", uploadRetries(), func() error {
+		var createErr error
+		input := &s3.CreateMultipartUploadInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			StorageClass: aws.String(storageClassFor(key)),
+			RequestPayer: requestPayer(),
+		}
+
+		if config.resultGrantRead != "" {
+			input.GrantRead = aws.String(config.resultGrantRead)
+		}
+
+		out, createErr = svc.CreateMultipartUpload(input)
+		return createErr
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: [%s]", err.Error())
+	}
+
+	state := &multipartState{
+		Bucket:    bucket,
+		Key:       key,
+		UploadID:  aws.StringValue(out.UploadId),
+		LocalFile: absFile,
+		Size:      info.Size(),
+		PartSize:  int64(config.multipartPartSizeMB) * 1024 * 1024,
+	}
+
+	log.Printf("multipart upload: s3://%s/%s  id: [%s]  size: [%d]  part size: [%d]", bucket, key, state.UploadID, state.Size, state.PartSize)
+
+	return uploadParts(ctx, svc, state)
+}
+
+// uploadParts uploads every part not yet recorded in the state and completes the upload.
+// if the lambda deadline approaches first, the state is saved for a later resume instead.
+func uploadParts(ctx context.Context, svc *s3.S3, state *multipartState) error {
+	f, err := os.Open(state.LocalFile)
+	if err != nil {
+		return fmt.Errorf("failed to open results file: [%s]", err.Error())
+	}
+	defer f.Close()
+
+	done := make(map[int64]bool)
+	for _, p := range state.Parts {
+		done[p.PartNumber] = true
+	}
+
+	numParts := (state.Size + state.PartSize - 1) / state.PartSize
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var partErr error
+
+	parts := make(chan int64)
+
+	// the workers record retries into this request's history, not whatever cmds is by then
+	history := cmds
+
+	for i := 0; i < config.multipartConcurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for n := range parts {
+				etag, err := uploadPart(history, svc, state, f, n)
+
+				mu.Lock()
+				if err != nil && partErr == nil {
+					partErr = err
+				}
+				if err == nil {
+					state.Parts = append(state.Parts, multipartPartState{PartNumber: n, ETag: etag})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	interrupted := false
+
+	for n := int64(1); n <= numParts; n++ {
+		if done[n] {
+			continue
+		}
+
+		mu.Lock()
+		failed := partErr != nil
+		mu.Unlock()
+
+		if failed {
+			break
+		}
+
+		if deadlineApproaching(ctx) {
+			interrupted = true
+			break
+		}
+
+		parts <- n
+	}
+
+	close(parts)
+	wg.Wait()
+
+	if partErr != nil {
+		abortMultipartUpload(svc, state)
+		return fmt.Errorf("failed to upload part: [%s]", partErr.Error())
+	}
+
+	if interrupted {
+		log.Printf("lambda deadline approaching; saving state of multipart upload after %d of %d parts", len(state.Parts), numParts)
+		return saveMultipartState(svc, state)
+	}
+
+	return completeMultipartUpload(svc, state)
+}
+
+func uploadPart(history *commandHistory, svc *s3.S3, state *multipartState, f *os.File, partNumber int64) (string, error) {
+	offset := (partNumber - 1) * state.PartSize
+
+	length := state.PartSize
+	if offset+length > state.Size {
+		length = state.Size - offset
+	}
+
+	var out *s3.UploadPartOutput
+
+	err := withRetries(history, fmt.Sprintf("upload part %d", partNumber), uploadRetries(), func() error {
+		var uploadErr error
+		out, uploadErr = svc.UploadPart(&s3.UploadPartInput{
+			Bucket:        aws.String(state.Bucket),
+			Key:           aws.String(state.Key),
+			UploadId:      aws.String(state.UploadID),
+			PartNumber:    aws.Int64(partNumber),
+			ContentLength: aws.Int64(length),
+			Body:          io.NewSectionReader(f, offset, length),
+			RequestPayer:  requestPayer(),
+		})
+		return uploadErr
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(out.ETag), nil
+}
+
+func completeMultipartUpload(svc *s3.S3, state *multipartState) error {
+	sort.Slice(state.Parts, func(i, j int) bool { return state.Parts[i].PartNumber < state.Parts[j].PartNumber })
+
+	var completed []*s3.CompletedPart
+	for _, p := range state.Parts {
+		completed = append(completed, &s3.CompletedPart{PartNumber: aws.Int64(p.PartNumber), ETag: aws.String(p.ETag)})
+	}
+
+	err := withRetries(cmds, "complete multipart upload", uploadRetries(), func() error {
+		_, completeErr := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(state.Bucket),
+			Key:             aws.String(state.Key),
+			UploadId:        aws.String(state.UploadID),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+			RequestPayer:    requestPayer(),
+		})
+		return completeErr
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: [%s]", err.Error())
+	}
+
+	// remove any state and spooled data left over from an earlier interruption
+
+	svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(state.Bucket),
+		Key:    aws.String(multipartStateKey(state.Key)),
+	})
+
+	if filepath.Dir(state.LocalFile) == multipartSpoolDir {
+		os.Remove(state.LocalFile)
+	}
+
+	return nil
+}
+
+func abortMultipartUpload(svc *s3.S3, state *multipartState) {
+	log.Printf("aborting multipart upload: s3://%s/%s  id: [%s]", state.Bucket, state.Key, state.UploadID)
+
+	if _, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	}); err != nil {
+		log.Printf("failed to abort multipart upload: [%s]", err.Error())
+	}
+}
+
+// saveMultipartState moves the local data out of the work dir (which is about to be
+// removed) and persists the upload state to s3, returning an uploadInterruptedError
+func saveMultipartState(svc *s3.S3, state *multipartState) error {
+	if err := os.MkdirAll(multipartSpoolDir, 0755); err != nil {
+		return fmt.Errorf("failed to create upload spool dir: [%s]", err.Error())
+	}
+
+	spoolFile, err := spoolFileName(state.UploadID)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(state.LocalFile, spoolFile); err != nil {
+		return fmt.Errorf("failed to spool results file: [%s]", err.Error())
+	}
+
+	state.LocalFile = spoolFile
+
+	stateText, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to serialize upload state: [%s]", err.Error())
+	}
+
+	stateKey := multipartStateKey(state.Key)
+
+	if _, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(state.Bucket),
+		Key:         aws.String(stateKey),
+		Body:        bytes.NewReader(stateText),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return fmt.Errorf("failed to save upload state: [%s]", err.Error())
+	}
+
+	return &uploadInterruptedError{stateKey: stateKey}
+}
+
+func loadMultipartState(svc *s3.S3, bucket, stateKey string) (*multipartState, error) {
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(stateKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download upload state: [%s]", err.Error())
+	}
+	defer obj.Body.Close()
+
+	stateText, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload state: [%s]", err.Error())
+	}
+
+	var state multipartState
+
+	if err = json.Unmarshal(stateText, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse upload state: [%s]", err.Error())
+	}
+
+	return &state, nil
+}
+
+// abortAbandonedUploads aborts multipart uploads under the prefix that can no longer
+// be resumed: those without saved state that are older than any lambda run, and
+// any older than the configured abandonment age.  spooled data of aborted uploads,
+// and any older than the abandonment age (whatever its upload), is removed.
+func abortAbandonedUploads(svc *s3.S3, bucket, prefix string) {
+	sweepUploadSpool()
+
+	out, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	if err != nil {
+		log.Printf("failed to list multipart uploads: [%s]", err.Error())
+		return
+	}
+
+	for _, u := range out.Uploads {
+		age := time.Since(aws.TimeValue(u.Initiated))
+
+		state := &multipartState{Bucket: bucket, Key: aws.StringValue(u.Key), UploadID: aws.StringValue(u.UploadId)}
+
+		if age < maxLambdaRuntime {
+			continue
+		}
+
+		if age < time.Duration(config.multipartAbandonHours)*time.Hour {
+			if saved, loadErr := loadMultipartState(svc, bucket, multipartStateKey(state.Key)); loadErr == nil && saved.UploadID == state.UploadID {
+				continue
+			}
+		}
+
+		abortMultipartUpload(svc, state)
+
+		svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(multipartStateKey(state.Key)),
+		})
+
+		if spoolFile, spoolErr := spoolFileName(state.UploadID); spoolErr == nil {
+			os.Remove(spoolFile)
+		}
+	}
+}
+
+// sweepUploadSpool removes spooled data older than the abandonment age, whose uploads
+// have been (or are about to be) aborted
+func sweepUploadSpool() {
+	files, err := ioutil.ReadDir(multipartSpoolDir)
+	if err != nil {
+		return
+	}
+
+	maxAge := time.Duration(config.multipartAbandonHours) * time.Hour
+
+	for _, f := range files {
+		if time.Since(f.ModTime()) < maxAge {
+			continue
+		}
+
+		log.Printf("removing abandoned upload data: %s", f.Name())
+
+		if err = os.Remove(filepath.Join(multipartSpoolDir, f.Name())); err != nil {
+			log.Printf("WARNING: failed to remove abandoned upload data: [%s]", err.Error())
+		}
+	}
+}
+
+func handleResumeUploadRequest(ctx context.Context, req lambdaRequestType) (string, error) {
+	log.Printf("handling resume upload request: [%s]", req.ResumeUpload)
+
+	svc := newS3Client(config.resultsS3)
+
+	state, err := loadMultipartState(svc, req.Bucket, req.ResumeUpload)
+	if err != nil {
+		return "", err
+	}
+
+	// the state must describe an upload of results in the request bucket
+
+	if state.Bucket != req.Bucket || multipartStateKey(state.Key) != req.ResumeUpload {
+		return "", fmt.Errorf("upload state does not match its location: [s3://%s/%s]", req.Bucket, req.ResumeUpload)
+	}
+
+	if state.LocalFile, err = spoolFileName(state.UploadID); err != nil {
+		return "", err
+	}
+
+	// the data is only available if this is the container that was interrupted
+
+	info, err := os.Stat(state.LocalFile)
+	if err != nil {
+		return "", fmt.Errorf("cannot resume upload: local data is not available in this environment; the ocr request must be re-run (%s)", err.Error())
+	}
+
+	if info.Size() != state.Size {
+		return "", fmt.Errorf("cannot resume upload: local data is %d bytes, but the upload is of %d; the ocr request must be re-run", info.Size(), state.Size)
+	}
+
+	// s3 is the source of truth for which parts were completed
+
+	listed, err := svc.ListParts(&s3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list uploaded parts: [%s]", err.Error())
+	}
+
+	state.Parts = nil
+	for _, p := range listed.Parts {
+		state.Parts = append(state.Parts, multipartPartState{PartNumber: aws.Int64Value(p.PartNumber), ETag: aws.StringValue(p.ETag)})
+	}
+
+	if err = uploadParts(ctx, svc, state); err != nil {
+		return "", err
+	}
+
+	output, err := json.Marshal(resumeUploadResponseType{Bucket: state.Bucket, Key: state.Key})
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize output: [%s]", err.Error())
+	}
+
+	return string(output), nil
+}
+
+func validateResumeUploadRequest(req lambdaRequestType) error {
+	if req.Bucket == "" {
+		return errors.New("resume upload request is missing bucket")
+	}
+
+	return nil
+}
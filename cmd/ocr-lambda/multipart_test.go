@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// useMultipartConfig sets up small (1 MB) multipart uploads in a fresh working directory
+func useMultipartConfig(t *testing.T) *fakeS3 {
+	t.Helper()
+
+	useConfig(t)
+
+	f := useFakeS3(t)
+
+	config.multipartThresholdMB = 1
+	config.multipartPartSizeMB = 1
+	config.multipartConcurrency = 1
+	config.multipartAbandonHours = 24
+	config.multipartStatePrefix = "internal/uploads"
+	config.uploadDeadlineMarginSecs = 1
+	config.uploadMaxRetries = 0
+	config.checksumAlgorithm = ""
+	config.resultGrantRead = ""
+
+	useWorkDir(t)
+
+	return f
+}
+
+// writeResultsFile writes a results file of the given size, with contents that differ between parts
+func writeResultsFile(t *testing.T, name string, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i / 1000)
+	}
+
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// removeSpoolFile removes the spooled data of an upload once the test is done
+func removeSpoolFile(t *testing.T, uploadID string) string {
+	t.Helper()
+
+	spoolFile, err := spoolFileName(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.Remove(spoolFile) })
+
+	return spoolFile
+}
+
+func TestUploadResultThreshold(t *testing.T) {
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name      string
+		size      int
+		multipart bool
+	}{
+		{"small", 1000, false},
+		{"at threshold", mb, false},
+		{"over threshold", mb + 1, true},
+		{"several parts", 2*mb + mb/2, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := useMultipartConfig(t)
+
+			data := writeResultsFile(t, "results.pdf", tc.size)
+
+			uploader := s3manager.NewUploaderWithClient(newS3Client(config.resultsS3))
+
+			if err := uploadResult(context.Background(), uploader, "bucket", "results/x", "results.pdf", ""); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if created := len(f.requestsFor(http.MethodPost, "uploads")); (created == 1) != tc.multipart {
+				t.Errorf("got %d multipart uploads, want multipart %t", created, tc.multipart)
+			}
+
+			obj := f.object("bucket", "results/x/results.pdf")
+			if obj == nil || !bytes.Equal(obj.data, data) {
+				t.Fatal("uploaded object differs from the results file")
+			}
+
+			if tc.multipart {
+				wantParts := (tc.size + mb - 1) / mb
+
+				if parts := len(f.requestsFor(http.MethodPut, "partNumber")); parts != wantParts {
+					t.Errorf("got %d parts, want %d", parts, wantParts)
+				}
+			}
+		})
+	}
+}
+
+func TestMultipartUploadInterruptedAndResumed(t *testing.T) {
+	f := useMultipartConfig(t)
+
+	data := writeResultsFile(t, "results.pdf", 5*1024*1024)
+
+	// parts take long enough that the deadline approaches part way through
+	f.fail = func(r *http.Request) int {
+		if r.URL.Query().Get("partNumber") != "" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1450*time.Millisecond)
+	defer cancel()
+
+	err := multipartUpload(ctx, newS3Client(config.resultsS3), "bucket", "results/x/results.pdf", "results.pdf")
+
+	interrupted, ok := err.(*uploadInterruptedError)
+	if !ok {
+		t.Fatalf("got error %v, want an interruption", err)
+	}
+
+	stateKey := "internal/uploads/results/x/results.pdf.json"
+
+	if interrupted.stateKey != stateKey {
+		t.Errorf("got state key %q, want %q", interrupted.stateKey, stateKey)
+	}
+
+	// the state is persisted, and the data spooled outside of the work dir
+
+	stateObj := f.object("bucket", stateKey)
+	if stateObj == nil {
+		t.Fatal("upload state was not saved")
+	}
+
+	var saved multipartState
+
+	if err = json.Unmarshal(stateObj.data, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	spoolFile := removeSpoolFile(t, saved.UploadID)
+
+	if len(saved.Parts) == 0 || len(saved.Parts) >= 5 {
+		t.Fatalf("got %d parts uploaded before the interruption, want some but not all", len(saved.Parts))
+	}
+
+	if saved.Size != int64(len(data)) || strings.Contains(string(stateObj.data), spoolFile) {
+		t.Errorf("unexpected saved state: %s", stateObj.data)
+	}
+
+	if spooled, _ := ioutil.ReadFile(spoolFile); !bytes.Equal(spooled, data) {
+		t.Error("results file was not spooled")
+	}
+
+	if f.object("bucket", "results/x/results.pdf") != nil {
+		t.Fatal("interrupted upload was completed")
+	}
+
+	partsBefore := len(f.requestsFor(http.MethodPut, "partNumber"))
+
+	// resuming uploads only the remaining parts
+
+	f.mu.Lock()
+	f.fail = nil
+	f.mu.Unlock()
+
+	req := lambdaRequestType{workflowRequestType: workflowRequestType{Bucket: "bucket", ResumeUpload: stateKey}}
+
+	output, err := handleResumeUploadRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error resuming: %s", err.Error())
+	}
+
+	if want := `{"bucket":"bucket","key":"results/x/results.pdf"}`; output != want {
+		t.Errorf("got output %s, want %s", output, want)
+	}
+
+	obj := f.object("bucket", "results/x/results.pdf")
+	if obj == nil || !bytes.Equal(obj.data, data) {
+		t.Fatal("resumed upload differs from the results file")
+	}
+
+	if resumed := len(f.requestsFor(http.MethodPut, "partNumber")) - partsBefore; resumed != 5-len(saved.Parts) {
+		t.Errorf("resumed with %d parts, want %d", resumed, 5-len(saved.Parts))
+	}
+
+	if f.object("bucket", stateKey) != nil {
+		t.Error("upload state was not removed")
+	}
+
+	if _, err = os.Stat(spoolFile); !os.IsNotExist(err) {
+		t.Error("spooled data was not removed")
+	}
+}
+
+func TestResumeUploadRejectsUntrustedState(t *testing.T) {
+	f := useMultipartConfig(t)
+
+	const stateKey = "internal/uploads/results/x/results.pdf.json"
+
+	// a file the state claims holds the data
+	writeResultsFile(t, "other.pdf", 1000)
+	otherFile, _ := filepath.Abs("other.pdf")
+
+	tests := []struct {
+		name   string
+		state  string
+		errMsg string
+	}{
+		{
+			name:   "local file",
+			state:  `{"bucket":"bucket","key":"results/x/results.pdf","uploadid":"upload-untrusted","localfile":"` + otherFile + `","size":1000,"partsize":1048576}`,
+			errMsg: "local data is not available",
+		},
+		{
+			name:   "other bucket",
+			state:  `{"bucket":"elsewhere","key":"results/x/results.pdf","uploadid":"upload-1","size":1000,"partsize":1048576}`,
+			errMsg: "does not match its location",
+		},
+		{
+			name:   "other key",
+			state:  `{"bucket":"bucket","key":"results/y/results.pdf","uploadid":"upload-1","size":1000,"partsize":1048576}`,
+			errMsg: "does not match its location",
+		},
+		{
+			name:   "upload id path",
+			state:  `{"bucket":"bucket","key":"results/x/results.pdf","uploadid":"../../` + strings.TrimPrefix(otherFile, "/") + `","size":1000,"partsize":1048576}`,
+			errMsg: "invalid upload id",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f.put("bucket", stateKey, []byte(tc.state))
+
+			req := lambdaRequestType{workflowRequestType: workflowRequestType{Bucket: "bucket", ResumeUpload: stateKey}}
+
+			_, err := handleResumeUploadRequest(context.Background(), req)
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Fatalf("got error %v, want one containing %q", err, tc.errMsg)
+			}
+		})
+	}
+
+	if len(f.requestsFor(http.MethodPut, "partNumber")) != 0 {
+		t.Error("parts were uploaded from a file named by the state")
+	}
+}
+
+func TestAbortAbandonedUploadsSweepsSpool(t *testing.T) {
+	f := useMultipartConfig(t)
+
+	old := time.Now().Add(-48 * time.Hour)
+
+	f.mu.Lock()
+	f.uploads["upload-abandoned"] = &fakeUpload{bucket: "bucket", key: "results/x/results.pdf", parts: map[int][]byte{}, initiated: old}
+	f.uploads["upload-current"] = &fakeUpload{bucket: "bucket", key: "results/x/results.hocr", parts: map[int][]byte{}, initiated: time.Now()}
+	f.mu.Unlock()
+
+	if err := os.MkdirAll(multipartSpoolDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	spoolFiles := make(map[string]string)
+
+	for _, id := range []string{"upload-abandoned", "upload-current", "upload-stale", "upload-fresh"} {
+		spoolFiles[id] = removeSpoolFile(t, id)
+
+		if err := ioutil.WriteFile(spoolFiles[id], []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// data of an upload of other results, spooled long ago
+	if err := os.Chtimes(spoolFiles["upload-stale"], old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	abortAbandonedUploads(newS3Client(config.resultsS3), "bucket", "results/x")
+
+	for id, wantKept := range map[string]bool{"upload-abandoned": false, "upload-current": true, "upload-stale": false, "upload-fresh": true} {
+		if _, err := os.Stat(spoolFiles[id]); (err == nil) != wantKept {
+			t.Errorf("%s: got spooled data kept %t, want %t", id, err == nil, wantKept)
+		}
+	}
+
+	aborted := f.requestsFor(http.MethodDelete, "uploadId")
+
+	if len(aborted) != 1 || aborted[0].query.Get("uploadId") != "upload-abandoned" {
+		t.Errorf("got aborted uploads %+v, want only the abandoned one", aborted)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// published when an ocr request finishes, so that the workflow need not poll s3
+type completionNotification struct {
+	Pid          string   `json:"pid,omitempty"` // workflow requests only
+	Bucket       string   `json:"bucket"`
+	ResultPrefix string   `json:"resultprefix"`
+	ResultsBase  string   `json:"resultsbase"`
+	Status       string   `json:"status"` // as in the results manifest
+	Error        string   `json:"error,omitempty"`
+	Confidence   *float64 `json:"confidence,omitempty"`
+}
+
+// notifyCompletion publishes the outcome of a request to the configured sns topic, if any.
+// the status is also sent as a message attribute, so subscribers can filter on it.
+func notifyCompletion(ctx context.Context, n completionNotification) {
+	if config.notifyTopicArn == "" {
+		return
+	}
+
+	message, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("WARNING: failed to serialize completion notification: [%s]", err.Error())
+		return
+	}
+
+	if _, err = sns.New(sess).PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn: aws.String(config.notifyTopicArn),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"status": {DataType: aws.String("String"), StringValue: aws.String(n.Status)},
+		},
+	}); err != nil {
+		log.Printf("WARNING: failed to publish completion notification: [%s]", err.Error())
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// working file names for the searchable pdf of the original image
+const (
+	originalPdfImage = "source-original.jpg"
+	originalPdfBase  = "source-original"
+)
+
+// saveOriginalImagePdf replaces the searchable pdf, whose page image is the scaled grayscale
+// image that was ocr'd, with one whose page image is the full resolution source image. the
+// source is ocr'd again for the text layer, so that the text lines up with the page image.
+// the source is re-encoded as a jpeg, which tesseract embeds as is, rotated upright first if
+// the converted image was.
+func saveOriginalImagePdf(sourceInput, resultsBase, langStr, tessdataDir string, engine tesseractParams, rotated int) error {
+	log.Print("creating searchable pdf from original image...")
+
+	args := []string{"convert", "-units", "PixelsPerInch", sourceInput}
+	if rotated != 0 {
+		args = append(args, "-rotate", strconv.Itoa(rotated), "+repage")
+	}
+	args = append(args, "-quality", strconv.Itoa(config.originalPdfQuality), originalPdfImage)
+
+	if out, err := runCommand("magick", args...); err != nil {
+		return fmt.Errorf("failed to prepare original image for pdf: [%s] (%s)", err.Error(), strings.TrimSpace(out))
+	}
+	defer os.Remove(originalPdfImage)
+
+	tessArgs := []string{originalPdfImage, originalPdfBase, "--psm", strconv.Itoa(engine.psm), "--oem", strconv.Itoa(engine.oem), "-l", langStr, "pdf"}
+
+	if out, err := runCommandEnv(tessdataEnv(tessdataDir), "tesseract", tessArgs...); err != nil {
+		return fmt.Errorf("failed to ocr original image for pdf: [%s] (%s)", err.Error(), out)
+	}
+
+	if err := os.Rename(fmt.Sprintf("%s.pdf", originalPdfBase), fmt.Sprintf("%s.pdf", resultsBase)); err != nil {
+		return fmt.Errorf("failed to save original image pdf: [%s]", err.Error())
+	}
+
+	return nil
+}
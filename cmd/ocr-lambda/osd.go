@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// tesseract's orientation and script detection results for an image
+type osdResult struct {
+	rotate                int     // degrees to rotate the image clockwise to make it upright
+	orientationConfidence float64 // confidence in the rotation
+	script                string  // e.g. "Latin", "Cyrillic", "Han"
+	scriptConfidence      float64 // confidence in the script
+}
+
+// page segmentation mode for orientation and script detection only
+const osdPsm = "0"
+
+// orientation confidence below which a detected rotation is not trusted; osd is
+// easily fooled by images with little text, and rotating an upright page ruins it
+const minRotateConfidence = 2.0
+
+// detectOrientation runs tesseract's orientation and script detection on an image;
+// it fails on images with too little text to detect either
+func detectOrientation(image, tessdataDir string) (*osdResult, error) {
+	log.Print("detecting orientation and script...")
+
+	out, err := runCommandEnv(tessdataEnv(tessdataDir), "tesseract", image, "stdout", "--psm", osdPsm, "-l", "osd")
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect orientation and script: [%s] (%s)", err.Error(), strings.TrimSpace(out))
+	}
+
+	osd, err := parseOsd(out)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("detected rotation %d (confidence %.2f), script %s (confidence %.2f)", osd.rotate, osd.orientationConfidence, osd.script, osd.scriptConfidence)
+
+	return osd, nil
+}
+
+// parseOsd reads tesseract's osd report, e.g.:
+//
+//	Page number: 0
+//	Orientation in degrees: 270
+//	Rotate: 90
+//	Orientation confidence: 2.08
+//	Script: Latin
+//	Script confidence: 1.69
+func parseOsd(out string) (*osdResult, error) {
+	osd := &osdResult{}
+	found := make(map[string]bool)
+
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		var err error
+
+		switch name {
+		case "Rotate":
+			osd.rotate, err = strconv.Atoi(value)
+		case "Orientation confidence":
+			osd.orientationConfidence, err = strconv.ParseFloat(value, 64)
+		case "Script":
+			osd.script = value
+		case "Script confidence":
+			osd.scriptConfidence, err = strconv.ParseFloat(value, 64)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse osd results: [%s] (%s)", err.Error(), line)
+		}
+
+		found[name] = true
+	}
+
+	if !found["Rotate"] || !found["Script"] {
+		return nil, fmt.Errorf("failed to parse osd results: [%s]", strings.TrimSpace(out))
+	}
+
+	return osd, nil
+}
+
+// rotateImage rotates an image in place by a multiple of 90 degrees clockwise
+func rotateImage(image string, degrees int) error {
+	log.Printf("rotating image %d degrees...", degrees)
+
+	args := append([]string{image}, tiffCompressions[config.intermediateCompression]...)
+	args = append(args, "-rotate", strconv.Itoa(degrees), "+repage", image)
+
+	if out, err := runCommand("magick", args...); err != nil {
+		return fmt.Errorf("failed to rotate image: [%s] (%s)", err.Error(), strings.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// rotateUpright rotates an image as osd suggests, if it is confident enough,
+// returning the rotation applied
+func rotateUpright(image string, osd *osdResult) (int, error) {
+	if osd.rotate%360 == 0 {
+		return 0, nil
+	}
+
+	if osd.orientationConfidence < minRotateConfidence {
+		log.Printf("not rotating image: orientation confidence %.2f is below %.2f", osd.orientationConfidence, minRotateConfidence)
+		return 0, nil
+	}
+
+	if err := rotateImage(image, osd.rotate); err != nil {
+		return 0, err
+	}
+
+	return osd.rotate, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+)
+
+// scaleWithinPixels returns the largest whole percentage at which an image of the given
+// dimensions produces at most maxPixels pixels
+func scaleWithinPixels(width, height int, maxPixels int64) int {
+	pct := int(math.Floor(math.Sqrt(float64(maxPixels)/(float64(width)*float64(height))) * 100))
+
+	// rounding up partial pixels may still overshoot slightly
+	for pct > 0 && estimateConvertedSize(width, height, float64(pct)) > maxPixels {
+		pct--
+	}
+
+	return pct
+}
+
+// capOutputPixels returns the scale at which to convert a source image of the given
+// dimensions so that the converted image has no more than the configured number of
+// pixels: the requested scale if that is within the limit, or a reduced one
+func capOutputPixels(width, height int, scale string) (string, error) {
+	if config.maxOutputMegapixels == 0 {
+		return scale, nil
+	}
+
+	pct, err := strconv.ParseFloat(scale, 64)
+	if err != nil || pct <= 0 {
+		return scale, nil
+	}
+
+	maxPixels := int64(config.maxOutputMegapixels) * 1000000
+
+	if estimateConvertedSize(width, height, pct) <= maxPixels {
+		return scale, nil
+	}
+
+	reduced := scaleWithinPixels(width, height, maxPixels)
+	if reduced < 1 {
+		return "", fmt.Errorf("source image is too large: [%dx%d] (maximum output %d megapixels)", width, height, config.maxOutputMegapixels)
+	}
+
+	newScale := strconv.Itoa(reduced)
+
+	log.Printf("converted %dx%d image at %s%% would exceed %d megapixels; reducing scale to %s%%", width, height, scale, config.maxOutputMegapixels, newScale)
+
+	return newScale, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var hocrBodyRegex = regexp.MustCompile(`<body[^>]*>`)
+var pageMarkerRegex = regexp.MustCompile(`^<!-- PAGE \d+ -->\n`)
+
+func validatePageNumber(page int) error {
+	if page < 0 {
+		return fmt.Errorf("page number must not be negative: [%d]", page)
+	}
+
+	return nil
+}
+
+func pageMarker(page int) string {
+	return fmt.Sprintf("<!-- PAGE %d -->\n", page)
+}
+
+// stripPageMarker removes the page number comment from the top of results text, if present
+func stripPageMarker(text string) string {
+	return pageMarkerRegex.ReplaceAllString(text, "")
+}
+
+// injectPageNumber records the page number within the parent work at the top of the
+// text and hocr results
+func injectPageNumber(resultsBase string, page int) error {
+	txtFile := fmt.Sprintf("%s.txt", resultsBase)
+
+	text, err := ioutil.ReadFile(txtFile)
+	if err != nil {
+		return fmt.Errorf("failed to read ocr results file: [%s]", err.Error())
+	}
+
+	if err = ioutil.WriteFile(txtFile, append([]byte(pageMarker(page)), text...), 0644); err != nil {
+		return fmt.Errorf("failed to save page number: [%s]", err.Error())
+	}
+
+	hocrFile := fmt.Sprintf("%s.hocr", resultsBase)
+
+	hocr, err := ioutil.ReadFile(hocrFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read hocr results file: [%s]", err.Error())
+	}
+
+	body := hocrBodyRegex.FindIndex(hocr)
+	if body == nil {
+		return fmt.Errorf("failed to save page number: no body in hocr file [%s]", hocrFile)
+	}
+
+	var b strings.Builder
+	b.Write(hocr[:body[1]])
+	fmt.Fprintf(&b, "\n  <div class=\"page-number\">%d</div>", page)
+	b.Write(hocr[body[1]:])
+
+	if err = ioutil.WriteFile(hocrFile, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to save page number: [%s]", err.Error())
+	}
+
+	return nil
+}
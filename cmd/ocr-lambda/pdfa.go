@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// working file names for pdf/a conversion
+const (
+	pdfaDefinitionFile = "pdfa-def.ps"
+	pdfaOutputFile     = "pdfa-output.pdf"
+)
+
+// output intent and document info for ghostscript's pdf/a conversion, after the
+// PDFA_def.ps example that ships with it; the output intent profile is required
+// for pdf/a, and the title and creation date are wanted by our preservation system
+const pdfaDefinitionTemplate = `%%!
+/ICCProfile (%s) def
+
+[ /Title (%s) /CreationDate (%s) /DOCINFO pdfmark
+
+[/_objdef {icc_PDFA} /type /stream /OBJ pdfmark
+[{icc_PDFA} << /N 3 >> /PUT pdfmark
+[{icc_PDFA} ICCProfile (r) file /PUT pdfmark
+
+[/_objdef {OutputIntent_PDFA} /type /dict /OBJ pdfmark
+[{OutputIntent_PDFA} <<
+  /Type /OutputIntent
+  /S /GTS_PDFA1
+  /DestOutputProfile {icc_PDFA}
+  /OutputConditionIdentifier (sRGB)
+>> /PUT pdfmark
+[{Catalog} << /OutputIntents [ {OutputIntent_PDFA} ] >> /PUT pdfmark
+`
+
+// escapePostScriptString escapes text for use within a postscript (string)
+func escapePostScriptString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\n", " ", "\r", " ").Replace(s)
+}
+
+// pdfaICCProfile returns the location of the srgb output intent profile in the lambda payload
+func pdfaICCProfile() string {
+	return fmt.Sprintf("%s/share/iccprofiles/srgb.icc", home)
+}
+
+// pdfResultsFiles returns the pdf results of each page that was ocr'd
+func pdfResultsFiles(resultsBase string) []string {
+	candidates := []string{fmt.Sprintf("%s.pdf", resultsBase)}
+
+	for _, base := range spreadResultsBases(resultsBase) {
+		candidates = append(candidates, fmt.Sprintf("%s.pdf", base))
+	}
+
+	for _, textFile := range pageTextFiles(resultsBase) {
+		candidates = append(candidates, strings.TrimSuffix(textFile, ".txt")+".pdf")
+	}
+
+	var files []string
+
+	for _, f := range candidates {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+
+	return files
+}
+
+// convertToPdfa rewrites a tesseract pdf as pdf/a-2b with ghostscript, embedding all fonts
+func convertToPdfa(pdfFile, title string, created time.Time) error {
+	log.Printf("converting %s to pdf/a...", pdfFile)
+
+	def := fmt.Sprintf(pdfaDefinitionTemplate, escapePostScriptString(pdfaICCProfile()), escapePostScriptString(title), created.UTC().Format("D:20060102150405Z"))
+
+	if err := ioutil.WriteFile(pdfaDefinitionFile, []byte(def), 0644); err != nil {
+		return fmt.Errorf("failed to save pdf/a definition: [%s]", err.Error())
+	}
+	defer os.Remove(pdfaDefinitionFile)
+
+	args := []string{"-dPDFA=2", "-dPDFACompatibilityPolicy=1", "-dBATCH", "-dNOPAUSE", "-dNOOUTERSAVE", "-dQUIET",
+		"-dEmbedAllFonts=true", "-sColorConversionStrategy=RGB", "-sProcessColorModel=DeviceRGB", "-sDEVICE=pdfwrite",
+		"--permit-file-read=" + pdfaICCProfile(), "-o", pdfaOutputFile, pdfaDefinitionFile, pdfFile}
+
+	if out, err := runCommand("gs", args...); err != nil {
+		os.Remove(pdfaOutputFile)
+		return fmt.Errorf("failed to convert pdf to pdf/a: [%s] (%s)", err.Error(), strings.TrimSpace(out))
+	}
+
+	if err := os.Rename(pdfaOutputFile, pdfFile); err != nil {
+		return fmt.Errorf("failed to save pdf/a: [%s]", err.Error())
+	}
+
+	return nil
+}
+
+// convertResultsToPdfa converts each pdf result to pdf/a, titled with the pid
+func convertResultsToPdfa(resultsBase, title string) error {
+	pdfFiles := pdfResultsFiles(resultsBase)
+	if len(pdfFiles) == 0 {
+		return fmt.Errorf("failed to convert pdf to pdf/a: no pdf results found")
+	}
+
+	created := time.Now()
+
+	for _, pdfFile := range pdfFiles {
+		if err := convertToPdfa(pdfFile, title, created); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// working file name for pdf downsampling
+const pdfDownsampleOutputFile = "pdf-downsampled.pdf"
+
+// downsamplePdfResults reduces the page images of each pdf result to at most the given
+// resolution.  the text layer is kept as tesseract placed it, and recognition (of the
+// converted image, at full resolution) is unaffected.
+func downsamplePdfResults(resultsBase string, dpi int) error {
+	for _, pdfFile := range pdfResultsFiles(resultsBase) {
+		if err := downsamplePdf(pdfFile, dpi); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// downsamplePdf rewrites a pdf with ghostscript, downsampling any image above the given
+// resolution to it; images at or below it are left as they are
+func downsamplePdf(pdfFile string, dpi int) error {
+	log.Printf("downsampling %s to %d dpi...", pdfFile, dpi)
+
+	res := strconv.Itoa(dpi)
+
+	args := []string{"-dBATCH", "-dNOPAUSE", "-dQUIET", "-sDEVICE=pdfwrite"}
+
+	for _, kind := range []string{"Color", "Gray", "Mono"} {
+		args = append(args, fmt.Sprintf("-dDownsample%sImages=true", kind), fmt.Sprintf("-d%sImageResolution=%s", kind, res),
+			fmt.Sprintf("-d%sImageDownsampleThreshold=1.0", kind))
+	}
+
+	args = append(args, "-o", pdfDownsampleOutputFile, pdfFile)
+
+	if out, err := runCommand("gs", args...); err != nil {
+		os.Remove(pdfDownsampleOutputFile)
+		return fmt.Errorf("failed to downsample pdf: [%s] (%s)", err.Error(), strings.TrimSpace(out))
+	}
+
+	if err := os.Rename(pdfDownsampleOutputFile, pdfFile); err != nil {
+		return fmt.Errorf("failed to save downsampled pdf: [%s]", err.Error())
+	}
+
+	return nil
+}
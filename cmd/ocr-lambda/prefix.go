@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// kinds of request with their own results layout
+const resultsKindWorkflow = "workflow"
+const resultsKindStandalone = "standalone"
+
+// where standalone requests are expected, and where their results go
+const standaloneRequestsPrefix = "standalone/requests/"
+const standaloneResultsPrefix = "standalone/results"
+
+// results of standalone requests outside of the expected prefix are set aside here
+const standaloneQuarantinePrefix = "standalone/quarantine"
+
+var pidRegex = regexp.MustCompile(`^[A-Za-z0-9:._-]+$`)
+var scaleRegex = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// inputs to the results prefix; which are used depends on the kind of request
+type resultsPrefixParams struct {
+	pid       string
+	parentPid string
+	scale     string
+	key       string
+}
+
+func validatePid(name, pid string) error {
+	if !pidRegex.MatchString(pid) || pid == "." || pid == ".." {
+		return fmt.Errorf("invalid %s: [%s]", name, pid)
+	}
+
+	return nil
+}
+
+// validateKeySegments rejects keys with empty, "." or ".." segments
+func validateKeySegments(key string) error {
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid key: [%s]", key)
+		}
+	}
+
+	return nil
+}
+
+// buildResultsPrefix determines where in s3 the results of a request are stored, returning
+// the prefix and a description of how it was derived, for logging:
+//
+//	workflow:   results/<pid>/<scale>, or results/<parentpid>/<pid>/<scale> when the pids differ
+//	            (the scale segment is omitted if empty)
+//	standalone: standalone/results/<key without standalone/requests/>, or
+//	            standalone/quarantine/<key> if the key is not under standalone/requests/
+func buildResultsPrefix(kind string, params resultsPrefixParams) (string, string, error) {
+	switch kind {
+	case resultsKindWorkflow:
+		if err := validatePid("pid", params.pid); err != nil {
+			return "", "", err
+		}
+
+		subDir := params.pid
+
+		if params.parentPid != params.pid && params.parentPid != "" {
+			if err := validatePid("parent pid", params.parentPid); err != nil {
+				return "", "", err
+			}
+
+			subDir = path.Join(params.parentPid, params.pid)
+		}
+
+		if params.scale != "" && !scaleRegex.MatchString(params.scale) {
+			return "", "", fmt.Errorf("invalid scale: [%s]", params.scale)
+		}
+
+		prefix := path.Join("results", subDir, params.scale)
+
+		return prefix, fmt.Sprintf("workflow: parentpid [%s] pid [%s] scale [%s] => [%s]", params.parentPid, params.pid, params.scale, prefix), nil
+
+	case resultsKindStandalone:
+		if err := validateKeySegments(params.key); err != nil {
+			return "", "", err
+		}
+
+		if !strings.HasPrefix(params.key, standaloneRequestsPrefix) {
+			prefix := path.Join(standaloneQuarantinePrefix, params.key)
+			log.Printf("WARNING: standalone key is not under %s; results will be quarantined", standaloneRequestsPrefix)
+
+			return prefix, fmt.Sprintf("standalone (quarantined): key [%s] => [%s]", params.key, prefix), nil
+		}
+
+		prefix := path.Join(standaloneResultsPrefix, strings.TrimPrefix(params.key, standaloneRequestsPrefix))
+
+		return prefix, fmt.Sprintf("standalone: key [%s] => [%s]", params.key, prefix), nil
+	}
+
+	return "", "", fmt.Errorf("unknown results kind: [%s]", kind)
+}
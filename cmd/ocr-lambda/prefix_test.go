@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildResultsPrefixWorkflow(t *testing.T) {
+	tests := []struct {
+		name      string
+		pid       string
+		parentPid string
+		scale     string
+		want      string
+		wantErr   bool
+	}{
+		{name: "pid only", pid: "uva-lib:123", want: "results/uva-lib:123"},
+		{name: "pid and scale", pid: "uva-lib:123", scale: "100", want: "results/uva-lib:123/100"},
+		{name: "fractional scale", pid: "uva-lib:123", scale: "12.5", want: "results/uva-lib:123/12.5"},
+		{name: "zero scale", pid: "uva-lib:123", scale: "0", want: "results/uva-lib:123/0"},
+		{name: "equal parent pid", pid: "uva-lib:123", parentPid: "uva-lib:123", scale: "50", want: "results/uva-lib:123/50"},
+		{name: "parent pid", pid: "uva-lib:123", parentPid: "uva-lib:100", scale: "50", want: "results/uva-lib:100/uva-lib:123/50"},
+		{name: "parent pid without scale", pid: "uva-lib:123", parentPid: "uva-lib:100", want: "results/uva-lib:100/uva-lib:123"},
+
+		{name: "empty pid", pid: "", wantErr: true},
+		{name: "dot pid", pid: ".", wantErr: true},
+		{name: "dot dot pid", pid: "..", wantErr: true},
+		{name: "pid with slash", pid: "a/b", wantErr: true},
+		{name: "pid with space", pid: "a b", wantErr: true},
+		{name: "dot dot parent pid", pid: "uva-lib:123", parentPid: "..", wantErr: true},
+		{name: "parent pid with slash", pid: "uva-lib:123", parentPid: "a/..", wantErr: true},
+
+		{name: "scale dot", pid: "uva-lib:123", scale: ".", wantErr: true},
+		{name: "scale dot dot", pid: "uva-lib:123", scale: "..", wantErr: true},
+		{name: "scale dots", pid: "uva-lib:123", scale: "...", wantErr: true},
+		{name: "scale leading dot", pid: "uva-lib:123", scale: ".5", wantErr: true},
+		{name: "scale trailing dot", pid: "uva-lib:123", scale: "5.", wantErr: true},
+		{name: "scale two dots", pid: "uva-lib:123", scale: "1.2.3", wantErr: true},
+		{name: "scale negative", pid: "uva-lib:123", scale: "-50", wantErr: true},
+		{name: "scale percent", pid: "uva-lib:123", scale: "50%", wantErr: true},
+		{name: "scale exponent", pid: "uva-lib:123", scale: "1e2", wantErr: true},
+		{name: "scale slash", pid: "uva-lib:123", scale: "50/..", wantErr: true},
+		{name: "scale space", pid: "uva-lib:123", scale: " 50", wantErr: true},
+		{name: "scale newline", pid: "uva-lib:123", scale: "50\n", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, desc, err := buildResultsPrefix(resultsKindWorkflow, resultsPrefixParams{pid: tc.pid, parentPid: tc.parentPid, scale: tc.scale})
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got [%s]", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if got != tc.want {
+				t.Errorf("got [%s], want [%s]", got, tc.want)
+			}
+
+			if !strings.HasSuffix(desc, "=> ["+tc.want+"]") {
+				t.Errorf("description does not end with the prefix: [%s]", desc)
+			}
+		})
+	}
+}
+
+func TestBuildResultsPrefixStandalone(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{name: "request", key: "standalone/requests/user/image.tif", want: "standalone/results/user/image.tif"},
+		{name: "outside requests", key: "other/image.tif", want: "standalone/quarantine/other/image.tif"},
+		{name: "requests prefix only in part", key: "standalone/requestsx/image.tif", want: "standalone/quarantine/standalone/requestsx/image.tif"},
+
+		{name: "empty key", key: "", wantErr: true},
+		{name: "empty segment", key: "standalone/requests//image.tif", wantErr: true},
+		{name: "dot segment", key: "standalone/requests/./image.tif", wantErr: true},
+		{name: "dot dot segment", key: "standalone/requests/../image.tif", wantErr: true},
+		{name: "leading slash", key: "/standalone/requests/image.tif", wantErr: true},
+		{name: "trailing slash", key: "standalone/requests/", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, _, err := buildResultsPrefix(resultsKindStandalone, resultsPrefixParams{key: tc.key})
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got [%s]", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if got != tc.want {
+				t.Errorf("got [%s], want [%s]", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildResultsPrefixUnknownKind(t *testing.T) {
+	if _, _, err := buildResultsPrefix("other", resultsPrefixParams{pid: "uva-lib:123"}); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type imageFrame struct {
+	width  int
+	height int
+}
+
+// pyramid level chosen as the conversion source, and how it relates to the full resolution image
+type pyramidLevelType struct {
+	Level  int     `json:"level"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Scale  string  `json:"scale"`  // resize percentage applied to this level
+	Factor float64 `json:"factor"` // full resolution width / level width
+}
+
+// parseFrames parses "<width> <height>" lines, one per frame, as output by magick identify
+func parseFrames(out string) ([]imageFrame, error) {
+	var frames []imageFrame
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var f imageFrame
+
+		if _, err := fmt.Sscanf(strings.TrimSpace(line), "%d %d", &f.width, &f.height); err != nil {
+			return nil, fmt.Errorf("failed to parse frame size: [%s] (%s)", err.Error(), line)
+		}
+
+		frames = append(frames, f)
+	}
+
+	return frames, nil
+}
+
+func identifyFrames(image string) ([]imageFrame, error) {
+	out, err := runCommand("magick", "identify", "-format", "%w %h\n", image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify image frames: [%s] (%s)", err.Error(), out)
+	}
+
+	return parseFrames(out)
+}
+
+// halves reports whether next is (to within rounding) half the size of prev
+func halves(prev, next int) bool {
+	return next > 0 && math.Abs(float64(prev)/2-float64(next)) <= 1
+}
+
+// isPyramid distinguishes a resolution pyramid, where each frame is half the size of the
+// previous one with the same aspect ratio, from a multi-page document (or a single image)
+func isPyramid(frames []imageFrame) bool {
+	if len(frames) < 2 {
+		return false
+	}
+
+	aspect := float64(frames[0].width) / float64(frames[0].height)
+
+	for i := 1; i < len(frames); i++ {
+		prev, next := frames[i-1], frames[i]
+
+		if !halves(prev.width, next.width) || !halves(prev.height, next.height) {
+			return false
+		}
+
+		// rounding at each level distorts very small levels slightly
+		if math.Abs(float64(next.width)/float64(next.height)-aspect)/aspect > 0.02 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// choosePyramidLevel selects the smallest pyramid level that is at least as large as the
+// requested scale of the full resolution image, and the scale to apply to that level so
+// the converted image is the same size it would be if made from the full resolution level
+func choosePyramidLevel(frames []imageFrame, scale string) *pyramidLevelType {
+	if !isPyramid(frames) {
+		return nil
+	}
+
+	pct, err := strconv.ParseFloat(scale, 64)
+	if err != nil || pct <= 0 {
+		return nil
+	}
+
+	target := float64(frames[0].width) * pct / 100
+
+	level := 0
+	for i, f := range frames {
+		if float64(f.width) >= target {
+			level = i
+		}
+	}
+
+	if level == 0 {
+		return nil
+	}
+
+	f := frames[level]
+
+	return &pyramidLevelType{
+		Level:  level,
+		Width:  f.width,
+		Height: f.height,
+		Scale:  strconv.FormatFloat(target/float64(f.width)*100, 'f', 4, 64),
+		Factor: float64(frames[0].width) / float64(f.width),
+	}
+}
+
+// selectPyramidLevel checks a tiff source image for a resolution pyramid, returning the
+// level to convert from, or nil to convert from the first frame as usual
+func selectPyramidLevel(localSourceImage, scale string) *pyramidLevelType {
+	frames, err := identifyFrames(localSourceImage)
+	if err != nil {
+		log.Printf("skipping pyramid check: %s", err.Error())
+		return nil
+	}
+
+	level := choosePyramidLevel(frames, scale)
+	if level != nil {
+		log.Printf("converting from pyramid level %d (%dx%d) at %s%% instead of level 0 (%dx%d) at %s%%",
+			level.Level, level.Width, level.Height, level.Scale, frames[0].width, frames[0].height, scale)
+	}
+
+	return level
+}
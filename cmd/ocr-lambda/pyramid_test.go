@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsPyramid(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []imageFrame
+		want   bool
+	}{
+		{name: "pyramid", frames: []imageFrame{{8000, 6000}, {4000, 3000}, {2000, 1500}, {1000, 750}}, want: true},
+		{name: "odd sizes rounded", frames: []imageFrame{{7001, 5001}, {3500, 2500}, {1750, 1250}, {875, 625}}, want: true},
+		{name: "rounded up", frames: []imageFrame{{7001, 5001}, {3501, 2501}}, want: true},
+		{name: "two levels", frames: []imageFrame{{1200, 1600}, {600, 800}}, want: true},
+
+		{name: "no frames"},
+		{name: "single image", frames: []imageFrame{{8000, 6000}}},
+		{name: "pages of the same size", frames: []imageFrame{{2550, 3300}, {2550, 3300}, {2550, 3300}}},
+		{name: "pages of differing sizes", frames: []imageFrame{{2550, 3300}, {3300, 2550}}},
+		{name: "thumbnail of another aspect", frames: []imageFrame{{8000, 6000}, {4000, 4000}}},
+		{name: "one dimension halved", frames: []imageFrame{{8000, 6000}, {4000, 6000}}},
+		{name: "quartered", frames: []imageFrame{{8000, 6000}, {2000, 1500}}},
+		{name: "not halved at a later level", frames: []imageFrame{{8000, 6000}, {4000, 3000}, {4000, 3000}}},
+		{name: "aspect drifts", frames: []imageFrame{{100, 3}, {50, 2}}},
+		{name: "empty level", frames: []imageFrame{{1, 1}, {0, 0}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isPyramid(tc.frames); got != tc.want {
+				t.Errorf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestChoosePyramidLevel(t *testing.T) {
+	frames := []imageFrame{{8000, 6000}, {4000, 3000}, {2000, 1500}, {1000, 750}}
+
+	tests := []struct {
+		scale string
+		want  *pyramidLevelType
+	}{
+		{"100", nil},
+		{"200", nil},
+		{"60", nil},
+		{"50", &pyramidLevelType{Level: 1, Width: 4000, Height: 3000, Scale: "100.0000", Factor: 2}},
+		{"30", &pyramidLevelType{Level: 1, Width: 4000, Height: 3000, Scale: "60.0000", Factor: 2}},
+		{"25", &pyramidLevelType{Level: 2, Width: 2000, Height: 1500, Scale: "100.0000", Factor: 4}},
+		{"10", &pyramidLevelType{Level: 3, Width: 1000, Height: 750, Scale: "80.0000", Factor: 8}},
+		{"5", &pyramidLevelType{Level: 3, Width: 1000, Height: 750, Scale: "40.0000", Factor: 8}},
+		{"0", nil},
+		{"abc", nil},
+	}
+
+	for _, tc := range tests {
+		if got := choosePyramidLevel(frames, tc.scale); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("scale %s: got %+v, want %+v", tc.scale, got, tc.want)
+		}
+	}
+
+	if got := choosePyramidLevel([]imageFrame{{2550, 3300}, {2550, 3300}}, "25"); got != nil {
+		t.Errorf("got level %+v for a multi-page document", got)
+	}
+}
+
+func TestParseFrames(t *testing.T) {
+	got, err := parseFrames("8000 6000\n4000 3000\n 2000 1500 \n")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if want := []imageFrame{{8000, 6000}, {4000, 3000}, {2000, 1500}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, out := range []string{"", "8000\n", "8000 x 6000\n"} {
+		if _, err = parseFrames(out); err == nil {
+			t.Errorf("%q: expected an error", out)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// punctuation and symbols common in printed text; anything else that is not a letter,
+// digit or space is more likely to be noise recognized as characters
+const commonPunctuation = ".,;:!?'\"()[]-–—‘’‚“”„«»&/%$£€§¶*#@+°"
+
+// how likely the text is to be usable, so that the workflow can route poor pages to be
+// re-ocr'd or transcribed; percentages are 0-100
+type qualityScore struct {
+	Score           float64  `json:"score"`                     // overall score: dictionary words, less the noise percentages
+	DictionaryWords *float64 `json:"dictionarywords,omitempty"` // words found in the dictionaries, if any were available
+	SuspiciousChars float64  `json:"suspiciouschars"`           // characters that are neither letters, digits, spaces nor common punctuation
+	MixedWords      float64  `json:"mixedwords"`                // words mixing letters and digits (e.g. "l1ke")
+	Words           int      `json:"words"`
+	Dictionaries    []string `json:"dictionaries,omitempty"` // languages whose dictionaries were used
+}
+
+// word lists, by language, kept for the life of the lambda; nil for languages with no word list
+var dictionaries = make(map[string]map[string]bool)
+
+// dictionaryKey returns the s3 key of a language's word list: a utf-8 file with a word per line
+func dictionaryKey(l string) string {
+	return path.Join(config.dictionaryPrefix, fmt.Sprintf("%s.txt", l))
+}
+
+// loadDictionary returns the word list of a language, downloading it the first time
+// it is needed, or nil if there is none
+func loadDictionary(l string) map[string]bool {
+	if words, ok := dictionaries[l]; ok {
+		return words
+	}
+
+	key := dictionaryKey(l)
+
+	log.Printf("downloading dictionary: s3://%s/%s", config.dictionaryBucket, key)
+
+	buf := aws.NewWriteAtBuffer([]byte{})
+	downloader := s3manager.NewDownloaderWithClient(newS3Client(config.resultsS3))
+
+	err := withRetries(cmds, "dictionary download", downloadRetries(), func() error {
+		_, dlErr := downloader.DownloadWithContext(workCtx, buf, &s3.GetObjectInput{
+			Bucket: aws.String(config.dictionaryBucket),
+			Key:    aws.String(key),
+		})
+		return dlErr
+	})
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != s3.ErrCodeNoSuchKey {
+			// a later request may have better luck
+			log.Printf("WARNING: failed to download dictionary: [%s]", err.Error())
+			return nil
+		}
+
+		log.Printf("no dictionary for language: [%s]", l)
+		dictionaries[l] = nil
+
+		return nil
+	}
+
+	words := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			words[strings.ToLower(word)] = true
+		}
+	}
+
+	log.Printf("loaded %d dictionary words for language: [%s]", len(words), l)
+
+	dictionaries[l] = words
+
+	return words
+}
+
+func isCommonChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsMark(r) || strings.ContainsRune(commonPunctuation, r)
+}
+
+// inDictionaries reports whether a (lower case) word, or each part of a hyphenated
+// word, is in any of the word lists
+func inDictionaries(word string, lists []map[string]bool) bool {
+	for _, list := range lists {
+		if list[word] {
+			return true
+		}
+	}
+
+	if !strings.Contains(word, "-") {
+		return false
+	}
+
+	for _, part := range strings.Split(word, "-") {
+		if part != "" && !inDictionaries(part, lists) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func roundPct(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+
+	return math.Round(float64(part)/float64(whole)*10000) / 100
+}
+
+// scoreText scores ocr text against the word lists of its languages (if configured)
+// and for noise; it is nil if the text has no words to score
+func scoreText(text, langStr string) *qualityScore {
+	var lists []map[string]bool
+	var used []string
+
+	if config.dictionaryBucket != "" {
+		for _, l := range strings.Split(langStr, "+") {
+			if list := loadDictionary(l); list != nil {
+				lists = append(lists, list)
+				used = append(used, l)
+			}
+		}
+	}
+
+	chars, suspicious := 0, 0
+
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+
+		chars++
+
+		if !isCommonChar(r) {
+			suspicious++
+		}
+	}
+
+	words, mixed, alphabetic, known := 0, 0, 0, 0
+
+	for _, token := range strings.Fields(text) {
+		token = strings.TrimFunc(token, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if token == "" {
+			continue
+		}
+
+		words++
+
+		hasLetter := strings.IndexFunc(token, unicode.IsLetter) >= 0
+		hasDigit := strings.IndexFunc(token, unicode.IsDigit) >= 0
+
+		switch {
+		case hasLetter && hasDigit:
+			mixed++
+
+		case hasLetter:
+			alphabetic++
+
+			if len(lists) > 0 && inDictionaries(strings.ToLower(token), lists) {
+				known++
+			}
+		}
+	}
+
+	if words == 0 {
+		return nil
+	}
+
+	q := &qualityScore{
+		SuspiciousChars: roundPct(suspicious, chars),
+		MixedWords:      roundPct(mixed, words),
+		Words:           words,
+		Dictionaries:    used,
+	}
+
+	base := 100.0
+
+	if len(lists) > 0 {
+		pct := roundPct(known, alphabetic)
+		q.DictionaryWords = &pct
+		base = pct
+	}
+
+	q.Score = math.Max(0, math.Round((base-q.SuspiciousChars-q.MixedWords)*100)/100)
+
+	return q
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// working file names for a second attempt at a low confidence image
+const (
+	retryConvertedImage = "retry-converted.tif"
+	retryResultsBase    = "retry-results"
+)
+
+// a conversion and ocr of the image, recorded in the command history so that the low
+// confidence threshold can be tuned
+type ocrAttemptInfo struct {
+	Scale      string   `json:"scale,omitempty"`
+	Confidence *float64 `json:"confidence,omitempty"` // mean word confidence, if any words were recognized
+	Words      int      `json:"words"`
+	Kept       bool     `json:"kept"`
+}
+
+// addAttempt records an attempt at converting and ocring the image, if a history is being kept
+func (h *commandHistory) addAttempt(info ocrAttemptInfo) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.Attempts = append(h.Attempts, info)
+}
+
+// imageConfidence returns the mean word confidence and number of words of a single image's results
+func imageConfidence(resultsBase string) (*float64, int, error) {
+	confidence, pageWords, err := ocrConfidence([]string{fmt.Sprintf("%s.tsv", resultsBase)})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	words := 0
+	if len(pageWords) > 0 {
+		words = pageWords[0]
+	}
+
+	return confidence, words, nil
+}
+
+// isLowConfidence reports whether results should be attempted again; images with no
+// recognized words (usually blank pages) are not
+func isLowConfidence(confidence *float64) bool {
+	return config.lowConfidenceThreshold > 0 && confidence != nil && *confidence < float64(config.lowConfidenceThreshold)
+}
+
+// retryScale returns the larger scale at which to convert the source image again, or
+// an empty string if it cannot be (the scale is not numeric, or the image would be too
+// large or not fit on disk)
+func retryScale(localSourceImage, scale string) string {
+	pct, err := strconv.ParseFloat(scale, 64)
+	if err != nil || pct <= 0 {
+		return ""
+	}
+
+	larger := strconv.Itoa(int(pct * float64(config.lowConfidenceRetryScale) / 100))
+
+	width, height, err := imageSize(localSourceImage)
+	if err != nil {
+		log.Printf("WARNING: not retrying low confidence image: %s", err.Error())
+		return ""
+	}
+
+	// a reduced scale would vary with the space available, and may be no larger anyway
+	if capped, err := capOutputPixels(width, height, larger); err != nil || capped != larger {
+		log.Printf("not retrying low confidence image: %s%% would exceed %d megapixels", larger, config.maxOutputMegapixels)
+		return ""
+	}
+
+	if fitted, err := fitConvertedImage(".", width, height, larger, config.diskSpaceReduceScale); err != nil || fitted != larger {
+		log.Printf("not retrying low confidence image: insufficient disk space to convert at %s%%", larger)
+		return ""
+	}
+
+	return larger
+}
+
+// keepRetryResults replaces the results of the first attempt with those of the retry
+func keepRetryResults(resultsBase, localConvertedImage string) error {
+	files, _ := filepath.Glob(fmt.Sprintf("%s.*", retryResultsBase))
+
+	for _, f := range files {
+		if err := os.Rename(f, resultsBase+strings.TrimPrefix(f, retryResultsBase)); err != nil {
+			return fmt.Errorf("failed to keep retried ocr results: [%s]", err.Error())
+		}
+	}
+
+	if err := os.Rename(retryConvertedImage, localConvertedImage); err != nil {
+		return fmt.Errorf("failed to keep retried converted image: [%s]", err.Error())
+	}
+
+	return nil
+}
+
+// removeRetryResults removes the working files of a retry whose results were not kept
+func removeRetryResults() {
+	files, _ := filepath.Glob(fmt.Sprintf("%s.*", retryResultsBase))
+
+	for _, f := range append(files, retryConvertedImage) {
+		os.Remove(f)
+	}
+}
+
+// ocrAtScale converts the source image again at the given scale (rotated as the first
+// conversion was) and ocrs it, returning the attempt
+func ocrAtScale(engine ocrEngine, localSourceImage, sourceInput, langStr string, outputFormats []string, params convertParams, rotated int) (ocrAttemptInfo, error) {
+	attempt := ocrAttemptInfo{Scale: params.scale}
+
+	if err := engine.convert(localSourceImage, sourceInput, retryConvertedImage, params); err != nil {
+		return attempt, err
+	}
+
+	if rotated != 0 {
+		if err := rotateImage(retryConvertedImage, rotated); err != nil {
+			return attempt, err
+		}
+	}
+
+	if err := engine.recognize(retryConvertedImage, retryResultsBase, langStr, outputFormats); err != nil {
+		return attempt, err
+	}
+
+	confidence, words, err := imageConfidence(retryResultsBase)
+	if err != nil {
+		return attempt, err
+	}
+
+	attempt.Confidence, attempt.Words = confidence, words
+
+	return attempt, nil
+}
+
+// retryLowConfidence converts the source image again at a larger scale if the mean word
+// confidence of its results is below the configured threshold, and ocrs it again, keeping
+// whichever results are more confident. both attempts are recorded in the command history.
+// a failed retry leaves the first results in place. it returns the scale of the results kept.
+func retryLowConfidence(engine ocrEngine, localSourceImage, sourceInput, localConvertedImage, resultsBase, langStr string, outputFormats []string, params convertParams, rotated int) (string, error) {
+	confidence, words, err := imageConfidence(resultsBase)
+	if err != nil {
+		log.Printf("WARNING: not retrying low confidence image: %s", err.Error())
+		return params.scale, nil
+	}
+
+	if !isLowConfidence(confidence) {
+		return params.scale, nil
+	}
+
+	scale := retryScale(localSourceImage, params.scale)
+	if scale == "" {
+		return params.scale, nil
+	}
+
+	log.Printf("mean confidence %.2f is below %d; retrying at %s%%...", *confidence, config.lowConfidenceThreshold, scale)
+
+	first := ocrAttemptInfo{Scale: params.scale, Confidence: confidence, Words: words}
+
+	retry, err := ocrAtScale(engine, localSourceImage, sourceInput, langStr, outputFormats, convertParams{scale: scale, operations: params.operations}, rotated)
+
+	switch {
+	case err != nil:
+		log.Printf("WARNING: keeping first results: failed to retry low confidence image: %s", err.Error())
+		removeRetryResults()
+		first.Kept = true
+
+	case retry.Confidence != nil && *retry.Confidence > *confidence:
+		log.Printf("keeping retried results: mean confidence %.2f (was %.2f)", *retry.Confidence, *confidence)
+
+		// some of the first results may have been replaced by now, so they cannot be kept either
+		if err = keepRetryResults(resultsBase, localConvertedImage); err != nil {
+			return "", err
+		}
+
+		retry.Kept = true
+
+	default:
+		log.Print("keeping first results: retried results were no more confident")
+		removeRetryResults()
+		first.Kept = true
+	}
+
+	cmds.addAttempt(first)
+	cmds.addAttempt(retry)
+
+	if retry.Kept {
+		return scale, nil
+	}
+
+	return params.scale, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3 client settings; each purpose (source images, results) may point at a different endpoint
+type s3EndpointConfig struct {
+	endpoint       string
+	forcePathStyle bool
+	useAccelerate  bool
+}
+
+func (e s3EndpointConfig) validate() error {
+	if e.useAccelerate && e.forcePathStyle {
+		return errors.New("transfer acceleration cannot be used with path-style addressing")
+	}
+
+	if e.endpoint == "" {
+		return nil
+	}
+
+	if e.useAccelerate {
+		return errors.New("transfer acceleration cannot be used with a custom endpoint")
+	}
+
+	u, err := url.Parse(e.endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint url: [%s]", e.endpoint)
+	}
+
+	return nil
+}
+
+func (e s3EndpointConfig) String() string {
+	return fmt.Sprintf("endpoint: [%s]  pathStyle: [%t]  accelerate: [%t]", e.endpoint, e.forcePathStyle, e.useAccelerate)
+}
+
+// awsConfig returns the client overrides for these settings; credentials and
+// region still come from the session's default resolution chain
+func (e s3EndpointConfig) awsConfig() *aws.Config {
+	cfg := aws.NewConfig().
+		WithS3ForcePathStyle(e.forcePathStyle).
+		WithS3UseAccelerate(e.useAccelerate)
+
+	if e.endpoint != "" {
+		cfg = cfg.WithEndpoint(e.endpoint)
+	}
+
+	return cfg
+}
+
+// s3 storage class introduced after the sdk version in use
+const storageClassGlacierIr = "GLACIER_IR"
+
+// storageClassFor returns the storage class for a result file.  only the large results
+// (pdf, hocr and alto, which is saved as .xml) use the configured class; everything else (text, tsv, json and the
+// like) is small, and infrequent-access classes bill small objects as if they were 128 KB
+// (plus a retrieval fee), so it is always stored as STANDARD.
+func storageClassFor(resultFile string) string {
+	switch path.Ext(resultFile) {
+	case ".pdf", ".hocr", ".xml":
+		return config.storageClass
+	}
+
+	return s3.StorageClassStandard
+}
+
+// requestPayer returns the configured request payer for image downloads and result uploads
+func requestPayer() *string {
+	if config.requestPayer == "" {
+		return nil
+	}
+
+	return aws.String(config.requestPayer)
+}
+
+func newS3Client(e s3EndpointConfig) *s3.S3 {
+	return s3.New(sess, e.awsConfig())
+}
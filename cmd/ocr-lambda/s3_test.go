@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestS3EndpointConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		e       s3EndpointConfig
+		wantErr bool
+	}{
+		{name: "default", e: s3EndpointConfig{}},
+		{name: "path style", e: s3EndpointConfig{forcePathStyle: true}},
+		{name: "accelerate", e: s3EndpointConfig{useAccelerate: true}},
+		{name: "http endpoint", e: s3EndpointConfig{endpoint: "http://localhost:9000", forcePathStyle: true}},
+		{name: "https endpoint", e: s3EndpointConfig{endpoint: "https://s3.example.org"}},
+
+		{name: "accelerate and path style", e: s3EndpointConfig{useAccelerate: true, forcePathStyle: true}, wantErr: true},
+		{name: "accelerate and endpoint", e: s3EndpointConfig{endpoint: "https://s3.example.org", useAccelerate: true}, wantErr: true},
+		{name: "no scheme", e: s3EndpointConfig{endpoint: "s3.example.org"}, wantErr: true},
+		{name: "other scheme", e: s3EndpointConfig{endpoint: "ftp://s3.example.org"}, wantErr: true},
+		{name: "no host", e: s3EndpointConfig{endpoint: "http://"}, wantErr: true},
+		{name: "unparseable", e: s3EndpointConfig{endpoint: "http://[::1"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.e.validate(); (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestStorageClassFor(t *testing.T) {
+	useConfig(t)
+
+	config.storageClass = s3.StorageClassStandardIa
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "results.pdf", want: s3.StorageClassStandardIa},
+		{file: "results.hocr", want: s3.StorageClassStandardIa},
+		{file: "results.xml", want: s3.StorageClassStandardIa},
+		{file: "results/uva-lib:1/ocr.pdf", want: s3.StorageClassStandardIa},
+		{file: "results.txt", want: s3.StorageClassStandard},
+		{file: "results.tsv", want: s3.StorageClassStandard},
+		{file: "results.json", want: s3.StorageClassStandard},
+		{file: "results.epub", want: s3.StorageClassStandard},
+		{file: "results", want: s3.StorageClassStandard},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			if got := storageClassFor(tc.file); got != tc.want {
+				t.Errorf("got storage class %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEnvS3Endpoint(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want s3EndpointConfig
+	}{
+		{
+			name: "unset",
+			want: s3EndpointConfig{},
+		},
+		{
+			name: "general settings",
+			env:  map[string]string{"S3_ENDPOINT": "http://localhost:9000", "S3_FORCE_PATH_STYLE": "true"},
+			want: s3EndpointConfig{endpoint: "http://localhost:9000", forcePathStyle: true},
+		},
+		{
+			name: "purpose overrides",
+			env:  map[string]string{"S3_ENDPOINT": "http://localhost:9000", "S3_FORCE_PATH_STYLE": "true", "S3_SOURCE_ENDPOINT": "https://s3.example.org", "S3_SOURCE_FORCE_PATH_STYLE": "false"},
+			want: s3EndpointConfig{endpoint: "https://s3.example.org"},
+		},
+		{
+			name: "other purpose ignored",
+			env:  map[string]string{"S3_RESULTS_ENDPOINT": "http://localhost:9000", "S3_SOURCE_USE_ACCELERATE": "true"},
+			want: s3EndpointConfig{useAccelerate: true},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			env := map[string]string{}
+			for _, name := range []string{"ENDPOINT", "FORCE_PATH_STYLE", "USE_ACCELERATE"} {
+				env["S3_"+name], env["S3_SOURCE_"+name], env["S3_RESULTS_"+name] = "", "", ""
+			}
+			for name, value := range tc.env {
+				env[name] = value
+			}
+
+			useEnv(t, env)
+
+			if got := envS3Endpoint("SOURCE"); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestS3EndpointConfigAwsConfig(t *testing.T) {
+	cfg := s3EndpointConfig{endpoint: "http://localhost:9000", forcePathStyle: true}.awsConfig()
+
+	if aws.StringValue(cfg.Endpoint) != "http://localhost:9000" || !aws.BoolValue(cfg.S3ForcePathStyle) || aws.BoolValue(cfg.S3UseAccelerate) {
+		t.Errorf("unexpected config for custom endpoint: %+v", cfg)
+	}
+
+	cfg = s3EndpointConfig{useAccelerate: true}.awsConfig()
+
+	if cfg.Endpoint != nil || aws.BoolValue(cfg.S3ForcePathStyle) || !aws.BoolValue(cfg.S3UseAccelerate) {
+		t.Errorf("unexpected config for accelerated endpoint: %+v", cfg)
+	}
+}
+
+func TestNewS3ClientUsesEndpointPerPurpose(t *testing.T) {
+	useConfig(t)
+
+	source := useFakeS3(t)
+	sourceS3 := config.sourceS3
+
+	// results go to a second endpoint
+	results := useFakeS3(t)
+	config.sourceS3 = sourceS3
+
+	source.put("images", "a.tif", []byte("image"))
+
+	if _, err := newS3Client(config.sourceS3).HeadObject(&s3.HeadObjectInput{Bucket: aws.String("images"), Key: aws.String("a.tif")}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if _, err := newS3Client(config.resultsS3).HeadObject(&s3.HeadObjectInput{Bucket: aws.String("images"), Key: aws.String("a.tif")}); err == nil {
+		t.Error("results client found the object in the source endpoint")
+	}
+
+	if len(source.requestsFor(http.MethodHead, "")) != 1 || len(results.requestsFor(http.MethodHead, "")) != 1 {
+		t.Error("requests did not go to the endpoint configured for their purpose")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// compiled fallbacks, used when neither the request nor the operator settings specify a value
+const defaultLanguage = "eng"
+const defaultScale = "100"
+const defaultBannerTemplate = "[Machine-generated OCR — {date} — may contain errors]"
+
+// layers a resolved setting can come from, in increasing order of precedence
+const settingsLayerCompiled = "compiled"
+const settingsLayerGlobal = "global"
+const settingsLayerCollection = "collection"
+const settingsLayerRequest = "request"
+
+type settingsDefaults struct {
+	Lang           string `json:"lang,omitempty"`
+	Scale          string `json:"scale,omitempty"`
+	Banner         *bool  `json:"banner,omitempty"`         // identify delivered text as machine-generated
+	BannerTemplate string `json:"bannertemplate,omitempty"` // banner line; "{date}" is replaced with the ocr date
+}
+
+// operator settings document, e.g.:
+// {"defaults":{"lang":"eng","banner":true},"collections":{"uva-lib:1234":{"lang":"fra","scale":"150"}}}
+// collections are keyed by parent pid prefix; the longest matching prefix applies
+type operatorSettings struct {
+	Defaults    settingsDefaults            `json:"defaults"`
+	Collections map[string]settingsDefaults `json:"collections"`
+}
+
+type resolvedSettings struct {
+	Lang           string            `json:"lang"`
+	Scale          string            `json:"scale"`
+	Banner         bool              `json:"banner"`
+	BannerTemplate string            `json:"bannertemplate"`
+	Sources        map[string]string `json:"sources"` // winning layer for each setting
+}
+
+// cached operator settings, refreshed once they are older than the configured ttl
+type settingsCache struct {
+	mu       sync.Mutex
+	current  *operatorSettings
+	loadedAt time.Time
+}
+
+var operatorSettingsCache settingsCache
+
+func readSettingsSource(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "s3://") {
+		return ioutil.ReadFile(source)
+	}
+
+	bucketKey := strings.SplitN(strings.TrimPrefix(source, "s3://"), "/", 2)
+	if len(bucketKey) != 2 || bucketKey[0] == "" || bucketKey[1] == "" {
+		return nil, fmt.Errorf("invalid settings location: [%s]", source)
+	}
+
+	obj, err := newS3Client(config.sourceS3).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketKey[0]),
+		Key:    aws.String(bucketKey[1]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	return ioutil.ReadAll(obj.Body)
+}
+
+func loadOperatorSettings(source string) (*operatorSettings, error) {
+	data, err := readSettingsSource(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read operator settings: [%s]", err.Error())
+	}
+
+	var settings operatorSettings
+
+	if err = json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse operator settings: [%s]", err.Error())
+	}
+
+	return &settings, nil
+}
+
+// get returns the current operator settings, reloading them if stale.  a failed reload
+// keeps the previous good settings (if any) so that running traffic is unaffected.
+func (c *settingsCache) get() *operatorSettings {
+	if config.settingsSource == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := time.Duration(config.settingsTTLSecs) * time.Second
+
+	if c.current != nil && time.Since(c.loadedAt) < ttl {
+		return c.current
+	}
+
+	settings, err := loadOperatorSettings(config.settingsSource)
+	if err != nil {
+		log.Printf("WARNING: keeping previous operator settings: %s", err.Error())
+
+		// try again after another ttl, rather than on every request
+		c.loadedAt = time.Now()
+
+		return c.current
+	}
+
+	c.current = settings
+	c.loadedAt = time.Now()
+
+	return c.current
+}
+
+// collection returns the overrides for the longest collection prefix matching the parent pid
+func (s *operatorSettings) collection(parentPid string) (string, settingsDefaults) {
+	best := ""
+	var overrides settingsDefaults
+
+	if parentPid == "" {
+		return best, overrides
+	}
+
+	for prefix, o := range s.Collections {
+		if strings.HasPrefix(parentPid, prefix) && len(prefix) > len(best) {
+			best = prefix
+			overrides = o
+		}
+	}
+
+	return best, overrides
+}
+
+// resolveSettings layers request values over per-collection and global operator
+// settings, over the compiled fallbacks, recording which layer each value came from
+func resolveSettings(settings *operatorSettings, parentPid string, request settingsDefaults) resolvedSettings {
+	res := resolvedSettings{
+		Lang:           defaultLanguage,
+		Scale:          defaultScale,
+		BannerTemplate: defaultBannerTemplate,
+		Sources: map[string]string{
+			"lang":           settingsLayerCompiled,
+			"scale":          settingsLayerCompiled,
+			"banner":         settingsLayerCompiled,
+			"bannertemplate": settingsLayerCompiled,
+		},
+	}
+
+	apply := func(layer string, values settingsDefaults) {
+		if values.Lang != "" {
+			res.Lang = values.Lang
+			res.Sources["lang"] = layer
+		}
+
+		if values.Scale != "" {
+			res.Scale = values.Scale
+			res.Sources["scale"] = layer
+		}
+
+		if values.Banner != nil {
+			res.Banner = *values.Banner
+			res.Sources["banner"] = layer
+		}
+
+		if values.BannerTemplate != "" {
+			res.BannerTemplate = values.BannerTemplate
+			res.Sources["bannertemplate"] = layer
+		}
+	}
+
+	if settings != nil {
+		apply(settingsLayerGlobal, settings.Defaults)
+
+		if prefix, overrides := settings.collection(parentPid); prefix != "" {
+			apply(fmt.Sprintf("%s:%s", settingsLayerCollection, prefix), overrides)
+		}
+	}
+
+	apply(settingsLayerRequest, request)
+
+	return res
+}
+
+// bannerLine returns the line identifying delivered text as machine-generated
+func (s resolvedSettings) bannerLine(date time.Time) string {
+	return strings.Replace(s.BannerTemplate, "{date}", date.Format("2006-01-02"), -1)
+}
+
+func deliveryFileName(resultsBase string) string {
+	return fmt.Sprintf("%s.delivery.txt", resultsBase)
+}
+
+// saveDeliveryText writes the delivery copy of the results text: the text preceded by the banner line
+func saveDeliveryText(deliveryFile string, settings resolvedSettings, text []byte, date time.Time) error {
+	delivered := append([]byte(settings.bannerLine(date)+"\n"), text...)
+
+	if err := ioutil.WriteFile(deliveryFile, delivered, 0644); err != nil {
+		return fmt.Errorf("failed to save delivery text: [%s]", err.Error())
+	}
+
+	return nil
+}
+
+func saveResolvedSettings(resultsBase string, settings resolvedSettings) {
+	settingsText, err := json.Marshal(settings)
+	if err != nil {
+		log.Printf("failed to serialize resolved settings: [%s]", err.Error())
+		return
+	}
+
+	if err = ioutil.WriteFile(fmt.Sprintf("%s.params.json", resultsBase), settingsText, 0644); err != nil {
+		log.Printf("failed to save resolved settings: [%s]", err.Error())
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveDeliveryText(t *testing.T) {
+	dir := t.TempDir()
+
+	text := []byte("first line\nsecond line\n")
+	resultsTxt := filepath.Join(dir, "results.txt")
+
+	if err := ioutil.WriteFile(resultsTxt, text, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := resolvedSettings{Banner: true, BannerTemplate: "[OCR {date}]"}
+	date := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	deliveryFile := deliveryFileName(filepath.Join(dir, "results"))
+
+	if err := saveDeliveryText(deliveryFile, settings, text, date); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	delivered, _ := ioutil.ReadFile(deliveryFile)
+
+	if want := "[OCR 2021-03-04]\nfirst line\nsecond line\n"; string(delivered) != want {
+		t.Errorf("got delivery text %q, want %q", delivered, want)
+	}
+
+	if n := strings.Count(string(delivered), "[OCR "); n != 1 {
+		t.Errorf("banner appears %d times", n)
+	}
+
+	// the canonical text is untouched
+	canonical, _ := ioutil.ReadFile(resultsTxt)
+
+	if string(canonical) != string(text) {
+		t.Errorf("results text was changed: %q", canonical)
+	}
+}
+
+func TestBannerLine(t *testing.T) {
+	date := time.Date(2021, 12, 31, 23, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{defaultBannerTemplate, "[Machine-generated OCR — 2021-12-31 — may contain errors]"},
+		{"no date", "no date"},
+		{"{date} and {date}", "2021-12-31 and 2021-12-31"},
+	}
+
+	for _, tc := range tests {
+		if got := (resolvedSettings{BannerTemplate: tc.template}).bannerLine(date); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.template, got, tc.want)
+		}
+	}
+}
+
+func TestResolveSettings(t *testing.T) {
+	var settings operatorSettings
+
+	err := json.Unmarshal([]byte(`{
+		"defaults": {"lang": "lat", "banner": true},
+		"collections": {
+			"uva-lib:1": {"lang": "fra", "scale": "150"},
+			"uva-lib:12": {"lang": "deu", "banner": false, "bannertemplate": "[OCR]"}
+		}
+	}`), &settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	no := false
+
+	tests := []struct {
+		name      string
+		settings  *operatorSettings
+		parentPid string
+		request   settingsDefaults
+		want      resolvedSettings
+	}{
+		{
+			name: "compiled fallbacks",
+			want: resolvedSettings{Lang: defaultLanguage, Scale: defaultScale, BannerTemplate: defaultBannerTemplate,
+				Sources: map[string]string{"lang": "compiled", "scale": "compiled", "banner": "compiled", "bannertemplate": "compiled"}},
+		},
+		{
+			name:     "global",
+			settings: &settings,
+			want: resolvedSettings{Lang: "lat", Scale: defaultScale, Banner: true, BannerTemplate: defaultBannerTemplate,
+				Sources: map[string]string{"lang": "global", "scale": "compiled", "banner": "global", "bannertemplate": "compiled"}},
+		},
+		{
+			name:      "collection",
+			settings:  &settings,
+			parentPid: "uva-lib:1000",
+			want: resolvedSettings{Lang: "fra", Scale: "150", Banner: true, BannerTemplate: defaultBannerTemplate,
+				Sources: map[string]string{"lang": "collection:uva-lib:1", "scale": "collection:uva-lib:1", "banner": "global", "bannertemplate": "compiled"}},
+		},
+		{
+			name:      "longest collection prefix",
+			settings:  &settings,
+			parentPid: "uva-lib:1234",
+			want: resolvedSettings{Lang: "deu", Scale: defaultScale, BannerTemplate: "[OCR]",
+				Sources: map[string]string{"lang": "collection:uva-lib:12", "scale": "compiled", "banner": "collection:uva-lib:12", "bannertemplate": "collection:uva-lib:12"}},
+		},
+		{
+			name:      "no matching collection",
+			settings:  &settings,
+			parentPid: "uva-lib:2",
+			want: resolvedSettings{Lang: "lat", Scale: defaultScale, Banner: true, BannerTemplate: defaultBannerTemplate,
+				Sources: map[string]string{"lang": "global", "scale": "compiled", "banner": "global", "bannertemplate": "compiled"}},
+		},
+		{
+			name:      "request",
+			settings:  &settings,
+			parentPid: "uva-lib:1000",
+			request:   settingsDefaults{Lang: "eng+fra", Banner: &no},
+			want: resolvedSettings{Lang: "eng+fra", Scale: "150", BannerTemplate: defaultBannerTemplate,
+				Sources: map[string]string{"lang": "request", "scale": "collection:uva-lib:1", "banner": "request", "bannertemplate": "compiled"}},
+		},
+		{
+			name:    "request without operator settings",
+			request: settingsDefaults{Scale: "50"},
+			want: resolvedSettings{Lang: defaultLanguage, Scale: "50", BannerTemplate: defaultBannerTemplate,
+				Sources: map[string]string{"lang": "compiled", "scale": "request", "banner": "compiled", "bannertemplate": "compiled"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := resolveSettings(tc.settings, tc.parentPid, tc.request)
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOperatorSettingsCache(t *testing.T) {
+	useOperatorSettings(t, `{"defaults":{"lang":"lat"}}`)
+
+	if s := operatorSettingsCache.get(); s == nil || s.Defaults.Lang != "lat" {
+		t.Fatalf("got settings %+v", s)
+	}
+
+	// changes are not seen until the settings are stale
+	if err := ioutil.WriteFile(config.settingsSource, []byte(`{"defaults":{"lang":"fra"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := operatorSettingsCache.get(); s.Defaults.Lang != "lat" {
+		t.Errorf("got lang %q before the settings are stale, want lat", s.Defaults.Lang)
+	}
+
+	config.settingsTTLSecs = 0
+
+	if s := operatorSettingsCache.get(); s.Defaults.Lang != "fra" {
+		t.Errorf("got lang %q once the settings are stale, want fra", s.Defaults.Lang)
+	}
+
+	// a failed reload keeps the previous settings
+	if err := ioutil.WriteFile(config.settingsSource, []byte(`{"defaults":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := operatorSettingsCache.get(); s == nil || s.Defaults.Lang != "fra" {
+		t.Errorf("got settings %+v after a failed reload, want the previous settings", s)
+	}
+
+	config.settingsSource = ""
+
+	if s := operatorSettingsCache.get(); s != nil {
+		t.Errorf("got settings %+v without a settings source", s)
+	}
+}
+
+func TestLoadOperatorSettingsFromS3(t *testing.T) {
+	useConfig(t)
+
+	f := useFakeS3(t)
+	f.put("config", "ocr/settings.json", []byte(`{"collections":{"uva-lib:1":{"scale":"150"}}}`))
+
+	settings, err := loadOperatorSettings("s3://config/ocr/settings.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if settings.Collections["uva-lib:1"].Scale != "150" {
+		t.Errorf("got settings %+v", settings)
+	}
+
+	if len(f.requestsFor(http.MethodGet, "")) != 1 {
+		t.Error("settings were not read from s3")
+	}
+
+	for _, source := range []string{"s3://config", "s3:///settings.json", "s3://config/missing.json"} {
+		if _, err = loadOperatorSettings(source); err == nil || !strings.Contains(err.Error(), "failed to read operator settings") {
+			t.Errorf("%s: got error %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// result base suffixes for the pages of a double-page spread, in reading order
+var spreadPages = []string{"left", "right"}
+
+var hocrBBoxRegex = regexp.MustCompile(`bbox (\d+) (\d+) (\d+) (\d+)`)
+var hocrIDRegex = regexp.MustCompile(`id='([^']*)'`)
+var hocrPageRegex = regexp.MustCompile(`<div class='ocr_page'[^>]*>`)
+var hocrImageRegex = regexp.MustCompile(`image "[^"]*"`)
+
+func spreadResultsBase(resultsBase, page string) string {
+	return fmt.Sprintf("%s-%s", resultsBase, page)
+}
+
+func spreadResultsBases(resultsBase string) []string {
+	var bases []string
+
+	for _, page := range spreadPages {
+		bases = append(bases, spreadResultsBase(resultsBase, page))
+	}
+
+	return bases
+}
+
+func imageSize(image string) (int, int, error) {
+	out, err := runCommand("magick", "identify", "-format", "%w %h", fmt.Sprintf("%s[0]", image))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to identify image: [%s] (%s)", err.Error(), out)
+	}
+
+	var width, height int
+	if _, err = fmt.Sscanf(out, "%d %d", &width, &height); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse image size: [%s] (%s)", err.Error(), out)
+	}
+
+	return width, height, nil
+}
+
+func cropImage(image, geometry, output string) error {
+	if out, err := runCommand("magick", image, "-crop", geometry, "+repage", output); err != nil {
+		return fmt.Errorf("failed to crop image: [%s] (%s)", err.Error(), out)
+	}
+
+	return nil
+}
+
+// splitSpread crops a double-page spread into left and right page images, returning
+// their file names and the width of the left page (the x offset of the right page)
+func splitSpread(localConvertedImage string) ([]string, int, error) {
+	log.Print("splitting double-page spread...")
+
+	width, height, err := imageSize(localConvertedImage)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	left := "source-converted-left.tif"
+	right := "source-converted-right.tif"
+
+	if err = cropImage(localConvertedImage, "50%x100%+0+0", left); err != nil {
+		return nil, 0, err
+	}
+
+	// crop the right page from wherever the left one ended, so odd widths lose no column
+	leftWidth, _, err := imageSize(left)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err = cropImage(localConvertedImage, fmt.Sprintf("%dx%d+%d+0", width-leftWidth, height, leftWidth), right); err != nil {
+		return nil, 0, err
+	}
+
+	return []string{left, right}, leftWidth, nil
+}
+
+// ocrSpread ocrs each page of a double-page spread independently, then combines the
+// text and hocr of the pages into the results for the whole spread
+func ocrSpread(localConvertedImage, resultsBase, langStr string, outputFormats []string, tessdataDir string, engine tesseractParams, cleanup bool) error {
+	pages, offset, err := splitSpread(localConvertedImage)
+	if err != nil {
+		return err
+	}
+
+	for i, page := range pages {
+		if err = ocrImage(page, spreadResultsBase(resultsBase, spreadPages[i]), langStr, outputFormats, tessdataDir, engine); err != nil {
+			return err
+		}
+
+		if cleanup {
+			removeIntermediate(page)
+		}
+	}
+
+	// text is simply concatenated in reading order
+
+	var text []byte
+
+	for _, page := range spreadPages {
+		pageText, readErr := ioutil.ReadFile(fmt.Sprintf("%s.txt", spreadResultsBase(resultsBase, page)))
+		if readErr != nil {
+			return fmt.Errorf("failed to read ocr results file: [%s]", readErr.Error())
+		}
+
+		text = append(text, pageText...)
+	}
+
+	if err = ioutil.WriteFile(fmt.Sprintf("%s.txt", resultsBase), text, 0644); err != nil {
+		return fmt.Errorf("failed to save combined ocr results: [%s]", err.Error())
+	}
+
+	// other formats are only available per page
+	if !hasFormat(outputFormats, "hocr") {
+		return nil
+	}
+
+	return combineSpreadHocr(localConvertedImage, resultsBase, offset)
+}
+
+func combineSpreadHocr(localConvertedImage, resultsBase string, offset int) error {
+	var docs []string
+
+	for _, page := range spreadPages {
+		doc, err := ioutil.ReadFile(fmt.Sprintf("%s.hocr", spreadResultsBase(resultsBase, page)))
+		if err != nil {
+			return fmt.Errorf("failed to read hocr results file: [%s]", err.Error())
+		}
+
+		docs = append(docs, string(doc))
+	}
+
+	width, height, err := imageSize(localConvertedImage)
+	if err != nil {
+		return err
+	}
+
+	combined, err := mergeHocrPages(docs[0], docs[1], offset, width, height, localConvertedImage)
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(fmt.Sprintf("%s.hocr", resultsBase), []byte(combined), 0644); err != nil {
+		return fmt.Errorf("failed to save combined hocr results: [%s]", err.Error())
+	}
+
+	return nil
+}
+
+// hocrPageContents splits a tesseract hocr document around the contents of its page element
+func hocrPageContents(doc string) (string, string, string, error) {
+	start := hocrPageRegex.FindStringIndex(doc)
+	end := strings.LastIndex(doc, "</div>")
+
+	if start == nil || end < start[1] {
+		return "", "", "", fmt.Errorf("failed to find page in hocr")
+	}
+
+	return doc[:start[1]], doc[start[1]:end], doc[end:], nil
+}
+
+// mergeHocrPages moves the contents of the right page into the left page, shifting the
+// right page's bounding boxes by the width of the left page and renaming its element ids
+// so they remain unique.  the page element is updated to describe the whole spread.
+func mergeHocrPages(left, right string, offset, width, height int, image string) (string, error) {
+	head, leftContents, tail, err := hocrPageContents(left)
+	if err != nil {
+		return "", err
+	}
+
+	_, rightContents, _, err := hocrPageContents(right)
+	if err != nil {
+		return "", err
+	}
+
+	rightContents = hocrBBoxRegex.ReplaceAllStringFunc(rightContents, func(bbox string) string {
+		m := hocrBBoxRegex.FindStringSubmatch(bbox)
+		x0, _ := strconv.Atoi(m[1])
+		x1, _ := strconv.Atoi(m[3])
+		return fmt.Sprintf("bbox %d %s %d %s", x0+offset, m[2], x1+offset, m[4])
+	})
+
+	rightContents = hocrIDRegex.ReplaceAllString(rightContents, "id='right_$1'")
+
+	pageTag := hocrPageRegex.FindString(head)
+	spreadTag := hocrBBoxRegex.ReplaceAllString(pageTag, fmt.Sprintf("bbox 0 0 %d %d", width, height))
+	spreadTag = hocrImageRegex.ReplaceAllString(spreadTag, fmt.Sprintf(`image "%s"`, image))
+
+	head = strings.Replace(head, pageTag, spreadTag, 1)
+
+	return head + leftContents + rightContents + tail, nil
+}
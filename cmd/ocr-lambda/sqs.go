@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// json for sqs message -> lambda communication, where each message body is a
+// workflow request, an s3 event, or an sns notification wrapping an s3 event
+type sqsRecordType struct {
+	MessageID   string `json:"messageId,omitempty"`
+	EventSource string `json:"eventSource,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+type sqsEvent struct {
+	Records []sqsRecordType `json:"Records,omitempty"`
+}
+
+// partial batch response, so that only failed messages are retried (requires
+// ReportBatchItemFailures on the event source mapping)
+type sqsBatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+type sqsBatchResponse struct {
+	BatchItemFailures []sqsBatchItemFailure `json:"batchItemFailures"`
+}
+
+// test notification sent by s3 when a bucket is first configured to notify a queue
+type s3TestEventType struct {
+	Event string `json:"Event,omitempty"`
+}
+
+const sqsEventSource = "aws:sqs"
+const s3TestEvent = "s3:TestEvent"
+
+func isSqsRequest(req lambdaRequestType) bool {
+	return len(req.Records) > 0 && req.Records[0].EventSource == sqsEventSource
+}
+
+// sqsEventFromRequest recovers the sqs layer of a request that was decoded as an s3 event
+func sqsEventFromRequest(req lambdaRequestType) sqsEvent {
+	var event sqsEvent
+
+	for _, rec := range req.Records {
+		event.Records = append(event.Records, sqsRecordType{MessageID: rec.MessageID, EventSource: rec.EventSource, Body: rec.Body})
+	}
+
+	return event
+}
+
+var errSqsTestEvent = errors.New("s3 test event")
+
+// unwrapSqsMessage decodes the request carried in an sqs message body
+func unwrapSqsMessage(body string) (lambdaRequestType, error) {
+	var req lambdaRequestType
+
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return req, fmt.Errorf("failed to parse sqs message body: [%s]", err.Error())
+	}
+
+	// sns notifications delivered to sqs without raw message delivery carry the s3 event as a string
+	if req.Pid == "" && len(req.Records) == 0 {
+		var note snsMessageType
+		if err := json.Unmarshal([]byte(body), &note); err == nil && note.Message != "" {
+			body = note.Message
+			if err = json.Unmarshal([]byte(body), &req); err != nil {
+				return req, fmt.Errorf("failed to parse sns message in sqs message body: [%s]", err.Error())
+			}
+		}
+	}
+
+	var test s3TestEventType
+	if err := json.Unmarshal([]byte(body), &test); err == nil && test.Event == s3TestEvent {
+		return req, errSqsTestEvent
+	}
+
+	if isSqsRequest(req) {
+		return req, errors.New("sqs message body is itself an sqs event")
+	}
+
+	return req, nil
+}
+
+// handleSqsOcrRequest processes each message in an sqs batch, reporting the ones that failed
+func handleSqsOcrRequest(ctx context.Context, req lambdaRequestType) (sqsBatchResponse, error) {
+	log.Print("handling sqs ocr request(s)")
+
+	event := sqsEventFromRequest(req)
+
+	res := sqsBatchResponse{BatchItemFailures: []sqsBatchItemFailure{}}
+
+	for _, rec := range event.Records {
+		inner, err := unwrapSqsMessage(rec.Body)
+		if err == errSqsTestEvent {
+			log.Printf("sqs message [%s]: ignoring s3 test event", rec.MessageID)
+			continue
+		}
+
+		if err == nil {
+			if _, err = handleOcrRequest(ctx, inner); err == nil {
+				log.Printf("sqs message [%s]: processed", rec.MessageID)
+				continue
+			}
+		}
+
+		log.Printf("sqs message [%s]: failed: %s", rec.MessageID, err.Error())
+
+		res.BatchItemFailures = append(res.BatchItemFailures, sqsBatchItemFailure{ItemIdentifier: rec.MessageID})
+	}
+
+	if len(res.BatchItemFailures) > 0 {
+		log.Printf("failed to process %d of %d sqs messages", len(res.BatchItemFailures), len(event.Records))
+	}
+
+	return res, nil
+}
+
+// handleLambdaRequest returns a batch response for sqs events, which the lambda service
+// interprets itself, and the ocr response for everything else
+func handleLambdaRequest(ctx context.Context, req lambdaRequestType) (interface{}, error) {
+	if isSqsRequest(req) {
+		return handleSqsOcrRequest(ctx, req)
+	}
+
+	return handleOcrRequest(ctx, req)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// formats magick can decode sequentially from a pipe; others (e.g. tiff, jp2) need
+// random access, so magick would spool them to a temporary file itself
+var streamableFormats = []string{"jpeg", "png"}
+
+// probeSourceFormat identifies the source image from its leading bytes, without downloading it
+func probeSourceFormat(bucket, key string) (*imageFormat, error) {
+	var obj *s3.GetObjectOutput
+
+	err := withRetries(cmds, "probe", downloadRetries(), func() error {
+		var getErr error
+		obj, getErr = newS3Client(config.sourceS3).GetObjectWithContext(workCtx, &s3.GetObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			Range:        aws.String("bytes=0-15"),
+			RequestPayer: requestPayer(),
+		})
+		return getErr
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3 file header: [%s]", err.Error())
+	}
+	defer obj.Body.Close()
+
+	header, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3 file header: [%s]", err.Error())
+	}
+
+	return detectImageFormat(header), nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// streamConvertImage converts the source image as it is downloaded, so that it never
+// occupies local storage, returning the bytes streamed; it returns false (and no error)
+// if the format cannot be streamed
+func streamConvertImage(bucket, key, localConvertedImage string, params convertParams) (int64, bool, error) {
+	format, err := probeSourceFormat(bucket, key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if format == nil || !hasFormat(streamableFormats, format.name) {
+		return 0, false, nil
+	}
+
+	log.Printf("streaming image: s3://%s/%s => %s", bucket, key, localConvertedImage)
+
+	obj, err := newS3Client(config.sourceS3).GetObjectWithContext(workCtx, &s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: requestPayer(),
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to download s3 file: [%s]", err.Error())
+	}
+	defer obj.Body.Close()
+
+	body := &countingReader{r: obj.Body}
+	input := fmt.Sprintf("%s:-", format.coder)
+
+	if out, err := runCommandInput(body, "magick", convertArgs(input, localConvertedImage, params)...); err != nil {
+		return 0, false, fmt.Errorf("failed to convert streamed image: [%s] (%s)", err.Error(), strings.TrimSpace(out))
+	}
+
+	// magick may stop reading before any trailing data, or early on a truncated stream
+	// (yet still produce an image), so read whatever remains to check the transfer
+	if _, err = io.Copy(ioutil.Discard, body); err != nil {
+		return 0, false, fmt.Errorf("failed to download s3 file: [%s]", err.Error())
+	}
+
+	if expected := aws.Int64Value(obj.ContentLength); body.n != expected {
+		return 0, false, fmt.Errorf("incomplete_download: streamed %d of %d bytes for s3://%s/%s", body.n, expected, bucket, key)
+	}
+
+	return body.n, true, nil
+}
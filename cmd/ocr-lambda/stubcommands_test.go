@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useStubCommands installs shell scripts, by command name, ahead of the real commands
+// on the path for the duration of the test, and keeps a fresh command history
+func useStubCommands(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	savedPath, savedCmds := os.Getenv("PATH"), cmds
+
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+savedPath)
+	cmds = &commandHistory{}
+
+	t.Cleanup(func() {
+		os.Setenv("PATH", savedPath)
+		cmds = savedCmds
+	})
+
+	return dir
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sfn"
+)
+
+// step functions limits the length of a task failure's cause
+const maxTaskFailureCause = 32768
+
+// task failure error codes, which state machines can match in retry and catch rules
+const (
+	taskErrorFailed       = "OcrFailed"
+	taskErrorTimedOut     = "OcrTimedOut"
+	taskErrorStageTimeout = "OcrStageTimedOut"
+)
+
+func taskErrorCode(err error) string {
+	switch err.(type) {
+	case *workDeadlineError:
+		return taskErrorTimedOut
+	case *stageTimeoutError:
+		return taskErrorStageTimeout
+	}
+
+	return taskErrorFailed
+}
+
+// sendTaskResult reports the outcome of a request to the step functions state machine
+// waiting on its task token; the response (json) is the task output on success
+func sendTaskResult(ctx context.Context, taskToken, response string, resultErr error) {
+	svc := sfn.New(sess)
+
+	var err error
+
+	if resultErr == nil {
+		log.Print("sending task success")
+
+		_, err = svc.SendTaskSuccessWithContext(ctx, &sfn.SendTaskSuccessInput{
+			TaskToken: aws.String(taskToken),
+			Output:    aws.String(response),
+		})
+	} else {
+		log.Print("sending task failure")
+
+		cause, _ := truncateText(resultErr.Error(), maxTaskFailureCause, config.truncationMarker)
+
+		_, err = svc.SendTaskFailureWithContext(ctx, &sfn.SendTaskFailureInput{
+			TaskToken: aws.String(taskToken),
+			Error:     aws.String(taskErrorCode(resultErr)),
+			Cause:     aws.String(cause),
+		})
+	}
+
+	if err != nil {
+		log.Printf("WARNING: failed to send task result: [%s]", err.Error())
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// github repositories of each tessdata quality tier: "fast" models are quickest, "best"
+// most accurate, and "standard" also include legacy engine data
+var tessdataRepos = map[string]string{
+	"fast":     "tessdata_fast",
+	"best":     "tessdata_best",
+	"standard": "tessdata",
+}
+
+var tessdataTypes = []string{"fast", "best", "standard"}
+
+func validateTessdataType(langType string) error {
+	if _, ok := tessdataRepos[langType]; !ok {
+		return fmt.Errorf("invalid tessdata type: [%s] (expected one of %s)", langType, strings.Join(tessdataTypes, ", "))
+	}
+
+	return nil
+}
+
+// tessdataTypeDir returns the local directory holding language files of a tessdata tier;
+// the configured tier uses the default directory (which includes the bundled files),
+// and any other is kept apart from it so that tiers are never mixed
+func tessdataTypeDir(langType string) string {
+	if langType == config.tessdataType {
+		return os.Getenv("TESSDATA_PREFIX")
+	}
+
+	return fmt.Sprintf("/tmp/tessdata-%s", langType)
+}
+
+// parseTessdataLocation splits an s3://bucket/prefix location, or a prefix within the
+// request bucket, into bucket and prefix
+func parseTessdataLocation(location, defaultBucket string) (string, string, error) {
+	bucket, prefix := defaultBucket, location
+
+	if strings.HasPrefix(location, "s3://") {
+		bucketPrefix := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+		if len(bucketPrefix) != 2 || bucketPrefix[0] == "" {
+			return "", "", fmt.Errorf("invalid tessdata location: [%s]", location)
+		}
+
+		bucket, prefix = bucketPrefix[0], bucketPrefix[1]
+	}
+
+	prefix = strings.Trim(prefix, "/")
+
+	if prefix == "" {
+		return "", "", fmt.Errorf("invalid tessdata location: [%s]", location)
+	}
+
+	return bucket, prefix + "/", nil
+}
+
+// downloadTessdataDir downloads the files directly under an s3 tessdata prefix (e.g. custom
+// trained models) into localDir, then links in any of the languages in langStr that it
+// lacks from languageDir
+func downloadTessdataDir(location, defaultBucket, localDir, languageDir, langStr string) error {
+	bucket, prefix, err := parseTessdataLocation(location, defaultBucket)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(localDir, 0755); err != nil {
+		return fmt.Errorf("failed to create tessdata dir: [%s]", err.Error())
+	}
+
+	var keys []string
+
+	if err = newS3Client(config.sourceS3).ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:       aws.String(bucket),
+		Prefix:       aws.String(prefix),
+		Delimiter:    aws.String("/"),
+		RequestPayer: requestPayer(),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if key := aws.StringValue(obj.Key); key != prefix {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	}); err != nil {
+		return fmt.Errorf("failed to list tessdata files: [%s]", err.Error())
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no tessdata files found: [s3://%s/%s]", bucket, prefix)
+	}
+
+	for _, key := range keys {
+		if _, err = downloadImage(bucket, key, filepath.Join(localDir, path.Base(key))); err != nil {
+			return fmt.Errorf("failed to download tessdata file: [%s]", err.Error())
+		}
+	}
+
+	// checkLanguages has already ensured every needed language is in the language directory
+
+	for _, l := range append([]string{"osd"}, strings.Split(langStr, "+")...) {
+		if l == "" {
+			continue
+		}
+
+		name := fmt.Sprintf("%s.traineddata", l)
+		local := filepath.Join(localDir, name)
+
+		if _, statErr := os.Stat(local); statErr == nil {
+			continue
+		}
+
+		if err = os.Symlink(filepath.Join(languageDir, name), local); err != nil {
+			log.Printf("WARNING: failed to link default language file: [%s]", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// tessdataEnv returns the environment for a tesseract subprocess using the given
+// tessdata directory, leaving this process's environment untouched
+func tessdataEnv(tessdataDir string) []string {
+	if tessdataDir == "" {
+		return nil
+	}
+
+	return append(os.Environ(), fmt.Sprintf("TESSDATA_PREFIX=%s", tessdataDir))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// tessdataCacheKey returns the s3 key of a cached language file; files from different
+// tessdata repositories and versions are kept apart
+func tessdataCacheKey(langType, langBranch, l string) string {
+	return path.Join(config.tessdataCachePrefix, tessdataRepos[langType], langBranch, fmt.Sprintf("%s.traineddata", l))
+}
+
+// fetchCachedLanguage downloads a language file from the s3 cache, returning false
+// if it could not be (most often because it has not been cached yet); failed attempts
+// are recorded in the given history, if any
+func fetchCachedLanguage(history *commandHistory, langType, langBranch, l, langFile string) bool {
+	key := tessdataCacheKey(langType, langBranch, l)
+
+	log.Printf("downloading cached language file: s3://%s/%s => %s", config.tessdataCacheBucket, key, langFile)
+
+	// download to a temporary file and rename it into place, as for other language downloads
+	f, err := ioutil.TempFile(filepath.Dir(langFile), fmt.Sprintf("%s.*.download", filepath.Base(langFile)))
+	if err != nil {
+		log.Printf("WARNING: failed to create temporary language file: [%s]", err.Error())
+		return false
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	downloader := s3manager.NewDownloaderWithClient(newS3Client(config.resultsS3))
+
+	err = withRetries(history, "tessdata cache download", downloadRetries(), func() error {
+		_, dlErr := downloader.Download(f, &s3.GetObjectInput{
+			Bucket: aws.String(config.tessdataCacheBucket),
+			Key:    aws.String(key),
+		})
+		return dlErr
+	})
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != s3.ErrCodeNoSuchKey {
+			log.Printf("WARNING: failed to download cached language file: [%s]", err.Error())
+		}
+		return false
+	}
+
+	if err = f.Close(); err != nil {
+		log.Printf("WARNING: failed to save cached language file: [%s]", err.Error())
+		return false
+	}
+
+	if err = os.Rename(f.Name(), langFile); err != nil {
+		log.Printf("WARNING: failed to save cached language file: [%s]", err.Error())
+		return false
+	}
+
+	return true
+}
+
+// storeCachedLanguage uploads a downloaded language file to the s3 cache; failures
+// only cost a later request the download again, so are not errors
+func storeCachedLanguage(langType, langBranch, l, langFile string) {
+	key := tessdataCacheKey(langType, langBranch, l)
+
+	log.Printf("caching language file: %s => s3://%s/%s", langFile, config.tessdataCacheBucket, key)
+
+	f, err := os.Open(langFile)
+	if err != nil {
+		log.Printf("WARNING: failed to open language file for caching: [%s]", err.Error())
+		return
+	}
+	defer f.Close()
+
+	uploader := s3manager.NewUploaderWithClient(newS3Client(config.resultsS3))
+
+	if _, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(config.tessdataCacheBucket),
+		Key:    aws.String(key),
+		Body:   f,
+	}); err != nil {
+		log.Printf("WARNING: failed to cache language file: [%s]", err.Error())
+	}
+}
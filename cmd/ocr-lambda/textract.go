@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/textract"
+)
+
+// days to keep each day's textract usage count
+const textractUsageTTLDays = 7
+
+// text textract detected in a source image
+type textractText struct {
+	lines      []string
+	confidence *float64 // mean word confidence (0-100), if any words were detected
+	words      int
+}
+
+// reserveTextractCall counts a call against today's textract limit, which is shared by all
+// invocations, reporting whether it is within the limit; textract is charged per page
+func reserveTextractCall() (bool, error) {
+	now := time.Now().UTC()
+
+	svc := dynamodb.New(sess)
+
+	_, err := svc.UpdateItemWithContext(workCtx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(config.textractUsageTable),
+		Key:                 map[string]*dynamodb.AttributeValue{"day": {S: aws.String(now.Format("2006-01-02"))}},
+		UpdateExpression:    aws.String("ADD #calls :one SET #expires = :expires"),
+		ConditionExpression: aws.String("attribute_not_exists(#calls) OR #calls < :limit"),
+		ExpressionAttributeNames: map[string]*string{
+			"#calls":   aws.String("calls"),
+			"#expires": aws.String("expires"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":     {N: aws.String("1")},
+			":limit":   {N: aws.String(strconv.Itoa(config.textractDailyLimit))},
+			":expires": {N: aws.String(strconv.FormatInt(now.AddDate(0, 0, textractUsageTTLDays).Unix(), 10))},
+		},
+	})
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("failed to update textract usage: [%s]", err.Error())
+	}
+
+	return true, nil
+}
+
+// detectDocumentText runs textract's text detection on a (single page) source image in s3
+func detectDocumentText(bucket, key string) (*textractText, error) {
+	log.Printf("detecting text with textract: s3://%s/%s", bucket, key)
+
+	svc := textract.New(sess)
+
+	out, err := svc.DetectDocumentTextWithContext(workCtx, &textract.DetectDocumentTextInput{
+		Document: &textract.Document{
+			S3Object: &textract.S3Object{Bucket: aws.String(bucket), Name: aws.String(key)},
+		},
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect text with textract: [%s]", err.Error())
+	}
+
+	text := &textractText{}
+
+	var total float64
+
+	for _, block := range out.Blocks {
+		switch aws.StringValue(block.BlockType) {
+		case textract.BlockTypeLine:
+			text.lines = append(text.lines, aws.StringValue(block.Text))
+
+		case textract.BlockTypeWord:
+			text.words++
+			total += aws.Float64Value(block.Confidence)
+		}
+	}
+
+	if text.words > 0 {
+		mean := math.Round(total/float64(text.words)*100) / 100
+		text.confidence = &mean
+	}
+
+	return text, nil
+}
+
+// textractFallback replaces the results text with the text textract detects in the source
+// image, if the daily limit allows; it returns that text, or nil if the results text was
+// left as it was (failures are logged, as tesseract's text is still a result)
+func textractFallback(bucket, key, localResultsTxt string) *textractText {
+	ok, err := reserveTextractCall()
+	if err != nil {
+		log.Printf("WARNING: not using textract: %s", err.Error())
+		return nil
+	}
+
+	if !ok {
+		log.Printf("WARNING: not using textract: daily limit of %d calls reached", config.textractDailyLimit)
+		return nil
+	}
+
+	text, err := detectDocumentText(bucket, key)
+	if err != nil {
+		log.Printf("WARNING: keeping tesseract text: %s", err.Error())
+		return nil
+	}
+
+	if text.words == 0 {
+		log.Print("keeping tesseract text: textract detected no text either")
+		return nil
+	}
+
+	if err = ioutil.WriteFile(localResultsTxt, []byte(strings.Join(text.lines, "\n")+"\n"), 0644); err != nil {
+		log.Printf("WARNING: keeping tesseract text: failed to save textract text: [%s]", err.Error())
+		return nil
+	}
+
+	log.Printf("using textract text: %d words", text.words)
+
+	return text
+}
+
+// validateTextractRequest checks that textract can be used for a request: it is enabled,
+// and the source is a single image in s3 that tesseract ocrs whole. reproducible requests
+// cannot use it, as textract's text cannot be regenerated by the pinned toolchain.
+func validateTextractRequest(req workflowRequestType, engineName string) error {
+	if config.textractDailyLimit == 0 {
+		return errors.New("textract fallback is not enabled")
+	}
+
+	if req.ImageURL != "" || req.MultiPage || req.SplitSpread || engineName != engineTesseract {
+		return errors.New("textractfallback cannot be combined with imageurl, multipage, splitspread or other engines")
+	}
+
+	if req.Reproducible {
+		return errors.New("textractfallback cannot be combined with reproducible")
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// pinned toolchain used to verify reproducible runs
+type toolchainManifest struct {
+	Tesseract   string            `json:"tesseract,omitempty"`   // expected tesseract version, e.g. "4.1.1"
+	Magick      string            `json:"magick,omitempty"`      // expected imagemagick version, e.g. "7.0.11-2"
+	Traineddata map[string]string `json:"traineddata,omitempty"` // language => sha256 of its traineddata file
+}
+
+func loadToolchainManifest(source string) (*toolchainManifest, error) {
+	var data []byte
+
+	switch {
+	case source == "":
+		return nil, errors.New("no toolchain manifest configured (set OCR_TOOLCHAIN_MANIFEST)")
+
+	case strings.HasPrefix(source, "s3://"):
+		bucketKey := strings.SplitN(strings.TrimPrefix(source, "s3://"), "/", 2)
+		if len(bucketKey) != 2 || bucketKey[0] == "" || bucketKey[1] == "" {
+			return nil, fmt.Errorf("invalid toolchain manifest location: [%s]", source)
+		}
+
+		obj, err := newS3Client(config.sourceS3).GetObject(&s3.GetObjectInput{
+			Bucket:       aws.String(bucketKey[0]),
+			Key:          aws.String(bucketKey[1]),
+			RequestPayer: requestPayer(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to download toolchain manifest: [%s]", err.Error())
+		}
+		defer obj.Body.Close()
+
+		if data, err = ioutil.ReadAll(obj.Body); err != nil {
+			return nil, fmt.Errorf("failed to read toolchain manifest: [%s]", err.Error())
+		}
+
+	default:
+		data = []byte(source)
+	}
+
+	var manifest toolchainManifest
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse toolchain manifest: [%s]", err.Error())
+	}
+
+	return &manifest, nil
+}
+
+func getTesseractVersion() string {
+	// first line is of the form: "tesseract 4.1.1"
+	out, _ := runCommand("tesseract", "--version")
+	fields := strings.Fields(firstLine(out))
+
+	if len(fields) < 2 {
+		return ""
+	}
+
+	return fields[1]
+}
+
+func getMagickVersion() string {
+	// first line is of the form: "Version: ImageMagick 7.0.11-2 Q16 x86_64 2021-03-06 https://imagemagick.org"
+	out, _ := runCommand("magick", "--version")
+	fields := strings.Fields(firstLine(out))
+
+	if len(fields) < 3 {
+		return ""
+	}
+
+	return fields[2]
+}
+
+func firstLine(s string) string {
+	return strings.SplitN(strings.TrimSpace(s), "\n", 2)[0]
+}
+
+func hashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// collectToolchain records the toolchain actually present in this environment,
+// limited to the traineddata files listed in the expected manifest plus those in use
+func collectToolchain(expected *toolchainManifest, langs []string) *toolchainManifest {
+	actual := &toolchainManifest{
+		Tesseract:   getTesseractVersion(),
+		Magick:      getMagickVersion(),
+		Traineddata: make(map[string]string),
+	}
+
+	names := append([]string{}, langs...)
+	for l := range expected.Traineddata {
+		names = append(names, l)
+	}
+
+	for _, l := range names {
+		if _, ok := actual.Traineddata[l]; ok || l == "" {
+			continue
+		}
+
+		sum, err := hashFile(path.Join(os.Getenv("TESSDATA_PREFIX"), fmt.Sprintf("%s.traineddata", l)))
+		if err != nil {
+			log.Printf("failed to hash traineddata for [%s]: %s", l, err.Error())
+			sum = ""
+		}
+
+		actual.Traineddata[l] = sum
+	}
+
+	return actual
+}
+
+// compareToolchain returns a description of every difference between the expected and actual toolchains
+func compareToolchain(expected, actual *toolchainManifest) []string {
+	var diffs []string
+
+	if expected.Tesseract != actual.Tesseract {
+		diffs = append(diffs, fmt.Sprintf("tesseract version: expected [%s], found [%s]", expected.Tesseract, actual.Tesseract))
+	}
+
+	if expected.Magick != actual.Magick {
+		diffs = append(diffs, fmt.Sprintf("magick version: expected [%s], found [%s]", expected.Magick, actual.Magick))
+	}
+
+	var langs []string
+	for l := range actual.Traineddata {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+
+	for _, l := range langs {
+		want, pinned := expected.Traineddata[l]
+
+		switch {
+		case !pinned:
+			diffs = append(diffs, fmt.Sprintf("traineddata [%s]: not pinned in manifest", l))
+
+		case actual.Traineddata[l] == "":
+			diffs = append(diffs, fmt.Sprintf("traineddata [%s]: missing", l))
+
+		case !strings.EqualFold(want, actual.Traineddata[l]):
+			diffs = append(diffs, fmt.Sprintf("traineddata [%s]: expected sha256 [%s], found [%s]", l, want, actual.Traineddata[l]))
+		}
+	}
+
+	return diffs
+}
+
+// verifyToolchain checks the runtime toolchain against the pinned manifest, and
+// saves the verified manifest alongside the results so that it is uploaded with them
+func verifyToolchain(langStr, resultsBase string) error {
+	log.Print("verifying toolchain for reproducible run")
+
+	expected, err := loadToolchainManifest(config.toolchainManifest)
+	if err != nil {
+		return err
+	}
+
+	// osd is always used for page segmentation, in addition to the requested languages
+	actual := collectToolchain(expected, append([]string{"osd"}, strings.Split(langStr, "+")...))
+
+	if diffs := compareToolchain(expected, actual); len(diffs) > 0 {
+		return fmt.Errorf("toolchain drift: %s", strings.Join(diffs, "; "))
+	}
+
+	manifestText, err := json.Marshal(actual)
+	if err != nil {
+		return fmt.Errorf("failed to serialize toolchain manifest: [%s]", err.Error())
+	}
+
+	manifestFile := fmt.Sprintf("%s.toolchain.json", resultsBase)
+
+	if err = ioutil.WriteFile(manifestFile, manifestText, 0644); err != nil {
+		return fmt.Errorf("failed to save toolchain manifest: [%s]", err.Error())
+	}
+
+	return nil
+}
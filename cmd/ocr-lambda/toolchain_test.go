@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	osdHash = "1b5d4a0d1bcee3bcad7fbcfd2b34b2b97b1c8b0f5b0d8a8d0e2e3c6a18f5b0c1"
+	engHash = "2c6e5b1e2cdff4cdbe8fcd0e3c45c3ca8c2d9c1f6c1e9b9e1f3f4d7b29f6c1d2"
+)
+
+func TestCompareToolchain(t *testing.T) {
+	expected := &toolchainManifest{
+		Tesseract:   "4.1.1",
+		Magick:      "7.0.11-2",
+		Traineddata: map[string]string{"osd": osdHash, "eng": engHash},
+	}
+
+	tests := []struct {
+		name   string
+		actual toolchainManifest
+		diffs  []string // substrings of each expected difference, in order
+	}{
+		{
+			name:   "match",
+			actual: toolchainManifest{Tesseract: "4.1.1", Magick: "7.0.11-2", Traineddata: map[string]string{"osd": osdHash, "eng": engHash}},
+		},
+		{
+			name:   "hash case ignored",
+			actual: toolchainManifest{Tesseract: "4.1.1", Magick: "7.0.11-2", Traineddata: map[string]string{"eng": strings.ToUpper(engHash)}},
+		},
+		{
+			name:   "tesseract version mismatch",
+			actual: toolchainManifest{Tesseract: "5.0.0", Magick: "7.0.11-2", Traineddata: map[string]string{"eng": engHash}},
+			diffs:  []string{"tesseract version: expected [4.1.1], found [5.0.0]"},
+		},
+		{
+			name:   "magick version mismatch",
+			actual: toolchainManifest{Tesseract: "4.1.1", Magick: "7.1.0-0", Traineddata: map[string]string{"eng": engHash}},
+			diffs:  []string{"magick version: expected [7.0.11-2], found [7.1.0-0]"},
+		},
+		{
+			name:   "missing version",
+			actual: toolchainManifest{Magick: "7.0.11-2", Traineddata: map[string]string{}},
+			diffs:  []string{"tesseract version: expected [4.1.1], found []"},
+		},
+		{
+			name:   "hash mismatch",
+			actual: toolchainManifest{Tesseract: "4.1.1", Magick: "7.0.11-2", Traineddata: map[string]string{"eng": osdHash, "osd": osdHash}},
+			diffs:  []string{"traineddata [eng]: expected sha256 [" + engHash + "], found [" + osdHash + "]"},
+		},
+		{
+			name:   "missing traineddata",
+			actual: toolchainManifest{Tesseract: "4.1.1", Magick: "7.0.11-2", Traineddata: map[string]string{"eng": ""}},
+			diffs:  []string{"traineddata [eng]: missing"},
+		},
+		{
+			name:   "unpinned traineddata",
+			actual: toolchainManifest{Tesseract: "4.1.1", Magick: "7.0.11-2", Traineddata: map[string]string{"fra": engHash}},
+			diffs:  []string{"traineddata [fra]: not pinned in manifest"},
+		},
+		{
+			name:   "everything differs",
+			actual: toolchainManifest{Tesseract: "5.0.0", Magick: "7.1.0-0", Traineddata: map[string]string{"eng": "", "osd": engHash, "fra": engHash}},
+			diffs:  []string{"tesseract version", "magick version", "traineddata [eng]: missing", "traineddata [fra]: not pinned", "traineddata [osd]: expected sha256"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			diffs := compareToolchain(expected, &tc.actual)
+
+			if len(diffs) != len(tc.diffs) {
+				t.Fatalf("got differences %q, want %d", diffs, len(tc.diffs))
+			}
+
+			for i := range diffs {
+				if !strings.Contains(diffs[i], tc.diffs[i]) {
+					t.Errorf("difference %d: got [%s], want [%s]", i, diffs[i], tc.diffs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCollectToolchain(t *testing.T) {
+	useStubCommands(t, map[string]string{
+		"tesseract": `echo "tesseract 4.1.1"; echo " leptonica-1.80.0"`,
+		"magick":    `echo "Version: ImageMagick 7.0.11-2 Q16 x86_64 2021-03-06 https://imagemagick.org"`,
+	})
+
+	tessdata := t.TempDir()
+
+	savedPrefix := os.Getenv("TESSDATA_PREFIX")
+	os.Setenv("TESSDATA_PREFIX", tessdata)
+	defer os.Setenv("TESSDATA_PREFIX", savedPrefix)
+
+	files := map[string]string{"eng": "english model", "osd": "osd model"}
+
+	hashes := make(map[string]string)
+
+	for l, content := range files {
+		f := filepath.Join(tessdata, l+".traineddata")
+		if err := ioutil.WriteFile(f, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		hashes[l], _ = hashFile(f)
+	}
+
+	expected := &toolchainManifest{Traineddata: map[string]string{"osd": "pinned", "deu": "pinned"}}
+
+	actual := collectToolchain(expected, []string{"osd", "eng", "", "eng"})
+
+	if actual.Tesseract != "4.1.1" || actual.Magick != "7.0.11-2" {
+		t.Errorf("got versions [%s] and [%s]", actual.Tesseract, actual.Magick)
+	}
+
+	// languages in use and those pinned are hashed once each; missing files hash as ""
+	want := map[string]string{"osd": hashes["osd"], "eng": hashes["eng"], "deu": ""}
+
+	if !reflect.DeepEqual(actual.Traineddata, want) {
+		t.Errorf("got traineddata %v, want %v", actual.Traineddata, want)
+	}
+
+	// pinned files must be present even if not in use
+	pinned := &toolchainManifest{Tesseract: "4.1.1", Magick: "7.0.11-2", Traineddata: map[string]string{"osd": hashes["osd"], "eng": hashes["eng"], "deu": engHash}}
+
+	if diffs := compareToolchain(pinned, actual); len(diffs) != 1 || diffs[0] != "traineddata [deu]: missing" {
+		t.Errorf("got differences %q", diffs)
+	}
+}
+
+func TestLoadToolchainManifest(t *testing.T) {
+	manifest, err := loadToolchainManifest(`{"tesseract":"4.1.1","traineddata":{"eng":"abc"}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if manifest.Tesseract != "4.1.1" || manifest.Traineddata["eng"] != "abc" {
+		t.Errorf("got %+v", manifest)
+	}
+
+	for _, source := range []string{"", "not json", "s3://bucket", "s3:///key"} {
+		if _, err := loadToolchainManifest(source); err == nil {
+			t.Errorf("%q: expected an error", source)
+		}
+	}
+}
+
+func TestLoadToolchainManifestFromS3(t *testing.T) {
+	useConfig(t)
+
+	f := useFakeS3(t)
+	f.put("config", "ocr/toolchain.json", []byte(`{"tesseract":"4.1.1"}`))
+
+	config.requestPayer = "requester"
+
+	manifest, err := loadToolchainManifest("s3://config/ocr/toolchain.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if manifest.Tesseract != "4.1.1" {
+		t.Errorf("got %+v", manifest)
+	}
+
+	// read through the configured source endpoint, as the requester
+	gets := f.requestsFor(http.MethodGet, "")
+	if len(gets) != 1 || gets[0].header.Get("X-Amz-Request-Payer") != "requester" {
+		t.Errorf("got requests %+v, want one get paid by the requester", gets)
+	}
+
+	if _, err = loadToolchainManifest("s3://config/missing.json"); err == nil || !strings.Contains(err.Error(), "failed to download toolchain manifest") {
+		t.Errorf("got error %v for a missing manifest", err)
+	}
+}
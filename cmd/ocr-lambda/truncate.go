@@ -0,0 +1,57 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// clusterBoundary returns the largest grapheme cluster boundary in s at or before n
+func clusterBoundary(s string, n int) int {
+	if n >= len(s) {
+		return len(s)
+	}
+
+	if n <= 0 {
+		return 0
+	}
+
+	// whether there is a boundary at n depends only on what precedes it and on the
+	// character starting at n, so the rest of s need not be segmented
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	_, size := utf8.DecodeRuneInString(s[n:])
+
+	boundary := 0
+
+	g := uniseg.NewGraphemes(s[:n+size])
+
+	for g.Next() {
+		_, end := g.Positions()
+		if end > n {
+			break
+		}
+
+		boundary = end
+	}
+
+	return boundary
+}
+
+// truncateText limits s to at most budget bytes, cutting at a grapheme cluster boundary
+// and appending marker when anything was removed.  it returns the (possibly) truncated
+// text and the original length in bytes.
+func truncateText(s string, budget int, marker string) (string, int) {
+	if len(s) <= budget {
+		return s, len(s)
+	}
+
+	// the marker counts against the budget, unless it alone would exceed it
+	if len(marker) > budget {
+		marker = ""
+	}
+
+	return s[:clusterBoundary(s, budget-len(marker))] + marker, len(s)
+}
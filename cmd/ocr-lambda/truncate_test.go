@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// texts to truncate, with the grapheme clusters they consist of
+var truncateTexts = []struct {
+	name     string
+	clusters []string
+}{
+	{"ascii", []string{"p", "l", "a", "i", "n", " ", "t", "e", "x", "t"}},
+	{"empty", nil},
+	{"zwj family", []string{"👨‍👩‍👧‍👦", "!"}},
+	{"zwj sequences", []string{"a", "👩‍💻", "👨🏽‍🚀", "b"}},
+	{"skin tone", []string{"👍🏿", "👍", "👍🏻"}},
+	{"flags", []string{"🇺🇸", "🇫🇷", "x"}},
+	{"variation selector", []string{"☺️", "☺"}},
+	{"tag sequence", []string{"🏴\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f", "."}},
+	{"arabic diacritics", []string{"بِ", "سْ", "مِ", " ", "ا", "ل", "لَّ", "هِ"}},
+	{"combining accents", []string{"é", "ạ̈", "o"}},
+	{"hangul jamo", []string{"각", "가"}},
+	{"crlf", []string{"a", "\r\n", "b"}},
+	{"cjk", []string{"漢", "字", "かな"[:3], "かな"[3:]}},
+}
+
+func TestTruncateTextEveryBudget(t *testing.T) {
+	for _, marker := range []string{"", "…", "[truncated]"} {
+		for _, tc := range truncateTexts {
+			text := strings.Join(tc.clusters, "")
+
+			// the lengths at which text may be cut
+			boundaries := map[int]bool{0: true}
+			n := 0
+			for _, c := range tc.clusters {
+				n += len(c)
+				boundaries[n] = true
+			}
+
+			for budget := 0; budget <= len(text)+1; budget++ {
+				got, original := truncateText(text, budget, marker)
+
+				if original != len(text) {
+					t.Errorf("%s/%q/%d: got original length %d, want %d", tc.name, marker, budget, original, len(text))
+				}
+
+				if !utf8.ValidString(got) {
+					t.Errorf("%s/%q/%d: invalid utf-8: %q", tc.name, marker, budget, got)
+				}
+
+				if len(got) > budget && budget < len(text) {
+					t.Errorf("%s/%q/%d: %q exceeds the budget", tc.name, marker, budget, got)
+				}
+
+				if budget >= len(text) {
+					if got != text {
+						t.Errorf("%s/%q/%d: got %q, want it untouched", tc.name, marker, budget, got)
+					}
+					continue
+				}
+
+				kept := got
+				if marker != "" && len(marker) <= budget {
+					if !strings.HasSuffix(got, marker) {
+						t.Errorf("%s/%q/%d: %q lacks the marker", tc.name, marker, budget, got)
+					}
+					kept = strings.TrimSuffix(got, marker)
+				}
+
+				if !strings.HasPrefix(text, kept) || !boundaries[len(kept)] {
+					t.Errorf("%s/%q/%d: %q is not cut at a cluster boundary", tc.name, marker, budget, kept)
+				}
+
+				// as many clusters are kept as fit
+				room := budget
+				if len(marker) <= budget {
+					room -= len(marker)
+				}
+				for b := range boundaries {
+					if b > len(kept) && b <= room {
+						t.Errorf("%s/%q/%d: cut at %d, but %d fits", tc.name, marker, budget, len(kept), b)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		budget int
+		marker string
+		want   string
+	}{
+		{"fits", "hello", 5, "…", "hello"},
+		{"ascii", "hello world", 8, "...", "hello..."},
+		{"marker too long", "hello world", 2, "[truncated]", "he"},
+		{"zero budget", "hello", 0, "", ""},
+		{"before zwj family", "ab👨‍👩‍👧", 10, "", "ab"},
+		{"whole zwj family", "ab👨‍👩‍👧c", 20, "", "ab👨‍👩‍👧"},
+		{"arabic", "بِسْمِ", 5, "", "بِ"},
+		{"invalid utf-8", "ab\xffcd", 3, "", "ab\xff"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, _ := truncateText(tc.text, tc.budget, tc.marker); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// http client for the vision api and google's token endpoint
+var visionHTTPClient *http.Client
+
+// the converted image is re-encoded for the vision api, which does not accept tiffs inline
+const visionImage = "vision-input.png"
+
+// vision api requests are limited to this size; the image is base64 encoded within them
+const visionMaxImageBytes = 10 * 1024 * 1024 * 3 / 4
+
+// languages vision may be given as hints, by tesseract language; others are left for it to detect
+var visionLanguageCodes = map[string]string{
+	"ara":     "ar",
+	"chi_sim": "zh",
+	"chi_tra": "zh-Hant",
+	"deu":     "de",
+	"ell":     "el",
+	"eng":     "en",
+	"fra":     "fr",
+	"heb":     "iw",
+	"ita":     "it",
+	"jpn":     "ja",
+	"kor":     "ko",
+	"lat":     "la",
+	"rus":     "ru",
+	"spa":     "es",
+}
+
+// breaks after a word that end its line
+var visionLineBreaks = []string{"EOL_SURE_SPACE", "HYPHEN", "LINE_BREAK"}
+
+// json for the vision api's document text detection
+type visionRequestType struct {
+	Requests []visionImageRequest `json:"requests"`
+}
+
+type visionImageRequest struct {
+	Image        visionImageContent  `json:"image"`
+	Features     []visionFeature     `json:"features"`
+	ImageContext *visionImageContext `json:"imageContext,omitempty"`
+}
+
+type visionImageContent struct {
+	Content string `json:"content"`
+}
+
+type visionFeature struct {
+	Type string `json:"type"`
+}
+
+type visionImageContext struct {
+	LanguageHints []string `json:"languageHints,omitempty"`
+}
+
+type visionResponseType struct {
+	Responses []visionImageResponse `json:"responses"`
+}
+
+type visionImageResponse struct {
+	FullTextAnnotation *visionTextAnnotation `json:"fullTextAnnotation"`
+	Error              *visionStatus         `json:"error"`
+}
+
+type visionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type visionTextAnnotation struct {
+	Pages []visionPage `json:"pages"`
+	Text  string       `json:"text"`
+}
+
+type visionPage struct {
+	Width  int           `json:"width"`
+	Height int           `json:"height"`
+	Blocks []visionBlock `json:"blocks"`
+}
+
+type visionBlock struct {
+	BoundingBox visionPoly        `json:"boundingBox"`
+	Paragraphs  []visionParagraph `json:"paragraphs"`
+}
+
+type visionParagraph struct {
+	BoundingBox visionPoly   `json:"boundingBox"`
+	Words       []visionWord `json:"words"`
+}
+
+type visionWord struct {
+	BoundingBox visionPoly     `json:"boundingBox"`
+	Symbols     []visionSymbol `json:"symbols"`
+	Confidence  float64        `json:"confidence"`
+}
+
+type visionSymbol struct {
+	Text     string `json:"text"`
+	Property *struct {
+		DetectedBreak *struct {
+			Type string `json:"type"`
+		} `json:"detectedBreak"`
+	} `json:"property"`
+}
+
+// zero coordinates are omitted from the api's json
+type visionPoly struct {
+	Vertices []struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	} `json:"vertices"`
+}
+
+// box returns the bounding box of a polygon (which may be rotated)
+func (p visionPoly) box() hocrBox {
+	if len(p.Vertices) == 0 {
+		return hocrBox{}
+	}
+
+	b := hocrBox{X0: p.Vertices[0].X, Y0: p.Vertices[0].Y, X1: p.Vertices[0].X, Y1: p.Vertices[0].Y}
+
+	for _, v := range p.Vertices[1:] {
+		b.X0, b.Y0 = minInt(b.X0, v.X), minInt(b.Y0, v.Y)
+		b.X1, b.Y1 = maxInt(b.X1, v.X), maxInt(b.Y1, v.Y)
+	}
+
+	return b
+}
+
+// union returns the smallest box containing both boxes
+func (b hocrBox) union(o hocrBox) hocrBox {
+	return hocrBox{X0: minInt(b.X0, o.X0), Y0: minInt(b.Y0, o.Y0), X1: maxInt(b.X1, o.X1), Y1: maxInt(b.Y1, o.Y1)}
+}
+
+// text returns the word's text, and the kind of break that follows it, if any
+func (w visionWord) text() (string, string) {
+	var text strings.Builder
+	brk := ""
+
+	for _, s := range w.Symbols {
+		text.WriteString(s.Text)
+
+		if s.Property != nil && s.Property.DetectedBreak != nil {
+			brk = s.Property.DetectedBreak.Type
+		}
+	}
+
+	// the hyphen of a word broken across lines is reported as a break, not a symbol
+	if brk == "HYPHEN" {
+		text.WriteString("-")
+	}
+
+	return text.String(), brk
+}
+
+// google cloud vision, which recognizes some material (e.g. cjk) far better than tesseract
+type visionEngine struct{}
+
+func (e visionEngine) convert(localSourceImage, sourceInput, localConvertedImage string, params convertParams) error {
+	return convertImage(localSourceImage, sourceInput, localConvertedImage, params)
+}
+
+// recognize sends the converted image to the vision api, and saves its text (as is) and
+// its pages, blocks, paragraphs and words as hocr and tsv like tesseract's
+func (e visionEngine) recognize(localConvertedImage, resultsBase, langStr string, outputFormats []string) error {
+	log.Print("ocring image with cloud vision...")
+
+	annotation, err := detectVisionText(localConvertedImage, langStr)
+	if err != nil {
+		return err
+	}
+
+	page := visionHocrPage(annotation)
+
+	results := map[string]string{
+		"txt":  annotation.Text,
+		"hocr": renderHocr(page, localConvertedImage),
+		"tsv":  renderTsv(page),
+	}
+
+	for _, format := range outputFormats {
+		contents, ok := results[format]
+		if !ok {
+			continue
+		}
+
+		if err = ioutil.WriteFile(fmt.Sprintf("%s.%s", resultsBase, format), []byte(contents), 0644); err != nil {
+			return fmt.Errorf("failed to save vision results: [%s]", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// visionLanguageHints returns the languages vision knows of among those requested
+func visionLanguageHints(langStr string) []string {
+	var hints []string
+
+	for _, l := range strings.Split(langStr, "+") {
+		if code, ok := visionLanguageCodes[l]; ok && !hasFormat(hints, code) {
+			hints = append(hints, code)
+		}
+	}
+
+	return hints
+}
+
+// detectVisionText runs vision's document text detection on the converted image
+func detectVisionText(localConvertedImage, langStr string) (*visionTextAnnotation, error) {
+	if out, err := runCommand("magick", localConvertedImage, visionImage); err != nil {
+		return nil, fmt.Errorf("failed to prepare image for vision: [%s] (%s)", err.Error(), strings.TrimSpace(out))
+	}
+	defer os.Remove(visionImage)
+
+	image, err := ioutil.ReadFile(visionImage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image for vision: [%s]", err.Error())
+	}
+
+	if len(image) > visionMaxImageBytes {
+		return nil, fmt.Errorf("image is too large for vision: [%d bytes] (maximum %d)", len(image), visionMaxImageBytes)
+	}
+
+	imageReq := visionImageRequest{
+		Image:    visionImageContent{Content: base64.StdEncoding.EncodeToString(image)},
+		Features: []visionFeature{{Type: "DOCUMENT_TEXT_DETECTION"}},
+	}
+
+	if hints := visionLanguageHints(langStr); len(hints) > 0 {
+		imageReq.ImageContext = &visionImageContext{LanguageHints: hints}
+	}
+
+	body, err := json.Marshal(visionRequestType{Requests: []visionImageRequest{imageReq}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize vision request: [%s]", err.Error())
+	}
+
+	token, err := visionAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(workCtx, http.MethodPost, config.visionEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vision request: [%s]", err.Error())
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := visionHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect text with vision: [%s]", err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to detect text with vision: [%s]", res.Status)
+	}
+
+	var out visionResponseType
+
+	if err = json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to parse vision response: [%s]", err.Error())
+	}
+
+	if len(out.Responses) == 0 {
+		return nil, fmt.Errorf("failed to detect text with vision: empty response")
+	}
+
+	if e := out.Responses[0].Error; e != nil {
+		return nil, fmt.Errorf("failed to detect text with vision: [%s] (code %d)", e.Message, e.Code)
+	}
+
+	// images without text have no annotation
+	if out.Responses[0].FullTextAnnotation == nil {
+		return &visionTextAnnotation{}, nil
+	}
+
+	return out.Responses[0].FullTextAnnotation, nil
+}
+
+// visionHocrPage normalizes vision's (single page) annotation into the hocr model; vision
+// has no lines, so each paragraph is split into lines at the line breaks it reports
+func visionHocrPage(annotation *visionTextAnnotation) *hocrPage {
+	page := &hocrPage{ID: "page_1"}
+
+	if len(annotation.Pages) == 0 {
+		return page
+	}
+
+	p := annotation.Pages[0]
+	page.BBox = hocrBox{X1: p.Width, Y1: p.Height}
+
+	// element ids are numbered through the page, as tesseract's are
+	pars, lines, words := 0, 0, 0
+
+	for i, b := range p.Blocks {
+		block := hocrBlock{ID: fmt.Sprintf("block_1_%d", i+1), BBox: b.BoundingBox.box()}
+
+		for _, vp := range b.Paragraphs {
+			pars++
+			par := hocrPar{ID: fmt.Sprintf("par_1_%d", pars), BBox: vp.BoundingBox.box()}
+
+			var line *hocrLine
+
+			for _, vw := range vp.Words {
+				text, brk := vw.text()
+				box := vw.BoundingBox.box()
+
+				if line == nil {
+					lines++
+					par.Lines = append(par.Lines, hocrLine{ID: fmt.Sprintf("line_1_%d", lines), BBox: box})
+					line = &par.Lines[len(par.Lines)-1]
+				}
+
+				words++
+				line.BBox = line.BBox.union(box)
+				line.Words = append(line.Words, hocrWord{ID: fmt.Sprintf("word_1_%d", words), BBox: box, Conf: int(math.Round(vw.Confidence * 100)), Text: text})
+
+				if hasFormat(visionLineBreaks, brk) {
+					line = nil
+				}
+			}
+
+			block.Pars = append(block.Pars, par)
+		}
+
+		page.Blocks = append(page.Blocks, block)
+	}
+
+	return page
+}
+
+const hocrTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN"
+    "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="en" lang="en">
+ <head>
+  <title></title>
+  <meta http-equiv="Content-Type" content="text/html;charset=utf-8"/>
+  <meta name="ocr-system" content="google-cloud-vision"/>
+  <meta name="ocr-capabilities" content="ocr_page ocr_carea ocr_par ocr_line ocrx_word ocrp_wconf"/>
+ </head>
+ <body>
+%s </body>
+</html>
+`
+
+func hocrBBox(b hocrBox) string {
+	return fmt.Sprintf("bbox %d %d %d %d", b.X0, b.Y0, b.X1, b.Y1)
+}
+
+// renderHocr renders a page as hocr, in the structure tesseract produces
+func renderHocr(page *hocrPage, image string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "  <div class=\"ocr_page\" id=\"%s\" title=\"image &quot;%s&quot;; %s; ppageno 0\">\n", page.ID, html.EscapeString(image), hocrBBox(page.BBox))
+
+	for _, block := range page.Blocks {
+		fmt.Fprintf(&b, "   <div class=\"ocr_carea\" id=\"%s\" title=\"%s\">\n", block.ID, hocrBBox(block.BBox))
+
+		for _, par := range block.Pars {
+			fmt.Fprintf(&b, "    <p class=\"ocr_par\" id=\"%s\" title=\"%s\">\n", par.ID, hocrBBox(par.BBox))
+
+			for _, line := range par.Lines {
+				fmt.Fprintf(&b, "     <span class=\"ocr_line\" id=\"%s\" title=\"%s\">", line.ID, hocrBBox(line.BBox))
+
+				for i, w := range line.Words {
+					if i > 0 {
+						b.WriteString(" ")
+					}
+
+					fmt.Fprintf(&b, "<span class=\"ocrx_word\" id=\"%s\" title=\"%s; x_wconf %d\">%s</span>", w.ID, hocrBBox(w.BBox), w.Conf, html.EscapeString(w.Text))
+				}
+
+				b.WriteString("</span>\n")
+			}
+
+			b.WriteString("    </p>\n")
+		}
+
+		b.WriteString("   </div>\n")
+	}
+
+	b.WriteString("  </div>\n")
+
+	return fmt.Sprintf(hocrTemplate, b.String())
+}
+
+// renderTsv renders the words of a page in tesseract's tsv layout, for word confidences
+func renderTsv(page *hocrPage) string {
+	var b strings.Builder
+
+	b.WriteString("level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n")
+
+	row := func(level, block, par, line, word int, box hocrBox, conf int, text string) {
+		fmt.Fprintf(&b, "%d\t1\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", level, block, par, line, word,
+			box.X0, box.Y0, box.X1-box.X0, box.Y1-box.Y0, conf, strings.ReplaceAll(text, "\t", " "))
+	}
+
+	row(1, 0, 0, 0, 0, page.BBox, -1, "")
+
+	for bi, block := range page.Blocks {
+		row(2, bi+1, 0, 0, 0, block.BBox, -1, "")
+
+		for pi, par := range block.Pars {
+			row(3, bi+1, pi+1, 0, 0, par.BBox, -1, "")
+
+			for li, line := range par.Lines {
+				row(4, bi+1, pi+1, li+1, 0, line.BBox, -1, "")
+
+				for wi, w := range line.Words {
+					row(5, bi+1, pi+1, li+1, wi+1, w.BBox, w.Conf, w.Text)
+				}
+			}
+		}
+	}
+
+	return b.String()
+}
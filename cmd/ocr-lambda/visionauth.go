@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// access requested for the cloud vision api
+const visionScope = "https://www.googleapis.com/auth/cloud-vision"
+
+// access tokens are renewed this long before they expire
+const visionTokenMargin = 5 * time.Minute
+
+// the google service account key (as downloaded from the cloud console) kept in secrets manager
+type visionCredentials struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type visionTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// an access token for the vision api, kept for the life of the lambda until it expires
+type visionToken struct {
+	value   string
+	expires time.Time
+}
+
+var visionAccess visionToken
+
+// loadVisionCredentials reads the service account key from secrets manager
+func loadVisionCredentials() (*visionCredentials, error) {
+	log.Printf("reading vision credentials: [%s]", config.visionSecret)
+
+	svc := secretsmanager.New(sess)
+
+	out, err := svc.GetSecretValueWithContext(workCtx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(config.visionSecret),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vision credentials: [%s]", err.Error())
+	}
+
+	var creds visionCredentials
+
+	if err = json.Unmarshal([]byte(aws.StringValue(out.SecretString)), &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse vision credentials: [%s]", err.Error())
+	}
+
+	if creds.ClientEmail == "" || creds.PrivateKey == "" || creds.TokenURI == "" {
+		return nil, errors.New("vision credentials are not a service account key")
+	}
+
+	return &creds, nil
+}
+
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signedAssertion returns a jwt, signed with the service account's key, asserting its
+// identity to google's token endpoint
+func signedAssertion(creds *visionCredentials, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", errors.New("failed to decode vision private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse vision private key: [%s]", err.Error())
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("vision private key is not an rsa key")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": visionScope,
+		"aud":   creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	unsigned := base64URL(header) + "." + base64URL(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign vision token request: [%s]", err.Error())
+	}
+
+	return unsigned + "." + base64URL(sig), nil
+}
+
+// visionAccessToken returns a current access token for the vision api, exchanging a
+// signed assertion for a new one if needed
+func visionAccessToken() (string, error) {
+	now := time.Now()
+
+	if visionAccess.value != "" && now.Add(visionTokenMargin).Before(visionAccess.expires) {
+		return visionAccess.value, nil
+	}
+
+	creds, err := loadVisionCredentials()
+	if err != nil {
+		return "", err
+	}
+
+	assertion, err := signedAssertion(creds, now)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+
+	req, err := http.NewRequestWithContext(workCtx, http.MethodPost, creds.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to request vision access token: [%s]", err.Error())
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := visionHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request vision access token: [%s]", err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to request vision access token: [%s]", res.Status)
+	}
+
+	var token visionTokenResponse
+
+	if err = json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse vision access token: [%s]", err.Error())
+	}
+
+	visionAccess = visionToken{value: token.AccessToken, expires: now.Add(time.Duration(token.ExpiresIn) * time.Second)}
+
+	return visionAccess.value, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// word and line coordinates of a page, so that viewers can highlight search hits
+// without parsing hocr. boxes are [x0, y0, x1, y1] in pixels of the image that was
+// ocr'd, which may be scaled from the source; width and height are that image's
+// size, so boxes can be mapped onto the source proportionally.
+type wordsPageType struct {
+	Page   int             `json:"page"`
+	Width  int             `json:"width"`
+	Height int             `json:"height"`
+	Lines  []wordsLineType `json:"lines"`
+}
+
+type wordsLineType struct {
+	BBox  [4]int          `json:"bbox"`
+	Words []wordsWordType `json:"words"`
+}
+
+type wordsWordType struct {
+	Text string `json:"text"`
+	BBox [4]int `json:"bbox"`
+	Conf int    `json:"conf"`
+}
+
+func (b hocrBox) coords() [4]int {
+	return [4]int{b.X0, b.Y0, b.X1, b.Y1}
+}
+
+// hocrResultsFiles returns the hocr results of each page that was ocr'd, in page order;
+// spreads have been combined into a single page by now
+func hocrResultsFiles(resultsBase string) []string {
+	if _, err := os.Stat(fmt.Sprintf("%s.hocr", resultsBase)); err == nil {
+		return []string{fmt.Sprintf("%s.hocr", resultsBase)}
+	}
+
+	return pageHocrFiles(resultsBase)
+}
+
+func pageHocrFiles(resultsBase string) []string {
+	var files []string
+
+	for _, textFile := range pageTextFiles(resultsBase) {
+		hocrFile := strings.TrimSuffix(textFile, ".txt") + ".hocr"
+		if _, err := os.Stat(hocrFile); err == nil {
+			files = append(files, hocrFile)
+		}
+	}
+
+	return files
+}
+
+func getPageWords(page *hocrPage, pageNum int) wordsPageType {
+	words := wordsPageType{Page: pageNum, Width: page.BBox.X1, Height: page.BBox.Y1, Lines: []wordsLineType{}}
+
+	for _, b := range page.Blocks {
+		for _, par := range b.Pars {
+			for _, line := range par.Lines {
+				l := wordsLineType{BBox: line.BBox.coords()}
+
+				for _, w := range line.Words {
+					text := strings.TrimSpace(w.Text)
+					if text == "" {
+						continue
+					}
+
+					l.Words = append(l.Words, wordsWordType{Text: text, BBox: w.BBox.coords(), Conf: w.Conf})
+				}
+
+				if len(l.Words) > 0 {
+					words.Lines = append(words.Lines, l)
+				}
+			}
+		}
+	}
+
+	return words
+}
+
+// saveWordCoordinates extracts word coordinates from the hocr results, saving them as
+// <resultsBase>.words.json; failures are logged, as the text results are still usable
+func saveWordCoordinates(resultsBase string) []wordsPageType {
+	hocrFiles := hocrResultsFiles(resultsBase)
+	if len(hocrFiles) == 0 {
+		log.Printf("skipping word coordinates: no hocr results found")
+		return nil
+	}
+
+	var pages []wordsPageType
+
+	for i, hocrFile := range hocrFiles {
+		page, err := parseHocrFile(hocrFile)
+		if err != nil {
+			log.Printf("skipping word coordinates: %s", err.Error())
+			return nil
+		}
+
+		pages = append(pages, getPageWords(page, i+1))
+	}
+
+	wordsText, err := json.Marshal(pages)
+	if err != nil {
+		log.Printf("failed to serialize word coordinates: [%s]", err.Error())
+		return pages
+	}
+
+	if err = ioutil.WriteFile(fmt.Sprintf("%s.words.json", resultsBase), wordsText, 0644); err != nil {
+		log.Printf("failed to save word coordinates: [%s]", err.Error())
+	}
+
+	return pages
+}
+
+// removeHocrResults removes hocr results that were only produced for their word coordinates
+func removeHocrResults(resultsBase string) {
+	files := append([]string{fmt.Sprintf("%s.hocr", resultsBase)}, pageHocrFiles(resultsBase)...)
+
+	for _, base := range spreadResultsBases(resultsBase) {
+		files = append(files, fmt.Sprintf("%s.hocr", base))
+	}
+
+	for _, f := range files {
+		os.Remove(f)
+	}
+}
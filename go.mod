@@ -5,4 +5,6 @@ go 1.16
 require (
 	github.com/aws/aws-lambda-go v1.23.0
 	github.com/aws/aws-sdk-go v1.37.24
+	github.com/rivo/uniseg v0.2.0
+	golang.org/x/text v0.3.3
 )
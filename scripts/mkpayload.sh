@@ -10,6 +10,7 @@ declare -a SRCURLS=(
 	"https://github.com/libjpeg-turbo/libjpeg-turbo/archive/2.0.6.tar.gz"
 	"https://download.osgeo.org/libtiff/tiff-4.2.0.tar.gz"
 	"https://download.sourceforge.net/libpng/libpng-1.6.37.tar.gz"
+	"https://github.com/ArtifexSoftware/ghostpdl-downloads/releases/download/gs9540/ghostscript-9.54.0.tar.gz"
 )
 
 # urls for tesseract language files
@@ -182,6 +183,18 @@ function install_imagemagick_from_source ()
 	popd > /dev/null || die "popd imagemagick"
 }
 
+function install_ghostscript_from_source ()
+{
+	msg "[$FUNCNAME]"
+
+	extract_and_enter "ghostscript" "^[^/]*/configure.ac$"
+
+	./configure --prefix="$INSTALLDIR" --disable-dependency-tracking --without-x --disable-cups --disable-gtk || die "could not configure ghostscript"
+	make install || die "could not build or install ghostscript"
+
+	popd > /dev/null || die "popd ghostscript"
+}
+
 function install_libjpeg_from_source ()
 {
 	msg "[$FUNCNAME]"
@@ -251,9 +264,11 @@ function create_payload ()
 
 	cp "${INSTALLDIR}/bin/tesseract" "${DISTDIR}/bin/" || die "dist bin cp tesseract"
 	cp "${INSTALLDIR}/bin/magick" "${DISTDIR}/bin/" || die "dist bin cp magick"
+	cp "${INSTALLDIR}/bin/gs" "${DISTDIR}/bin/" || die "dist bin cp gs"
 
 	cp -R "${INSTALLDIR}/etc/ImageMagick-7" "${DISTDIR}/etc" || die "dist etc cp"
 	cp -R "${INSTALLDIR}/share/tessdata" "${DISTDIR}/share" || die "dist share cp"
+	cp -R "${INSTALLDIR}"/share/ghostscript/*/iccprofiles "${DISTDIR}/share" || die "dist share cp iccprofiles"
 
 	# copy in libraries needed by our binaries
 	while read line; do
@@ -403,6 +418,22 @@ function install_imagemagick ()
 	package_mark_installed "$pkg"
 }
 
+function install_ghostscript ()
+{
+	msg "[$FUNCNAME]"
+
+	local pkg="ghostscript"
+
+	package_already_installed "$pkg" && return
+
+	# install dependencies first
+
+	# now install
+	install_ghostscript_from_source
+
+	package_mark_installed "$pkg"
+}
+
 function install_leptonica ()
 {
 	msg "[$FUNCNAME]"
@@ -451,6 +482,7 @@ function install_dependencies ()
 
 	install_tesseract
 	install_imagemagick
+	install_ghostscript
 
 	popd > /dev/null || die "install popd"
 }